@@ -22,12 +22,22 @@ type accumulator struct {
 	maker     MetricMaker
 	metrics   chan<- telegraf.Metric
 	precision time.Duration
+
+	// timestamp, if set, is the time of metrics added without one
+	timestamp time.Time
 }
 
 func NewAccumulator(
 	maker MetricMaker,
 	metrics chan<- telegraf.Metric,
 ) telegraf.Accumulator {
+	return newAccumulator(maker, metrics)
+}
+
+func newAccumulator(
+	maker MetricMaker,
+	metrics chan<- telegraf.Metric,
+) *accumulator {
 	acc := accumulator{
 		maker:     maker,
 		metrics:   metrics,
@@ -134,6 +144,8 @@ func (ac *accumulator) getTime(t []time.Time) time.Time {
 	var timestamp time.Time
 	if len(t) > 0 {
 		timestamp = t[0]
+	} else if !ac.timestamp.IsZero() {
+		timestamp = ac.timestamp
 	} else {
 		timestamp = time.Now()
 	}
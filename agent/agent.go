@@ -18,6 +18,79 @@ import (
 // Agent runs a set of plugins.
 type Agent struct {
 	Config *config.Config
+
+	// mu guards the plugin slices of Config, which Reload replaces while the
+	// agent runs, along with pipeline and run
+	mu       sync.RWMutex
+	pipeline *pipeline
+	run      *runState
+
+	// reloadMu serializes calls to Reload
+	reloadMu sync.Mutex
+}
+
+// runState holds what is needed to start and stop individual plugins while
+// the agent runs. Its maps are only used by Run before it is published and
+// by Reload.
+type runState struct {
+	inputCtx context.Context
+	inputC   chan<- telegraf.Metric
+	inputs   map[*models.RunningInput]*task
+	inputWG  sync.WaitGroup
+
+	// procStages receives the metrics flushed from processors removed by
+	// Reload, for each stage with processors
+	procStages map[int64]chan []telegraf.Metric
+
+	aggStages map[int64]*aggStage
+	aggs      map[*models.RunningAggregator]*task
+
+	outputCtx context.Context
+	outputs   map[*models.RunningOutput]*task
+	outputWG  sync.WaitGroup
+
+	// reloads counts the calls to Reload in progress
+	reloads sync.WaitGroup
+}
+
+// aggStage is the state of the aggregators of a pipeline stage.
+type aggStage struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// aggregations receives the metrics pushed by the aggregators
+	aggregations chan telegraf.Metric
+	wg           sync.WaitGroup
+}
+
+// task is a goroutine running a single plugin.
+type task struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// startTask runs f in a goroutine counted by wg, with a context derived from
+// parent which is canceled when the task is stopped.
+func startTask(
+	parent context.Context,
+	wg *sync.WaitGroup,
+	f func(ctx context.Context),
+) *task {
+	ctx, cancel := context.WithCancel(parent)
+	t := &task{cancel: cancel, done: make(chan struct{})}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(t.done)
+		f(ctx)
+	}()
+	return t
+}
+
+// stop cancels the task and waits for it to return.
+func (t *task) stop() {
+	t.cancel()
+	<-t.done
 }
 
 // NewAgent returns an Agent for the given Config.
@@ -39,15 +112,18 @@ func (a *Agent) Run(ctx context.Context) error {
 		return ctx.Err()
 	}
 
+	p, err := newPipeline(a.Config.Processors, a.Config.Aggregators, a.Config.Outputs)
+	if err != nil {
+		return err
+	}
+
 	log.Printf("D! [agent] Connecting outputs")
-	err := a.connectOutputs(ctx)
+	err = a.connectOutputs(ctx)
 	if err != nil {
 		return err
 	}
 
 	inputC := make(chan telegraf.Metric, 100)
-	procC := make(chan telegraf.Metric, 100)
-	outputC := make(chan telegraf.Metric, 100)
 
 	startTime := time.Now()
 
@@ -57,6 +133,47 @@ func (a *Agent) Run(ctx context.Context) error {
 		return err
 	}
 
+	outputCtx, outputCancel := context.WithCancel(context.Background())
+	rs := &runState{
+		inputCtx:   ctx,
+		inputC:     inputC,
+		inputs:     make(map[*models.RunningInput]*task),
+		procStages: make(map[int64]chan []telegraf.Metric),
+		aggStages:  make(map[int64]*aggStage),
+		aggs:       make(map[*models.RunningAggregator]*task),
+		outputCtx:  outputCtx,
+		outputs:    make(map[*models.RunningOutput]*task),
+	}
+	for _, stage := range p.stages {
+		if len(stage.processors) > 0 {
+			rs.procStages[stage.id] = make(chan []telegraf.Metric)
+		}
+		if len(stage.aggregators) == 0 {
+			continue
+		}
+		aggCtx, aggCancel := context.WithCancel(context.Background())
+		rs.aggStages[stage.id] = &aggStage{
+			ctx:          aggCtx,
+			cancel:       aggCancel,
+			aggregations: make(chan telegraf.Metric, 100),
+		}
+	}
+
+	for _, input := range a.Config.Inputs {
+		a.startInput(rs, input, startTime)
+	}
+	for _, agg := range a.Config.Aggregators {
+		a.startAggregator(rs, agg, startTime)
+	}
+	for _, output := range a.Config.Outputs {
+		a.startOutput(rs, output, startTime)
+	}
+
+	a.mu.Lock()
+	a.pipeline = p
+	a.run = rs
+	a.mu.Unlock()
+
 	var wg sync.WaitGroup
 
 	src := inputC
@@ -66,7 +183,7 @@ func (a *Agent) Run(ctx context.Context) error {
 	go func(dst chan telegraf.Metric) {
 		defer wg.Done()
 
-		err := a.runInputs(ctx, startTime, dst)
+		err := a.runInputs(ctx, rs)
 		if err != nil {
 			log.Printf("E! [agent] Error running inputs: %v", err)
 		}
@@ -80,47 +197,50 @@ func (a *Agent) Run(ctx context.Context) error {
 
 	src = dst
 
-	if len(a.Config.Processors) > 0 {
-		dst = procC
+	// Reload cannot add or remove the goroutines of the stages.
+	for _, stage := range p.stages {
+		if len(stage.processors) > 0 {
+			dst = make(chan telegraf.Metric, 100)
 
-		wg.Add(1)
-		go func(src, dst chan telegraf.Metric) {
-			defer wg.Done()
+			wg.Add(1)
+			go func(id int64, src, dst chan telegraf.Metric) {
+				defer wg.Done()
 
-			err := a.runProcessors(src, dst)
-			if err != nil {
-				log.Printf("E! [agent] Error running processors: %v", err)
-			}
-			close(dst)
-			log.Printf("D! [agent] Processor channel closed")
-		}(src, dst)
+				err := a.runProcessors(rs, id, src, dst)
+				if err != nil {
+					log.Printf("E! [agent] Error running processors: %v", err)
+				}
+				close(dst)
+				log.Printf("D! [agent] Processor channel closed")
+			}(stage.id, src, dst)
 
-		src = dst
-	}
+			src = dst
+		}
 
-	if len(a.Config.Aggregators) > 0 {
-		dst = outputC
+		if len(stage.aggregators) > 0 {
+			dst = make(chan telegraf.Metric, 100)
 
-		wg.Add(1)
-		go func(src, dst chan telegraf.Metric) {
-			defer wg.Done()
+			wg.Add(1)
+			go func(id int64, src, dst chan telegraf.Metric) {
+				defer wg.Done()
 
-			err := a.runAggregators(startTime, src, dst)
-			if err != nil {
-				log.Printf("E! [agent] Error running aggregators: %v", err)
-			}
-			close(dst)
-			log.Printf("D! [agent] Output channel closed")
-		}(src, dst)
+				err := a.runAggregators(rs, id, src, dst)
+				if err != nil {
+					log.Printf("E! [agent] Error running aggregators: %v", err)
+				}
+				close(dst)
+				log.Printf("D! [agent] Aggregator channel closed")
+			}(stage.id, src, dst)
 
-		src = dst
+			src = dst
+		}
 	}
 
 	wg.Add(1)
 	go func(src chan telegraf.Metric) {
 		defer wg.Done()
 
-		err := a.runOutputs(startTime, src)
+		err := a.runOutputs(rs, outputCancel, src)
 		if err != nil {
 			log.Printf("E! [agent] Error running outputs: %v", err)
 		}
@@ -128,6 +248,8 @@ func (a *Agent) Run(ctx context.Context) error {
 
 	wg.Wait()
 
+	a.stopProcessors()
+
 	log.Printf("D! [agent] Closing outputs")
 	err = a.closeOutputs()
 	if err != nil {
@@ -212,51 +334,65 @@ func (a *Agent) Test(ctx context.Context) error {
 	return nil
 }
 
-// runInputs starts and triggers the periodic gather for Inputs.
-//
-// When the context is done the timers are stopped and this function returns
-// after all ongoing Gather calls complete.
+// runInputs waits for the context to be done, then stops reloading plugins
+// and returns after all ongoing Gather calls complete.
 func (a *Agent) runInputs(
 	ctx context.Context,
-	startTime time.Time,
-	dst chan<- telegraf.Metric,
+	rs *runState,
 ) error {
-	var wg sync.WaitGroup
-	for _, input := range a.Config.Inputs {
-		interval := a.Config.Agent.Interval.Duration
-		precision := a.Config.Agent.Precision.Duration
-		jitter := a.Config.Agent.CollectionJitter.Duration
+	<-ctx.Done()
 
-		// Overwrite agent interval if this plugin has its own.
-		if input.Config.Interval != 0 {
-			interval = input.Config.Interval
-		}
+	a.mu.Lock()
+	a.run = nil
+	a.mu.Unlock()
+	rs.reloads.Wait()
 
-		acc := NewAccumulator(input, dst)
-		acc.SetPrecision(precision, interval)
+	rs.inputWG.Wait()
+	return nil
+}
 
-		wg.Add(1)
-		go func(input *models.RunningInput) {
-			defer wg.Done()
+// startInput triggers the periodic or scheduled gather for an input until
+// the input's task is stopped or the inputs are stopped.
+func (a *Agent) startInput(
+	rs *runState,
+	input *models.RunningInput,
+	startTime time.Time,
+) {
+	interval := a.Config.Agent.Interval.Duration
+	precision := a.Config.Agent.Precision.Duration
+	jitter := a.Config.Agent.CollectionJitter.Duration
 
-			if a.Config.Agent.RoundInterval {
-				err := internal.SleepContext(
-					ctx, internal.AlignDuration(startTime, interval))
-				if err != nil {
-					return
-				}
-			}
+	// Overwrite agent interval if this plugin has its own.
+	if input.Config.Interval != 0 {
+		interval = input.Config.Interval
+	}
 
-			a.gatherOnInterval(ctx, acc, input, interval, jitter)
-		}(input)
+	acc := newAccumulator(input, rs.inputC)
+	acc.SetPrecision(precision, interval)
+
+	if input.Config.Schedule != nil {
+		rs.inputs[input] = startTask(rs.inputCtx, &rs.inputWG, func(ctx context.Context) {
+			a.gatherOnSchedule(ctx, acc, input, interval)
+		})
+		return
 	}
-	wg.Wait()
 
-	return nil
+	rs.inputs[input] = startTask(rs.inputCtx, &rs.inputWG, func(ctx context.Context) {
+		if a.Config.Agent.RoundInterval {
+			err := internal.SleepContext(
+				ctx, internal.AlignDuration(startTime, interval))
+			if err != nil {
+				return
+			}
+		}
+
+		a.gatherOnInterval(ctx, acc, input, interval, jitter)
+	})
 }
 
 // gather runs an input's gather function periodically until the context is
-// done.
+// done. A gather is never started while the last one, which may have timed
+// out, is still running; the interval is skipped instead.
 func (a *Agent) gatherOnInterval(
 	ctx context.Context,
 	acc telegraf.Accumulator,
@@ -269,16 +405,21 @@ func (a *Agent) gatherOnInterval(
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	// gathering is closed when the last gather returns
+	var gathering <-chan struct{}
+	defer func() {
+		if gathering != nil {
+			<-gathering
+		}
+	}()
+
 	for {
 		err := internal.SleepContext(ctx, internal.RandomDuration(jitter))
 		if err != nil {
 			return
 		}
 
-		err = a.gatherOnce(acc, input, interval)
-		if err != nil {
-			acc.AddError(err)
-		}
+		gathering = a.gatherIfIdle(ctx, acc, input, interval, gathering)
 
 		select {
 		case <-ticker.C:
@@ -289,25 +430,126 @@ func (a *Agent) gatherOnInterval(
 	}
 }
 
+// gatherOnSchedule runs an input's gather function at the times of its
+// schedule until the context is done. Metrics added without a time are
+// timestamped with the scheduled time. Runs missed while the last gather was
+// running are skipped.
+func (a *Agent) gatherOnSchedule(
+	ctx context.Context,
+	acc *accumulator,
+	input *models.RunningInput,
+	interval time.Duration,
+) {
+	defer panicRecover(input)
+
+	var gathering <-chan struct{}
+	defer func() {
+		if gathering != nil {
+			<-gathering
+		}
+	}()
+
+	last := time.Now()
+	for {
+		now := time.Now()
+		if now.Before(last) {
+			now = last
+		}
+		next := input.Config.Schedule.Next(now)
+		if next.IsZero() {
+			log.Printf("E! [agent] schedule of input %q has no more runs", input.Name())
+			return
+		}
+
+		err := internal.SleepContext(ctx, time.Until(next))
+		if err != nil {
+			return
+		}
+		last = next
+
+		// The accumulator is still used by the last gather until it returns.
+		select {
+		case <-gathering:
+			gathering = nil
+		default:
+		}
+		if gathering == nil {
+			acc.timestamp = next
+		}
+		gathering = a.gatherIfIdle(ctx, acc, input, interval, gathering)
+	}
+}
+
+// gatherIfIdle runs the input's gather function once, unless the last
+// gather, which is running until gathering is closed, has not returned. It
+// returns the channel which is closed once the gather it started returns, or
+// nil if it has.
+func (a *Agent) gatherIfIdle(
+	ctx context.Context,
+	acc telegraf.Accumulator,
+	input *models.RunningInput,
+	interval time.Duration,
+	gathering <-chan struct{},
+) <-chan struct{} {
+	select {
+	case <-gathering:
+		gathering = nil
+	default:
+	}
+
+	if gathering != nil {
+		input.GathersSkipped.Incr(1)
+		log.Printf("W! [agent] input %q is still gathering, skipping this interval",
+			input.Name())
+		return gathering
+	}
+
+	gathering, err := a.gatherOnce(ctx, acc, input, interval)
+	if err != nil {
+		acc.AddError(err)
+	}
+	return gathering
+}
+
 // gatherOnce runs the input's Gather function once, logging a warning each
-// interval it fails to complete before.
+// interval it fails to complete before. If the input has a gather_timeout,
+// the gather's context is canceled once it passes and gatherOnce returns
+// without waiting for the gather. The returned channel is then closed when
+// the gather returns, and is nil otherwise.
 func (a *Agent) gatherOnce(
+	ctx context.Context,
 	acc telegraf.Accumulator,
 	input *models.RunningInput,
-	timeout time.Duration,
-) error {
-	ticker := time.NewTicker(timeout)
+	interval time.Duration,
+) (<-chan struct{}, error) {
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	done := make(chan error)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var timeout <-chan time.Time
+	if input.Config.GatherTimeout > 0 {
+		timer := time.NewTimer(input.Config.GatherTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	gathering := make(chan struct{})
+	done := make(chan error, 1)
 	go func() {
-		done <- input.Gather(acc)
+		defer close(gathering)
+		done <- input.GatherContext(ctx, acc)
 	}()
 
 	for {
 		select {
 		case err := <-done:
-			return err
+			return nil, err
+		case <-timeout:
+			input.GatherTimeouts.Incr(1)
+			return gathering, fmt.Errorf("input %q did not complete within its gather_timeout of %s",
+				input.Name(), input.Config.GatherTimeout)
 		case <-ticker.C:
 			log.Printf("W! [agent] input %q did not complete within its interval",
 				input.Name())
@@ -315,50 +557,125 @@ func (a *Agent) gatherOnce(
 	}
 }
 
-// runProcessors applies processors to metrics.
+// releaseInterval is how often processors holding metrics back are asked to
+// release them.
+const releaseInterval = 100 * time.Millisecond
+
+// runProcessors applies the processors of a stage to metrics, and sends on
+// the metrics they release.
+//
+// When the source is closed the metrics still held by the processors are
+// flushed before returning.
 func (a *Agent) runProcessors(
+	rs *runState,
+	stage int64,
 	src <-chan telegraf.Metric,
 	agg chan<- telegraf.Metric,
 ) error {
-	for metric := range src {
-		metrics := a.applyProcessors(metric)
+	ticker := time.NewTicker(releaseInterval)
+	defer ticker.Stop()
+
+	for {
+		var metrics []telegraf.Metric
+		select {
+		case metric, ok := <-src:
+			if !ok {
+				for _, metric := range releaseHeld(a.stageProcessors(stage), true) {
+					agg <- metric
+				}
+				return nil
+			}
+			metrics = a.applyProcessors(stage, metric)
+		case <-ticker.C:
+			metrics = releaseHeld(a.stageProcessors(stage), false)
+		case metrics = <-rs.procStages[stage]:
+		}
 
 		for _, metric := range metrics {
 			agg <- metric
 		}
 	}
+}
 
-	return nil
+// stageProcessors returns the processors of a stage.
+func (a *Agent) stageProcessors(stage int64) []*models.RunningProcessor {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.pipeline.stage(stage).processors
+}
+
+// applyProcessors applies the processors of a stage to a metric.
+func (a *Agent) applyProcessors(stage int64, m telegraf.Metric) []telegraf.Metric {
+	return applyProcessors(a.stageProcessors(stage), m)
 }
 
-// applyProcessors applies all processors to a metric.
-func (a *Agent) applyProcessors(m telegraf.Metric) []telegraf.Metric {
+// applyProcessors applies processors to a metric in order.
+func applyProcessors(processors []*models.RunningProcessor, m telegraf.Metric) []telegraf.Metric {
 	metrics := []telegraf.Metric{m}
-	for _, processor := range a.Config.Processors {
+	for _, processor := range processors {
 		metrics = processor.Apply(metrics...)
 	}
 
 	return metrics
 }
 
-// runAggregators triggers the periodic push for Aggregators.
+// releaseHeld collects the metrics released by the processors which hold
+// metrics back, or all their held metrics if flush is set, and applies the
+// processors following each to them.
+func releaseHeld(processors []*models.RunningProcessor, flush bool) []telegraf.Metric {
+	var metrics []telegraf.Metric
+	for i, processor := range processors {
+		held := flushProcessor(processor, flush)
+		for _, m := range held {
+			metrics = append(metrics, applyProcessors(processors[i+1:], m)...)
+		}
+	}
+	return metrics
+}
+
+// flushProcessor returns the metrics released by a processor which holds
+// metrics back, or all its held metrics if flush is set.
+func flushProcessor(processor *models.RunningProcessor, flush bool) []telegraf.Metric {
+	hp, ok := processor.Processor.(telegraf.HoldingProcessor)
+	if !ok {
+		return nil
+	}
+
+	processor.Lock()
+	defer processor.Unlock()
+	if flush {
+		return hp.Flush()
+	}
+	return hp.Release()
+}
+
+// runAggregators adds metrics to the aggregators of a stage and sends their
+// aggregations on, after applying the stage's processors to them.
 //
-// When the context is done a final push will occur and then this function
-// will return.
+// When the source is closed the stage's aggregators push a final time, and
+// this function returns once they have and the metrics which the stage's
+// processors held back from the aggregations have been flushed.
 func (a *Agent) runAggregators(
-	startTime time.Time,
+	rs *runState,
+	stage int64,
 	src <-chan telegraf.Metric,
 	dst chan<- telegraf.Metric,
 ) error {
-	ctx, cancel := context.WithCancel(context.Background())
+	as := rs.aggStages[stage]
 
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		for metric := range src {
+			// The lock is not held while adding, as Add waits for pushes
+			// which wait for the aggregations to be processed.
+			a.mu.RLock()
+			aggregators := a.pipeline.stage(stage).aggregators
+			a.mu.RUnlock()
+
 			var dropOriginal bool
-			for _, agg := range a.Config.Aggregators {
+			for _, agg := range aggregators {
 				if ok := agg.Add(metric); ok {
 					dropOriginal = true
 				}
@@ -368,46 +685,55 @@ func (a *Agent) runAggregators(
 				dst <- metric
 			}
 		}
-		cancel()
-	}()
+		as.cancel()
 
-	precision := a.Config.Agent.Precision.Duration
-	interval := a.Config.Agent.Interval.Duration
-	aggregations := make(chan telegraf.Metric, 100)
-	for _, agg := range a.Config.Aggregators {
-		wg.Add(1)
-		go func(agg *models.RunningAggregator) {
-			defer wg.Done()
-
-			if a.Config.Agent.RoundInterval {
-				// Aggregators are aligned to the agent interval regardless of
-				// their period.
-				err := internal.SleepContext(ctx, internal.AlignDuration(startTime, interval))
-				if err != nil {
-					return
-				}
-			}
-
-			agg.SetPeriodStart(startTime)
-
-			acc := NewAccumulator(agg, aggregations)
-			acc.SetPrecision(precision, interval)
-			a.push(ctx, agg, acc)
-			close(aggregations)
-		}(agg)
-	}
+		// No aggregators are started once the inputs have stopped.
+		as.wg.Wait()
+		close(as.aggregations)
+	}()
 
-	for metric := range aggregations {
-		metrics := a.applyProcessors(metric)
+	for metric := range as.aggregations {
+		metrics := a.applyProcessors(stage, metric)
 		for _, metric := range metrics {
 			dst <- metric
 		}
 	}
+	for _, metric := range releaseHeld(a.stageProcessors(stage), true) {
+		dst <- metric
+	}
 
 	wg.Wait()
 	return nil
 }
 
+// startAggregator triggers the periodic push for an aggregator.
+func (a *Agent) startAggregator(
+	rs *runState,
+	agg *models.RunningAggregator,
+	startTime time.Time,
+) {
+	precision := a.Config.Agent.Precision.Duration
+	interval := a.Config.Agent.Interval.Duration
+	as := rs.aggStages[agg.Config.Stage]
+
+	rs.aggs[agg] = startTask(as.ctx, &as.wg, func(ctx context.Context) {
+		if a.Config.Agent.RoundInterval {
+			// Aggregators are aligned to the agent interval regardless of
+			// their period.
+			err := internal.SleepContext(ctx, internal.AlignDuration(startTime, interval))
+			if err != nil {
+				return
+			}
+		}
+
+		agg.SetPeriodStart(startTime)
+
+		acc := NewAccumulator(agg, as.aggregations)
+		acc.SetPrecision(precision, interval)
+		a.push(ctx, agg, acc)
+	})
+}
+
 // push runs the push for a single aggregator every period.  More simple than
 // the output/input version as timeout should be less likely.... not really
 // because the output channel can block for now.
@@ -432,58 +758,100 @@ func (a *Agent) push(
 	}
 }
 
-// runOutputs triggers the periodic write for Outputs.
+// runOutputs adds metrics to the outputs, after applying the processors of
+// each output to them, along with the metrics those processors release.
 //
-// When the context is done, outputs continue to run until their buffer is
-// closed, afterwich they run flush once more.
+// When the source is closed the metrics still held by the processors are
+// flushed and cancel is called, which makes the outputs flush once more, and
+// this function returns once they have.
 func (a *Agent) runOutputs(
-	startTime time.Time,
+	rs *runState,
+	cancel context.CancelFunc,
 	src <-chan telegraf.Metric,
 ) error {
-	interval := a.Config.Agent.FlushInterval.Duration
-	jitter := a.Config.Agent.FlushJitter.Duration
-
-	ctx, cancel := context.WithCancel(context.Background())
+	ticker := time.NewTicker(releaseInterval)
+	defer ticker.Stop()
 
-	var wg sync.WaitGroup
-	for _, output := range a.Config.Outputs {
-		interval := interval
-		// Overwrite agent flush_interval if this plugin has its own.
-		if output.Config.FlushInterval != 0 {
-			interval = output.Config.FlushInterval
+loop:
+	for {
+		select {
+		case metric, ok := <-src:
+			if !ok {
+				break loop
+			}
+			a.addToOutputs(metric)
+		case <-ticker.C:
+			a.releaseToOutputs(false)
 		}
+	}
+	a.releaseToOutputs(true)
 
-		wg.Add(1)
-		go func(output *models.RunningOutput) {
-			defer wg.Done()
+	log.Println("I! [agent] Hang on, flushing any cached metrics before shutdown")
+	cancel()
+	rs.outputWG.Wait()
 
-			if a.Config.Agent.RoundInterval {
-				err := internal.SleepContext(
-					ctx, internal.AlignDuration(startTime, interval))
-				if err != nil {
-					return
-				}
-			}
+	return nil
+}
 
-			a.flush(ctx, output, interval, jitter)
-		}(output)
+// addToOutputs adds a metric to every output, after applying the processors
+// of the output to it.
+func (a *Agent) addToOutputs(metric telegraf.Metric) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for i, output := range a.Config.Outputs {
+		m := metric
+		if i != len(a.Config.Outputs)-1 {
+			m = metric.Copy()
+		}
+
+		processors, ok := a.pipeline.outputProcessors[output]
+		if !ok {
+			output.AddMetric(m)
+			continue
+		}
+		for _, m := range applyProcessors(processors, m) {
+			output.AddMetric(m)
+		}
 	}
+}
 
-	for metric := range src {
-		for i, output := range a.Config.Outputs {
-			if i == len(a.Config.Outputs)-1 {
-				output.AddMetric(metric)
-			} else {
-				output.AddMetric(metric.Copy())
-			}
+// releaseToOutputs adds the metrics released by the processors of each
+// output to the output, or all their held metrics if flush is set.
+func (a *Agent) releaseToOutputs(flush bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for output, processors := range a.pipeline.outputProcessors {
+		for _, m := range releaseHeld(processors, flush) {
+			output.AddMetric(m)
 		}
 	}
+}
 
-	log.Println("I! [agent] Hang on, flushing any cached metrics before shutdown")
-	cancel()
-	wg.Wait()
+// startOutput triggers the periodic write for an output.
+func (a *Agent) startOutput(
+	rs *runState,
+	output *models.RunningOutput,
+	startTime time.Time,
+) {
+	interval := a.Config.Agent.FlushInterval.Duration
+	jitter := a.Config.Agent.FlushJitter.Duration
 
-	return nil
+	// Overwrite agent flush_interval if this plugin has its own.
+	if output.Config.FlushInterval != 0 {
+		interval = output.Config.FlushInterval
+	}
+
+	rs.outputs[output] = startTask(rs.outputCtx, &rs.outputWG, func(ctx context.Context) {
+		if a.Config.Agent.RoundInterval {
+			// If the output is stopped while aligning, flush will write its
+			// buffer once before returning.
+			internal.SleepContext(ctx, internal.AlignDuration(startTime, interval))
+		}
+
+		a.flush(ctx, output, interval, jitter)
+	})
 }
 
 // flush runs an output's flush function periodically until the context is
@@ -561,80 +929,127 @@ func (a *Agent) flushOnce(
 
 }
 
-// connectOutputs connects to all outputs.
+// connectOutputs opens the buffers of all outputs and connects to them.
 func (a *Agent) connectOutputs(ctx context.Context) error {
 	for _, output := range a.Config.Outputs {
-		log.Printf("D! [agent] Attempting connection to output: %s\n", output.Name)
-		err := output.Output.Connect()
-		if err != nil {
-			log.Printf("E! [agent] Failed to connect to output %s, retrying in 15s, "+
-				"error was '%s' \n", output.Name, err)
+		if err := output.InitBuffer(); err != nil {
+			return err
+		}
 
-			err := internal.SleepContext(ctx, 15*time.Second)
-			if err != nil {
-				return err
-			}
+		if err := a.connectOutput(ctx, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-			err = output.Output.Connect()
-			if err != nil {
-				return err
-			}
+// connectOutput connects to an output, retrying once if it fails.
+func (a *Agent) connectOutput(ctx context.Context, output *models.RunningOutput) error {
+	log.Printf("D! [agent] Attempting connection to output: %s\n", output.Name)
+	err := output.Output.Connect()
+	if err != nil {
+		log.Printf("E! [agent] Failed to connect to output %s, retrying in 15s, "+
+			"error was '%s' \n", output.Name, err)
+
+		err := internal.SleepContext(ctx, 15*time.Second)
+		if err != nil {
+			return err
+		}
+
+		err = output.Output.Connect()
+		if err != nil {
+			return err
 		}
-		log.Printf("D! [agent] Successfully connected to output: %s\n", output.Name)
 	}
+	log.Printf("D! [agent] Successfully connected to output: %s\n", output.Name)
 	return nil
 }
 
-// closeOutputs closes all outputs.
+// closeOutputs closes all outputs and their buffers.
 func (a *Agent) closeOutputs() error {
 	var err error
 	for _, output := range a.Config.Outputs {
-		err = output.Output.Close()
+		err = output.Close()
 	}
 	return err
 }
 
+// stopProcessors stops all processors which hold resources.
+func (a *Agent) stopProcessors() {
+	for _, processor := range a.Config.Processors {
+		stopProcessor(processor)
+	}
+}
+
+// stopProcessor stops a processor if it holds resources.
+func stopProcessor(processor *models.RunningProcessor) {
+	if sp, ok := processor.Processor.(telegraf.StoppingProcessor); ok {
+		processor.Lock()
+		defer processor.Unlock()
+		sp.Stop()
+	}
+}
+
 // startServiceInputs starts all service inputs.
 func (a *Agent) startServiceInputs(
 	ctx context.Context,
 	dst chan<- telegraf.Metric,
 ) error {
-	started := []telegraf.ServiceInput{}
+	started := []*models.RunningInput{}
 
 	for _, input := range a.Config.Inputs {
-		if si, ok := input.Input.(telegraf.ServiceInput); ok {
-			// Service input plugins are not subject to timestamp rounding.
-			// This only applies to the accumulator passed to Start(), the
-			// Gather() accumulator does apply rounding according to the
-			// precision agent setting.
-			acc := NewAccumulator(input, dst)
-			acc.SetPrecision(time.Nanosecond, 0)
-
-			err := si.Start(acc)
-			if err != nil {
-				log.Printf("E! [agent] Service for input %s failed to start: %v",
-					input.Name(), err)
-
-				for _, si := range started {
-					si.Stop()
-				}
-
-				return err
+		err := a.startServiceInput(input, dst)
+		if err != nil {
+			for _, input := range started {
+				stopServiceInput(input)
 			}
 
-			started = append(started, si)
+			return err
 		}
+
+		started = append(started, input)
 	}
 
 	return nil
 }
 
+// startServiceInput starts an input if it is a service input.
+func (a *Agent) startServiceInput(
+	input *models.RunningInput,
+	dst chan<- telegraf.Metric,
+) error {
+	si, ok := input.Input.(telegraf.ServiceInput)
+	if !ok {
+		return nil
+	}
+
+	// Service input plugins are not subject to timestamp rounding.
+	// This only applies to the accumulator passed to Start(), the
+	// Gather() accumulator does apply rounding according to the
+	// precision agent setting.
+	acc := NewAccumulator(input, dst)
+	acc.SetPrecision(time.Nanosecond, 0)
+
+	err := si.Start(acc)
+	if err != nil {
+		log.Printf("E! [agent] Service for input %s failed to start: %v",
+			input.Name(), err)
+		return err
+	}
+	return nil
+}
+
 // stopServiceInputs stops all service inputs.
 func (a *Agent) stopServiceInputs() {
 	for _, input := range a.Config.Inputs {
-		if si, ok := input.Input.(telegraf.ServiceInput); ok {
-			si.Stop()
-		}
+		stopServiceInput(input)
+	}
+}
+
+// stopServiceInput stops an input if it is a service input.
+func stopServiceInput(input *models.RunningInput) {
+	if si, ok := input.Input.(telegraf.ServiceInput); ok {
+		si.Stop()
 	}
 }
 
@@ -1,9 +1,14 @@
 package agent
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/testutil"
 
 	// needing to load the plugins
 	_ "github.com/influxdata/telegraf/plugins/inputs/all"
@@ -109,3 +114,110 @@ func TestAgent_LoadOutput(t *testing.T) {
 	a, _ = NewAgent(c)
 	assert.Equal(t, 3, len(a.Config.Outputs))
 }
+
+// hangingInput gathers until its context is canceled, if it is a context
+// input, or until it is released.
+type hangingInput struct {
+	release chan struct{}
+}
+
+func (i *hangingInput) SampleConfig() string { return "" }
+func (i *hangingInput) Description() string  { return "" }
+
+func (i *hangingInput) Gather(acc telegraf.Accumulator) error {
+	<-i.release
+	return nil
+}
+
+type hangingContextInput struct {
+	hangingInput
+}
+
+func (i *hangingContextInput) GatherContext(ctx context.Context, acc telegraf.Accumulator) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestAgent_GatherTimeout(t *testing.T) {
+	a, _ := NewAgent(config.NewConfig())
+	input := models.NewRunningInput(&hangingContextInput{},
+		&models.InputConfig{Name: "gather_timeout", GatherTimeout: 10 * time.Millisecond})
+
+	var acc testutil.Accumulator
+	gathering, err := a.gatherOnce(context.Background(), &acc, input, time.Second)
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), input.GatherTimeouts.Get())
+
+	// The gather's context is canceled
+	select {
+	case <-gathering:
+	case <-time.After(time.Second):
+		t.Fatal("gather was not canceled")
+	}
+}
+
+func TestAgent_GatherSkipsOverlap(t *testing.T) {
+	a, _ := NewAgent(config.NewConfig())
+	hanging := &hangingInput{release: make(chan struct{})}
+	input := models.NewRunningInput(hanging,
+		&models.InputConfig{Name: "gather_overlap", GatherTimeout: 10 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var acc testutil.Accumulator
+		a.gatherOnInterval(ctx, &acc, input, 20*time.Millisecond, 0)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for input.GathersSkipped.Get() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for skipped gathers")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Only the first gather timed out, as no other gather was started
+	assert.Equal(t, int64(1), input.GatherTimeouts.Get())
+
+	close(hanging.release)
+	cancel()
+	<-done
+}
+
+// everyPeriod is a schedule running at each multiple of a period.
+type everyPeriod time.Duration
+
+func (p everyPeriod) Next(t time.Time) time.Time {
+	return t.Truncate(time.Duration(p)).Add(time.Duration(p))
+}
+
+func TestAgent_GatherOnSchedule(t *testing.T) {
+	a, _ := NewAgent(config.NewConfig())
+	period := 50 * time.Millisecond
+	input := models.NewRunningInput(&mockServiceInput{},
+		&models.InputConfig{Name: "gather_schedule", Schedule: everyPeriod(period)})
+
+	metrics := make(chan telegraf.Metric, 10)
+	acc := newAccumulator(input, metrics)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.gatherOnSchedule(ctx, acc, input, time.Second)
+	}()
+
+	// Metrics are timestamped with the scheduled time
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-metrics:
+			assert.Equal(t, m.Time().Truncate(period), m.Time())
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for metrics")
+		}
+	}
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/models"
+)
+
+// pipeline is the graph of processors and aggregators between the inputs and
+// the outputs.
+//
+// Processors and aggregators are grouped into stages by their stage setting,
+// and metrics pass through the stages from the lowest to the highest. Within
+// a stage, metrics pass through the processors in order and then through the
+// aggregators, whose aggregations pass through the stage's processors again
+// before moving on to the next stage.
+//
+// Processors referenced by an output are not part of any stage, and only
+// process the metrics of the outputs referencing them. A processor which
+// holds metrics back can only be referenced by one output, which its held
+// metrics are released to.
+type pipeline struct {
+	stages []*stage
+	// outputProcessors holds the processors of each output which has any
+	outputProcessors map[*models.RunningOutput][]*models.RunningProcessor
+}
+
+type stage struct {
+	id          int64
+	processors  []*models.RunningProcessor
+	aggregators []*models.RunningAggregator
+}
+
+// newPipeline builds the pipeline of the plugins. The processors must be
+// sorted by order.
+func newPipeline(
+	processors []*models.RunningProcessor,
+	aggregators []*models.RunningAggregator,
+	outputs []*models.RunningOutput,
+) (*pipeline, error) {
+	p := &pipeline{
+		outputProcessors: make(map[*models.RunningOutput][]*models.RunningProcessor),
+	}
+
+	referenced := make(map[*models.RunningProcessor]bool)
+	for _, output := range outputs {
+		for _, name := range output.Config.Processors {
+			processor, err := findProcessor(processors, name)
+			if err != nil {
+				return nil, fmt.Errorf("output %s: %s", output.Name, err)
+			}
+			if _, ok := processor.Processor.(telegraf.HoldingProcessor); ok && referenced[processor] {
+				return nil, fmt.Errorf("output %s: processor %q holds metrics back and is already used by another output",
+					output.Name, name)
+			}
+			p.outputProcessors[output] = append(p.outputProcessors[output], processor)
+			referenced[processor] = true
+		}
+	}
+
+	stages := make(map[int64]*stage)
+	stageOf := func(id int64) *stage {
+		s, ok := stages[id]
+		if !ok {
+			s = &stage{id: id}
+			stages[id] = s
+			p.stages = append(p.stages, s)
+		}
+		return s
+	}
+	for _, processor := range processors {
+		if !referenced[processor] {
+			s := stageOf(processor.Config.Stage)
+			s.processors = append(s.processors, processor)
+		}
+	}
+	for _, agg := range aggregators {
+		s := stageOf(agg.Config.Stage)
+		s.aggregators = append(s.aggregators, agg)
+	}
+
+	sort.Slice(p.stages, func(i, j int) bool { return p.stages[i].id < p.stages[j].id })
+	return p, nil
+}
+
+// findProcessor returns the processor with the given alias, or with the given
+// plugin name if it has no alias.
+func findProcessor(processors []*models.RunningProcessor, name string) (*models.RunningProcessor, error) {
+	var found *models.RunningProcessor
+	for _, processor := range processors {
+		alias := processor.Config.Alias
+		if alias == name || (alias == "" && processor.Name == name) {
+			if found != nil {
+				return nil, fmt.Errorf("processor %q is ambiguous, give it an alias", name)
+			}
+			found = processor
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("undefined processor %q", name)
+	}
+	return found, nil
+}
+
+// stage returns the stage with the given id, which is empty if the pipeline
+// has no such stage.
+func (p *pipeline) stage(id int64) *stage {
+	for _, s := range p.stages {
+		if s.id == id {
+			return s
+		}
+	}
+	return &stage{id: id}
+}
+
+// sameLayout returns whether the pipelines have the same stages, each with
+// processors and aggregators if the other has them, so that the goroutines
+// running one can run the other.
+func (p *pipeline) sameLayout(other *pipeline) bool {
+	if len(p.stages) != len(other.stages) {
+		return false
+	}
+	for i, s := range p.stages {
+		o := other.stages[i]
+		if s.id != o.id ||
+			(len(s.processors) > 0) != (len(o.processors) > 0) ||
+			(len(s.aggregators) > 0) != (len(o.aggregators) > 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// owner returns the stage, or otherwise the output, whose metrics the
+// processor processes. Both are nil if the processor is not in the pipeline.
+func (p *pipeline) owner(processor *models.RunningProcessor) (*stage, *models.RunningOutput) {
+	for _, s := range p.stages {
+		for _, sp := range s.processors {
+			if sp == processor {
+				return s, nil
+			}
+		}
+	}
+	for output, processors := range p.outputProcessors {
+		for _, op := range processors {
+			if op == processor {
+				return nil, output
+			}
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,250 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type prefixProcessor struct {
+	prefix string
+}
+
+func (p *prefixProcessor) SampleConfig() string { return "" }
+func (p *prefixProcessor) Description() string  { return "" }
+
+func (p *prefixProcessor) Apply(in ...telegraf.Metric) []telegraf.Metric {
+	for _, m := range in {
+		m.AddPrefix(p.prefix)
+	}
+	return in
+}
+
+// holdingProcessor holds back every metric until it is released.
+type holdingProcessor struct {
+	held    []telegraf.Metric
+	release bool
+}
+
+func (p *holdingProcessor) SampleConfig() string { return "" }
+func (p *holdingProcessor) Description() string  { return "" }
+
+func (p *holdingProcessor) Apply(in ...telegraf.Metric) []telegraf.Metric {
+	p.held = append(p.held, in...)
+	return nil
+}
+
+func (p *holdingProcessor) Release() []telegraf.Metric {
+	if !p.release {
+		return nil
+	}
+	return p.Flush()
+}
+
+func (p *holdingProcessor) Flush() []telegraf.Metric {
+	held := p.held
+	p.held = nil
+	return held
+}
+
+type recordingOutput struct {
+	sync.Mutex
+	names []string
+}
+
+func (o *recordingOutput) SampleConfig() string { return "" }
+func (o *recordingOutput) Description() string  { return "" }
+func (o *recordingOutput) Connect() error       { return nil }
+func (o *recordingOutput) Close() error         { return nil }
+
+func (o *recordingOutput) Write(metrics []telegraf.Metric) error {
+	o.Lock()
+	defer o.Unlock()
+	for _, m := range metrics {
+		o.names = append(o.names, m.Name())
+	}
+	return nil
+}
+
+func newProcessor(name, alias string, stage int64) *models.RunningProcessor {
+	return &models.RunningProcessor{
+		Name:      name,
+		Processor: &prefixProcessor{prefix: name + "_"},
+		Config:    &models.ProcessorConfig{Name: name, Alias: alias, Stage: stage},
+	}
+}
+
+func newAggregator(name string, stage int64) *models.RunningAggregator {
+	return &models.RunningAggregator{
+		Config: &models.AggregatorConfig{Name: name, Stage: stage},
+	}
+}
+
+func newOutput(name string, processors ...string) *models.RunningOutput {
+	return models.NewRunningOutput(name, &recordingOutput{},
+		&models.OutputConfig{Name: name, Processors: processors}, 0, 0)
+}
+
+func TestNewPipeline(t *testing.T) {
+	rename := newProcessor("rename", "", 1)
+	enum := newProcessor("enum", "", 0)
+	regexA := newProcessor("regex", "a", 0)
+	regexB := newProcessor("regex", "b", 0)
+	minmax := newAggregator("minmax", 0)
+	basicstats := newAggregator("basicstats", 1)
+	influxdb := newOutput("influxdb", "b", "a")
+	file := newOutput("file")
+
+	p, err := newPipeline(
+		[]*models.RunningProcessor{enum, regexA, regexB, rename},
+		[]*models.RunningAggregator{basicstats, minmax},
+		[]*models.RunningOutput{influxdb, file})
+	require.NoError(t, err)
+
+	// Referenced processors only process the metrics of their outputs
+	require.Len(t, p.stages, 2)
+	assert.Equal(t, int64(0), p.stages[0].id)
+	assert.Equal(t, []*models.RunningProcessor{enum}, p.stages[0].processors)
+	assert.Equal(t, []*models.RunningAggregator{minmax}, p.stages[0].aggregators)
+	assert.Equal(t, int64(1), p.stages[1].id)
+	assert.Equal(t, []*models.RunningProcessor{rename}, p.stages[1].processors)
+	assert.Equal(t, []*models.RunningAggregator{basicstats}, p.stages[1].aggregators)
+
+	assert.Equal(t, []*models.RunningProcessor{regexB, regexA}, p.outputProcessors[influxdb])
+	_, ok := p.outputProcessors[file]
+	assert.False(t, ok)
+
+	assert.Empty(t, p.stage(2).processors)
+}
+
+func TestNewPipeline_Errors(t *testing.T) {
+	processors := []*models.RunningProcessor{
+		newProcessor("regex", "", 0),
+		newProcessor("regex", "", 0),
+		newProcessor("enum", "", 0),
+	}
+
+	_, err := newPipeline(processors, nil,
+		[]*models.RunningOutput{newOutput("file", "regex")})
+	assert.Error(t, err)
+
+	_, err = newPipeline(processors, nil,
+		[]*models.RunningOutput{newOutput("file", "rename")})
+	assert.Error(t, err)
+
+	_, err = newPipeline(processors, nil,
+		[]*models.RunningOutput{newOutput("file", "enum")})
+	assert.NoError(t, err)
+}
+
+func TestNewPipeline_SharedHoldingProcessor(t *testing.T) {
+	hold := &models.RunningProcessor{
+		Name:      "hold",
+		Processor: &holdingProcessor{},
+		Config:    &models.ProcessorConfig{Name: "hold"},
+	}
+	processors := []*models.RunningProcessor{hold}
+
+	_, err := newPipeline(processors, nil,
+		[]*models.RunningOutput{newOutput("file", "hold")})
+	assert.NoError(t, err)
+
+	_, err = newPipeline(processors, nil,
+		[]*models.RunningOutput{newOutput("file", "hold"), newOutput("influxdb", "hold")})
+	assert.Error(t, err)
+}
+
+func TestPipeline_SameLayout(t *testing.T) {
+	p, err := newPipeline(
+		[]*models.RunningProcessor{newProcessor("enum", "", 0)},
+		[]*models.RunningAggregator{newAggregator("minmax", 1)},
+		nil)
+	require.NoError(t, err)
+
+	same, err := newPipeline(
+		[]*models.RunningProcessor{newProcessor("rename", "", 0)},
+		[]*models.RunningAggregator{newAggregator("basicstats", 1), newAggregator("minmax", 1)},
+		nil)
+	require.NoError(t, err)
+	assert.True(t, p.sameLayout(same))
+
+	other, err := newPipeline(
+		[]*models.RunningProcessor{newProcessor("enum", "", 2)},
+		[]*models.RunningAggregator{newAggregator("minmax", 1)},
+		nil)
+	require.NoError(t, err)
+	assert.False(t, p.sameLayout(other))
+}
+
+func TestAgent_OutputProcessors(t *testing.T) {
+	c := config.NewConfig()
+	c.Processors = models.RunningProcessors{newProcessor("first", "", 0), newProcessor("second", "", 0)}
+	c.Outputs = []*models.RunningOutput{newOutput("a", "second", "first"), newOutput("b")}
+	a, err := NewAgent(c)
+	require.NoError(t, err)
+	a.pipeline, err = newPipeline(c.Processors, c.Aggregators, c.Outputs)
+	require.NoError(t, err)
+
+	src := make(chan telegraf.Metric, 1)
+	src <- testutil.TestMetric(1, "cpu")
+	close(src)
+	_, cancel := context.WithCancel(context.Background())
+	require.NoError(t, a.runOutputs(&runState{}, cancel, src))
+
+	for _, output := range c.Outputs {
+		require.NoError(t, output.Write())
+	}
+	assert.Equal(t, []string{"first_second_cpu"}, c.Outputs[0].Output.(*recordingOutput).names)
+	assert.Equal(t, []string{"cpu"}, c.Outputs[1].Output.(*recordingOutput).names)
+}
+
+func TestAgent_ReleaseHeld(t *testing.T) {
+	holder := &holdingProcessor{}
+	c := config.NewConfig()
+	c.Processors = models.RunningProcessors{
+		{Name: "hold", Processor: holder, Config: &models.ProcessorConfig{Name: "hold"}},
+		newProcessor("after", "", 0),
+	}
+	a, err := NewAgent(c)
+	require.NoError(t, err)
+	a.pipeline, err = newPipeline(c.Processors, c.Aggregators, c.Outputs)
+	require.NoError(t, err)
+
+	src := make(chan telegraf.Metric)
+	dst := make(chan telegraf.Metric, 10)
+	done := make(chan error)
+	go func() {
+		done <- a.runProcessors(&runState{}, 0, src, dst)
+	}()
+
+	// Released metrics pass through the following processors without
+	// further metrics arriving
+	src <- testutil.TestMetric(1, "released")
+	c.Processors[0].Lock()
+	holder.release = true
+	c.Processors[0].Unlock()
+	select {
+	case m := <-dst:
+		assert.Equal(t, "after_released", m.Name())
+	case <-time.After(5 * time.Second):
+		t.Fatal("held metric was not released")
+	}
+
+	// Metrics still held are flushed once the source is closed
+	c.Processors[0].Lock()
+	holder.release = false
+	c.Processors[0].Unlock()
+	src <- testutil.TestMetric(1, "flushed")
+	close(src)
+	require.NoError(t, <-done)
+	require.Len(t, dst, 1)
+	assert.Equal(t, "after_flushed", (<-dst).Name())
+}
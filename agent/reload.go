@@ -0,0 +1,355 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/models"
+)
+
+// ErrRestartRequired is returned by Reload when a configuration cannot be
+// applied to the running agent, which must be restarted instead.
+var ErrRestartRequired = errors.New("configuration cannot be reloaded without a restart")
+
+// ReloadResult counts the plugins started, stopped and kept running by a
+// reload. A changed plugin is both stopped and started.
+type ReloadResult struct {
+	Started   int
+	Stopped   int
+	Unchanged int
+}
+
+// Reload applies a new configuration to the running agent. Plugins are
+// matched by the digest of their tables: only removed and changed plugins are
+// stopped, and only added and changed plugins are started, while unchanged
+// plugins keep running along with their buffers.
+//
+// ErrRestartRequired is returned, and nothing changes, if the agent is not
+// running or if the agent settings, the global tags or the layout of the
+// pipeline differ, that is the stages and whether each has processors and
+// aggregators. If the pipeline is invalid nothing changes either. If a new
+// plugin fails to start, or a new output fails to connect, the rest of the
+// configuration is applied without it and the error is returned.
+//
+// Removed and changed plugins are stopped, and outputs closed, before new
+// plugins are started, as a changed plugin may need the same port, buffer
+// directory or connection as its old instance.
+func (a *Agent) Reload(ctx context.Context, c *config.Config) (ReloadResult, error) {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	a.mu.Lock()
+	rs := a.run
+	if rs != nil {
+		rs.reloads.Add(1)
+	}
+	a.mu.Unlock()
+	if rs == nil {
+		return ReloadResult{}, ErrRestartRequired
+	}
+	defer rs.reloads.Done()
+
+	if !reloadable(a.Config, c) {
+		return ReloadResult{}, ErrRestartRequired
+	}
+	next, err := newPipeline(c.Processors, c.Aggregators, c.Outputs)
+	if err != nil {
+		return ReloadResult{}, err
+	}
+	if !a.pipeline.sameLayout(next) {
+		return ReloadResult{}, ErrRestartRequired
+	}
+
+	// Only Reload replaces the plugins, so they can be read without the lock.
+	oldInputs := a.Config.Inputs
+	oldProcessors := a.Config.Processors
+	oldAggregators := a.Config.Aggregators
+	oldOutputs := a.Config.Outputs
+
+	inputDiff := diffPlugins(inputDigests(oldInputs), inputDigests(c.Inputs))
+	processorDiff := diffPlugins(processorDigests(oldProcessors), processorDigests(c.Processors))
+	aggregatorDiff := diffPlugins(aggregatorDigests(oldAggregators), aggregatorDigests(c.Aggregators))
+	outputDiff := diffPlugins(outputDigests(oldOutputs), outputDigests(c.Outputs))
+
+	var result ReloadResult
+	result.Unchanged = inputDiff.unchanged() + processorDiff.unchanged() +
+		aggregatorDiff.unchanged() + outputDiff.unchanged()
+
+	processors := make(models.RunningProcessors, len(c.Processors))
+	for i, j := range processorDiff.running {
+		if j >= 0 {
+			processors[i] = oldProcessors[j]
+			continue
+		}
+		processors[i] = c.Processors[i]
+		log.Printf("I! [agent] Started processor %s", processors[i].Name)
+		result.Started++
+	}
+
+	var keptAggregators []*models.RunningAggregator
+	for _, j := range aggregatorDiff.running {
+		if j >= 0 {
+			keptAggregators = append(keptAggregators, oldAggregators[j])
+		}
+	}
+	var keptOutputs []*models.RunningOutput
+	for _, j := range outputDiff.running {
+		if j >= 0 {
+			keptOutputs = append(keptOutputs, oldOutputs[j])
+		}
+	}
+
+	// Metrics are no longer passed to the removed plugins once the lock is
+	// released, so they can be stopped.
+	a.mu.Lock()
+	oldPipeline := a.pipeline
+	a.Config.Processors = processors
+	a.Config.Aggregators = keptAggregators
+	a.Config.Outputs = keptOutputs
+	a.pipeline = a.mustPipeline()
+	a.mu.Unlock()
+
+	for _, j := range inputDiff.removed {
+		input := oldInputs[j]
+		rs.inputs[input].stop()
+		delete(rs.inputs, input)
+		stopServiceInput(input)
+		log.Printf("I! [agent] Stopped input %s", input.Name())
+		result.Stopped++
+	}
+	for _, j := range processorDiff.removed {
+		flushRemovedProcessor(rs, oldPipeline, oldProcessors[j])
+		stopProcessor(oldProcessors[j])
+		log.Printf("I! [agent] Stopped processor %s", oldProcessors[j].Name)
+		result.Stopped++
+	}
+	for _, j := range aggregatorDiff.removed {
+		agg := oldAggregators[j]
+		rs.aggs[agg].stop()
+		delete(rs.aggs, agg)
+		log.Printf("I! [agent] Stopped aggregator %s", agg.Name())
+		result.Stopped++
+	}
+	for _, j := range outputDiff.removed {
+		output := oldOutputs[j]
+		rs.outputs[output].stop()
+		delete(rs.outputs, output)
+		if err := output.Close(); err != nil {
+			log.Printf("E! [agent] Error closing output %s: %s", output.Name, err)
+		}
+		log.Printf("I! [agent] Stopped output %s", output.Name)
+		result.Stopped++
+	}
+
+	// The new plugins are started once the removed ones have stopped.
+	startTime := time.Now()
+
+	var outputs []*models.RunningOutput
+	for i, j := range outputDiff.running {
+		if j >= 0 {
+			outputs = append(outputs, oldOutputs[j])
+			continue
+		}
+		output := c.Outputs[i]
+		if berr := output.InitBuffer(); berr != nil {
+			log.Printf("E! [agent] %s", berr)
+			output.Close()
+			if err == nil {
+				err = berr
+			}
+			continue
+		}
+		if cerr := a.connectOutput(ctx, output); cerr != nil {
+			log.Printf("E! [agent] Could not connect to output %s: %s", output.Name, cerr)
+			output.Close()
+			if err == nil {
+				err = fmt.Errorf("could not connect to output %s: %s", output.Name, cerr)
+			}
+			continue
+		}
+		a.startOutput(rs, output, startTime)
+		outputs = append(outputs, output)
+		log.Printf("I! [agent] Started output %s", output.Name)
+		result.Started++
+	}
+
+	var inputs []*models.RunningInput
+	for i, j := range inputDiff.running {
+		if j >= 0 {
+			inputs = append(inputs, oldInputs[j])
+			continue
+		}
+		input := c.Inputs[i]
+		if serr := a.startServiceInput(input, rs.inputC); serr != nil {
+			if err == nil {
+				err = fmt.Errorf("service for input %s failed to start: %s",
+					input.Name(), serr)
+			}
+			continue
+		}
+		a.startInput(rs, input, startTime)
+		inputs = append(inputs, input)
+		log.Printf("I! [agent] Started input %s", input.Name())
+		result.Started++
+	}
+
+	var aggregators []*models.RunningAggregator
+	for i, j := range aggregatorDiff.running {
+		if j >= 0 {
+			aggregators = append(aggregators, oldAggregators[j])
+			continue
+		}
+		agg := c.Aggregators[i]
+		a.startAggregator(rs, agg, startTime)
+		aggregators = append(aggregators, agg)
+		log.Printf("I! [agent] Started aggregator %s", agg.Name())
+		result.Started++
+	}
+
+	a.mu.Lock()
+	a.Config.Inputs = inputs
+	a.Config.Aggregators = aggregators
+	a.Config.Outputs = outputs
+	a.pipeline = a.mustPipeline()
+	a.mu.Unlock()
+
+	return result, err
+}
+
+// reloadable returns whether the plugins of the running configuration can be
+// replaced by those of c.
+func reloadable(running, c *config.Config) bool {
+	return reflect.DeepEqual(running.Agent, c.Agent) &&
+		reflect.DeepEqual(running.Tags, c.Tags)
+}
+
+// flushRemovedProcessor sends the metrics still held by a processor removed
+// from the pipeline on to where the processor sent its metrics, after
+// applying the processors which followed it. The outputs it was used by are
+// not stopped yet.
+func flushRemovedProcessor(rs *runState, p *pipeline, processor *models.RunningProcessor) {
+	held := flushProcessor(processor, true)
+	if len(held) == 0 {
+		return
+	}
+
+	stage, output := p.owner(processor)
+	switch {
+	case stage != nil:
+		var metrics []telegraf.Metric
+		for _, m := range held {
+			metrics = append(metrics, applyProcessors(following(stage.processors, processor), m)...)
+		}
+		rs.procStages[stage.id] <- metrics
+	case output != nil:
+		for _, m := range held {
+			for _, m := range applyProcessors(following(p.outputProcessors[output], processor), m) {
+				output.AddMetric(m)
+			}
+		}
+	}
+}
+
+// following returns the processors after the given processor.
+func following(processors []*models.RunningProcessor, processor *models.RunningProcessor) []*models.RunningProcessor {
+	for i, p := range processors {
+		if p == processor {
+			return processors[i+1:]
+		}
+	}
+	return nil
+}
+
+// mustPipeline builds the pipeline of the running plugins. Their settings are
+// those of a configuration whose pipeline is valid, so it cannot fail.
+func (a *Agent) mustPipeline() *pipeline {
+	p, err := newPipeline(a.Config.Processors, a.Config.Aggregators, a.Config.Outputs)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// pluginDiff pairs the plugins of a new configuration with the running
+// plugins which have the same digest.
+type pluginDiff struct {
+	// running holds for each new plugin the index of the running plugin
+	// which is kept in its place, or -1 if the new plugin must be started
+	running []int
+	// removed holds the indexes of the running plugins which must be stopped
+	removed []int
+}
+
+func diffPlugins(running, next []string) pluginDiff {
+	unmatched := make(map[string][]int)
+	for i, digest := range running {
+		unmatched[digest] = append(unmatched[digest], i)
+	}
+
+	d := pluginDiff{running: make([]int, len(next))}
+	kept := make([]bool, len(running))
+	for i, digest := range next {
+		d.running[i] = -1
+		if indexes := unmatched[digest]; len(indexes) > 0 {
+			d.running[i] = indexes[0]
+			kept[indexes[0]] = true
+			unmatched[digest] = indexes[1:]
+		}
+	}
+
+	for i := range running {
+		if !kept[i] {
+			d.removed = append(d.removed, i)
+		}
+	}
+	return d
+}
+
+// unchanged returns the number of running plugins which are kept.
+func (d pluginDiff) unchanged() int {
+	var n int
+	for _, j := range d.running {
+		if j >= 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func inputDigests(inputs []*models.RunningInput) []string {
+	digests := make([]string, len(inputs))
+	for i, input := range inputs {
+		digests[i] = input.Digest
+	}
+	return digests
+}
+
+func processorDigests(processors models.RunningProcessors) []string {
+	digests := make([]string, len(processors))
+	for i, processor := range processors {
+		digests[i] = processor.Digest
+	}
+	return digests
+}
+
+func aggregatorDigests(aggregators []*models.RunningAggregator) []string {
+	digests := make([]string, len(aggregators))
+	for i, agg := range aggregators {
+		digests[i] = agg.Digest
+	}
+	return digests
+}
+
+func outputDigests(outputs []*models.RunningOutput) []string {
+	digests := make([]string, len(outputs))
+	for i, output := range outputs {
+		digests[i] = output.Digest
+	}
+	return digests
+}
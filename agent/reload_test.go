@@ -0,0 +1,233 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockServiceInput struct {
+	sync.Mutex
+	started bool
+	stopped bool
+}
+
+func (i *mockServiceInput) SampleConfig() string { return "" }
+func (i *mockServiceInput) Description() string  { return "" }
+
+func (i *mockServiceInput) Gather(acc telegraf.Accumulator) error {
+	acc.AddFields("mock", map[string]interface{}{"value": 1}, nil)
+	return nil
+}
+
+func (i *mockServiceInput) Start(acc telegraf.Accumulator) error {
+	i.Lock()
+	defer i.Unlock()
+	i.started = true
+	return nil
+}
+
+func (i *mockServiceInput) Stop() {
+	i.Lock()
+	defer i.Unlock()
+	i.stopped = true
+}
+
+func (i *mockServiceInput) state() (bool, bool) {
+	i.Lock()
+	defer i.Unlock()
+	return i.started, i.stopped
+}
+
+// eventLog records the order in which plugins are connected and closed
+type eventLog struct {
+	sync.Mutex
+	events []string
+}
+
+func (l *eventLog) add(event string) {
+	l.Lock()
+	defer l.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *eventLog) get() []string {
+	l.Lock()
+	defer l.Unlock()
+	return append([]string(nil), l.events...)
+}
+
+type mockOutput struct {
+	sync.Mutex
+	connected bool
+	closed    bool
+	metrics   int
+
+	name string
+	log  *eventLog
+}
+
+func (o *mockOutput) SampleConfig() string { return "" }
+func (o *mockOutput) Description() string  { return "" }
+
+func (o *mockOutput) Connect() error {
+	o.Lock()
+	defer o.Unlock()
+	o.connected = true
+	if o.log != nil {
+		o.log.add(o.name + " connected")
+	}
+	return nil
+}
+
+func (o *mockOutput) Close() error {
+	o.Lock()
+	defer o.Unlock()
+	o.closed = true
+	if o.log != nil {
+		o.log.add(o.name + " closed")
+	}
+	return nil
+}
+
+func (o *mockOutput) Write(metrics []telegraf.Metric) error {
+	o.Lock()
+	defer o.Unlock()
+	o.metrics += len(metrics)
+	return nil
+}
+
+func (o *mockOutput) state() (bool, bool, int) {
+	o.Lock()
+	defer o.Unlock()
+	return o.connected, o.closed, o.metrics
+}
+
+func newReloadConfig(inputs []string, outputs []string) *config.Config {
+	c := config.NewConfig()
+	c.Agent.OmitHostname = true
+	c.Agent.RoundInterval = false
+	c.Agent.Interval = internal.Duration{Duration: 10 * time.Millisecond}
+	c.Agent.FlushInterval = internal.Duration{Duration: 10 * time.Millisecond}
+
+	for _, digest := range inputs {
+		input := models.NewRunningInput(&mockServiceInput{},
+			&models.InputConfig{Name: "mock"})
+		input.Digest = digest
+		c.Inputs = append(c.Inputs, input)
+	}
+	for _, digest := range outputs {
+		output := models.NewRunningOutput("mock", &mockOutput{},
+			&models.OutputConfig{Name: "mock"}, 0, 0)
+		output.Digest = digest
+		c.Outputs = append(c.Outputs, output)
+	}
+	return c
+}
+
+func TestAgent_Reload(t *testing.T) {
+	c := newReloadConfig([]string{"a", "b"}, []string{"o"})
+	a, err := NewAgent(c)
+	require.NoError(t, err)
+	inputA, inputB := c.Inputs[0], c.Inputs[1]
+	output := c.Outputs[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- a.Run(ctx)
+	}()
+
+	// Wait for the agent to run
+	waitForWrites(t, output)
+
+	c2 := newReloadConfig([]string{"c", "a"}, []string{"o"})
+	result, err := a.Reload(ctx, c2)
+	require.NoError(t, err)
+	assert.Equal(t, ReloadResult{Started: 1, Stopped: 1, Unchanged: 2}, result)
+
+	// The unchanged plugins keep running in the order of the new config
+	assert.Equal(t, []*models.RunningInput{c2.Inputs[0], inputA}, a.Config.Inputs)
+	assert.Equal(t, []*models.RunningOutput{output}, a.Config.Outputs)
+	started, _ := c2.Inputs[0].Input.(*mockServiceInput).state()
+	assert.True(t, started)
+	_, stopped := inputA.Input.(*mockServiceInput).state()
+	assert.False(t, stopped)
+	_, stopped = inputB.Input.(*mockServiceInput).state()
+	assert.True(t, stopped)
+	_, closed, _ := output.Output.(*mockOutput).state()
+	assert.False(t, closed)
+	connected, _, _ := c2.Outputs[0].Output.(*mockOutput).state()
+	assert.False(t, connected)
+
+	// The agent settings cannot be reloaded
+	c3 := newReloadConfig([]string{"a"}, []string{"o"})
+	c3.Agent.Interval = internal.Duration{Duration: time.Second}
+	_, err = a.Reload(ctx, c3)
+	assert.Equal(t, ErrRestartRequired, err)
+	assert.Len(t, a.Config.Inputs, 2)
+
+	cancel()
+	require.NoError(t, <-done)
+	_, closed, _ = output.Output.(*mockOutput).state()
+	assert.True(t, closed)
+
+	// A stopped agent cannot be reloaded
+	_, err = a.Reload(ctx, c2)
+	assert.Equal(t, ErrRestartRequired, err)
+}
+
+func TestAgent_ReloadChangedOutput(t *testing.T) {
+	log := &eventLog{}
+	c := newReloadConfig([]string{"a"}, []string{"o"})
+	old := c.Outputs[0].Output.(*mockOutput)
+	old.name, old.log = "old", log
+	a, err := NewAgent(c)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- a.Run(ctx)
+	}()
+	waitForWrites(t, c.Outputs[0])
+
+	c2 := newReloadConfig([]string{"a"}, []string{"o2"})
+	changed := c2.Outputs[0].Output.(*mockOutput)
+	changed.name, changed.log = "new", log
+	result, err := a.Reload(ctx, c2)
+	require.NoError(t, err)
+	assert.Equal(t, ReloadResult{Started: 1, Stopped: 1, Unchanged: 1}, result)
+
+	// The old instance is closed before the new one connects, as both may
+	// need the same connection
+	assert.Equal(t, []string{"old connected", "old closed", "new connected"}, log.get())
+	assert.Equal(t, []*models.RunningOutput{c2.Outputs[0]}, a.Config.Outputs)
+	waitForWrites(t, c2.Outputs[0])
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+// waitForWrites waits for metrics to be written to an output
+func waitForWrites(t *testing.T, output *models.RunningOutput) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, _, n := output.Output.(*mockOutput).state()
+		if n > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for metrics")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/influxdata/telegraf/plugins/outputs"
 	_ "github.com/influxdata/telegraf/plugins/outputs/all"
 	_ "github.com/influxdata/telegraf/plugins/processors/all"
+	"github.com/influxdata/telegraf/selfstat"
 	"github.com/kardianos/service"
 )
 
@@ -41,6 +42,8 @@ var fVersion = flag.Bool("version", false, "display the version and exit")
 var fSampleConfig = flag.Bool("sample-config", false,
 	"print out full sample configuration")
 var fPidfile = flag.String("pidfile", "", "file to write our pid to")
+var fReloadMode = flag.String("reload-mode", "restart",
+	"how to reload the config on SIGHUP: restart all plugins, or diff to only restart changed plugins")
 var fInputFilters = flag.String("input-filter", "",
 	"filter the inputs to enable, separator is :")
 var fInputList = flag.Bool("input-list", false,
@@ -68,6 +71,12 @@ var (
 
 var stop chan struct{}
 
+var (
+	reloadsApplied   = selfstat.Register("agent", "reloads_applied", map[string]string{})
+	reloadsRestarted = selfstat.Register("agent", "reloads_restarted", map[string]string{})
+	reloadsFailed    = selfstat.Register("agent", "reloads_failed", map[string]string{})
+)
+
 func reloadLoop(
 	stop chan struct{},
 	inputFilters []string,
@@ -82,71 +91,171 @@ func reloadLoop(
 
 		ctx, cancel := context.WithCancel(context.Background())
 
-		signals := make(chan os.Signal)
+		agents := make(chan *agent.Agent, 1)
+
+		signals := make(chan os.Signal, 1)
 		signal.Notify(signals, os.Interrupt, syscall.SIGHUP,
 			syscall.SIGTERM, syscall.SIGINT)
 		go func() {
-			select {
-			case sig := <-signals:
-				if sig == syscall.SIGHUP {
-					log.Printf("I! Reloading Telegraf config")
+			var ag *agent.Agent
+			// In diff mode the config is reloaded off this goroutine, so
+			// that stop signals cancel a reload in progress.
+			reloads := make(chan struct{}, 1)
+			restart := make(chan struct{})
+			for {
+				select {
+				case ag = <-agents:
+					if *fReloadMode == "diff" {
+						go reloadAgentLoop(ctx, ag, reloads, restart, inputFilters, outputFilters)
+					}
+					continue
+				case <-restart:
 					<-reload
 					reload <- true
+					cancel()
+				case sig := <-signals:
+					if sig == syscall.SIGHUP {
+						log.Printf("I! Reloading Telegraf config")
+						if *fReloadMode == "diff" && ag != nil {
+							select {
+							case reloads <- struct{}{}:
+							default:
+								// a reload is already pending, and will
+								// load the latest config
+							}
+							continue
+						}
+						<-reload
+						reload <- true
+					}
+					cancel()
+				case <-stop:
+					cancel()
 				}
-				cancel()
-			case <-stop:
-				cancel()
+				return
 			}
 		}()
 
-		err := runAgent(ctx, inputFilters, outputFilters)
+		err := runAgent(ctx, inputFilters, outputFilters, agents)
 		if err != nil {
 			log.Fatalf("E! [telegraf] Error running agent: %v", err)
 		}
 	}
 }
 
-func runAgent(ctx context.Context,
+// reloadAgentLoop reloads the config of the running agent each time a reload
+// is requested, until ctx is cancelled. If the agent must be restarted
+// instead, a restart is requested and no further reloads are applied.
+func reloadAgentLoop(
+	ctx context.Context,
+	ag *agent.Agent,
+	reloads <-chan struct{},
+	restart chan<- struct{},
 	inputFilters []string,
 	outputFilters []string,
-) error {
-	// Setup default logging. This may need to change after reading the config
-	// file, but we can configure it to use our logger implementation now.
-	logger.SetupLogging(false, false, "")
-	log.Printf("I! Starting Telegraf %s", version)
+) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-reloads:
+		}
 
-	// If no other options are specified, load the config file and run.
+		if !reloadAgent(ctx, ag, inputFilters, outputFilters) {
+			select {
+			case restart <- struct{}{}:
+			case <-ctx.Done():
+			}
+			return
+		}
+	}
+}
+
+// reloadAgent applies the config to the running agent, only restarting the
+// plugins which changed. It returns false if the agent must be restarted
+// instead.
+func reloadAgent(
+	ctx context.Context,
+	ag *agent.Agent,
+	inputFilters []string,
+	outputFilters []string,
+) bool {
+	c, err := loadConfig(inputFilters, outputFilters)
+	if err != nil {
+		log.Printf("E! [telegraf] Error reloading config, keeping the running config: %v", err)
+		reloadsFailed.Incr(1)
+		return true
+	}
+
+	result, err := ag.Reload(ctx, c)
+	if err == agent.ErrRestartRequired {
+		log.Printf("I! [telegraf] Config cannot be reloaded in place, restarting all plugins")
+		reloadsRestarted.Incr(1)
+		return false
+	}
+	if err != nil {
+		log.Printf("E! [telegraf] Error reloading config: %v", err)
+		reloadsFailed.Incr(1)
+		return true
+	}
+
+	log.Printf("I! [telegraf] Reloaded config: %d plugins started, %d stopped, %d unchanged",
+		result.Started, result.Stopped, result.Unchanged)
+	reloadsApplied.Incr(1)
+	return true
+}
+
+// loadConfig loads and validates the config files.
+func loadConfig(inputFilters []string, outputFilters []string) (*config.Config, error) {
 	c := config.NewConfig()
 	c.OutputFilters = outputFilters
 	c.InputFilters = inputFilters
 	err := c.LoadConfig(*fConfig)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if *fConfigDirectory != "" {
 		for _, dir := range strings.Split(*fConfigDirectory, ",") {
 			if err := c.LoadDirectory(dir); err != nil {
-				return err
+				return nil, err
 			}
 		}
 	}
 	if !*fTest && len(c.Outputs) == 0 {
-		return errors.New("Error: no outputs found, did you provide a valid config file?")
+		return nil, errors.New("Error: no outputs found, did you provide a valid config file?")
 	}
 	if len(c.Inputs) == 0 {
-		return errors.New("Error: no inputs found, did you provide a valid config file?")
+		return nil, errors.New("Error: no inputs found, did you provide a valid config file?")
 	}
 
 	if int64(c.Agent.Interval.Duration) <= 0 {
-		return fmt.Errorf("Agent interval must be positive, found %s",
+		return nil, fmt.Errorf("Agent interval must be positive, found %s",
 			c.Agent.Interval.Duration)
 	}
 
 	if int64(c.Agent.FlushInterval.Duration) <= 0 {
-		return fmt.Errorf("Agent flush_interval must be positive; found %s",
+		return nil, fmt.Errorf("Agent flush_interval must be positive; found %s",
 			c.Agent.Interval.Duration)
 	}
+	return c, nil
+}
+
+func runAgent(ctx context.Context,
+	inputFilters []string,
+	outputFilters []string,
+	agents chan<- *agent.Agent,
+) error {
+	// Setup default logging. This may need to change after reading the config
+	// file, but we can configure it to use our logger implementation now.
+	logger.SetupLogging(false, false, "")
+	log.Printf("I! Starting Telegraf %s", version)
+
+	// If no other options are specified, load the config file and run.
+	c, err := loadConfig(inputFilters, outputFilters)
+	if err != nil {
+		return err
+	}
 
 	ag, err := agent.NewAgent(c)
 	if err != nil {
@@ -188,6 +297,7 @@ func runAgent(ctx context.Context,
 		}
 	}
 
+	agents <- ag
 	return ag.Run(ctx)
 }
 
@@ -250,6 +360,10 @@ func main() {
 	flag.Parse()
 	args := flag.Args()
 
+	if *fReloadMode != "restart" && *fReloadMode != "diff" {
+		log.Fatalf("E! Unknown reload mode %q, must be restart or diff", *fReloadMode)
+	}
+
 	inputFilters, outputFilters := []string{}, []string{}
 	if *fInputFilters != "" {
 		inputFilters = strings.Split(":"+strings.TrimSpace(*fInputFilters)+":", ":")
@@ -0,0 +1,308 @@
+package dcosutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+const (
+	// loginTokenDuration is how long the signed login token is valid for
+	loginTokenDuration = 5 * time.Minute
+	// tokenDuration is the requested lifetime of an authentication token; the
+	// expiry of the token which IAM issues takes precedence
+	tokenDuration = 65 * time.Minute
+	// refreshBefore is how long before its expiry a token is renewed
+	refreshBefore = 5 * time.Minute
+)
+
+// ServiceAccount is a DC/OS service account which logs in with a private key
+type ServiceAccount struct {
+	UID           string
+	PrivateKey    *rsa.PrivateKey
+	LoginEndpoint string
+}
+
+// NewServiceAccount returns a service account with a PEM encoded RSA private key
+func NewServiceAccount(uid string, privateKey []byte, loginEndpoint string) (ServiceAccount, error) {
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(privateKey)
+	if err != nil {
+		return ServiceAccount{}, fmt.Errorf("error reading private key of service account %s: %s", uid, err)
+	}
+	return ServiceAccount{
+		UID:           uid,
+		PrivateKey:    key,
+		LoginEndpoint: loginEndpoint,
+	}, nil
+}
+
+// iamConfig is the service account file written by the DC/OS installer
+type iamConfig struct {
+	UID           string `json:"uid"`
+	PrivateKey    string `json:"private_key"`
+	LoginEndpoint string `json:"login_endpoint"`
+}
+
+// ReadIAMConfig reads a service account from a DC/OS IAM config file
+func ReadIAMConfig(path string) (ServiceAccount, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ServiceAccount{}, err
+	}
+
+	var cfg iamConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return ServiceAccount{}, fmt.Errorf("error reading IAM config %s: %s", path, err)
+	}
+	if cfg.UID == "" || cfg.PrivateKey == "" || cfg.LoginEndpoint == "" {
+		return ServiceAccount{}, fmt.Errorf("error reading IAM config %s: uid, private_key and login_endpoint are required", path)
+	}
+	return NewServiceAccount(cfg.UID, []byte(cfg.PrivateKey), cfg.LoginEndpoint)
+}
+
+// IAMError is returned when the DC/OS IAM service rejects a login
+type IAMError struct {
+	URL         string
+	StatusCode  int
+	Title       string
+	Description string
+}
+
+func (e *IAMError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("[%s] %s: %s", e.URL, e.Title, e.Description)
+	}
+	return fmt.Sprintf("[%s] %s", e.URL, e.Title)
+}
+
+// loginRequest is the body of a login request
+type loginRequest struct {
+	UID   string `json:"uid"`
+	Exp   int64  `json:"exp,omitempty"`
+	Token string `json:"token,omitempty"`
+}
+
+// loginResponse is the body of a successful login response
+type loginResponse struct {
+	Token string `json:"token"`
+}
+
+// loginClaims are the claims of the token with which a service account logs in
+type loginClaims struct {
+	UID string `json:"uid"`
+	jwt.StandardClaims
+}
+
+// Authenticator logs in as a service account and caches the resulting token,
+// which it renews shortly before it expires
+type Authenticator struct {
+	account ServiceAccount
+	client  *http.Client
+
+	mu        sync.Mutex
+	token     string
+	issued    time.Time
+	refreshAt time.Time
+	expires   time.Time
+
+	loginFailures selfstat.Stat
+	tokenAge      selfstat.Stat
+}
+
+// NewAuthenticator returns an authenticator for account, which logs in with
+// requests made through rt. Each plugin has its own authenticator, so that
+// the key and transport of a reloaded plugin take effect, while the
+// statistics of authenticators for the same account are combined.
+func NewAuthenticator(account ServiceAccount, rt http.RoundTripper) *Authenticator {
+	tags := map[string]string{
+		"uid":            account.UID,
+		"login_endpoint": account.LoginEndpoint,
+	}
+	return &Authenticator{
+		account:       account,
+		client:        &http.Client{Transport: rt, Timeout: time.Minute},
+		loginFailures: selfstat.Register("dcos_auth", "login_failures", tags),
+		tokenAge:      selfstat.Register("dcos_auth", "token_age_seconds", tags),
+	}
+}
+
+// Token returns a valid token, logging in if there is no token or if the
+// cached token is due to be renewed. A token which could not be renewed is
+// returned until it expires.
+func (a *Authenticator) Token(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if a.token == "" || !now.Before(a.refreshAt) {
+		if err := a.login(ctx, now); err != nil {
+			a.loginFailures.Incr(1)
+			if a.token == "" || !now.Before(a.expires) {
+				a.token = ""
+				return "", err
+			}
+			log.Printf("W! Could not renew token of service account %s: %s", a.account.UID, err)
+		}
+	}
+
+	a.tokenAge.Set(int64(now.Sub(a.issued) / time.Second))
+	return a.token, nil
+}
+
+// Invalidate discards token if it is the cached token, so that the next call
+// to Token logs in again
+func (a *Authenticator) Invalidate(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token == token {
+		a.token = ""
+	}
+}
+
+// login requests a new token from the login endpoint
+func (a *Authenticator) login(ctx context.Context, now time.Time) error {
+	loginToken, err := jwt.NewWithClaims(jwt.SigningMethodRS256, loginClaims{
+		UID: a.account.UID,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: now.Add(loginTokenDuration).Unix(),
+		},
+	}).SignedString(a.account.PrivateKey)
+	if err != nil {
+		return err
+	}
+
+	expires := now.Add(tokenDuration)
+	body, err := json.Marshal(loginRequest{
+		UID:   a.account.UID,
+		Exp:   expires.Unix(),
+		Token: loginToken,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", a.account.LoginEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		iamErr := &IAMError{
+			URL:        a.account.LoginEndpoint,
+			StatusCode: resp.StatusCode,
+			Title:      resp.Status,
+		}
+		// IAM describes the error in the body, when it can
+		var desc struct {
+			Title       string `json:"title"`
+			Description string `json:"description"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&desc); err == nil && desc.Title != "" {
+			iamErr.Title = desc.Title
+			iamErr.Description = desc.Description
+		}
+		return iamErr
+	}
+
+	var auth loginResponse
+	if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+		return fmt.Errorf("error reading login response from %s: %s", a.account.LoginEndpoint, err)
+	}
+	if auth.Token == "" {
+		return fmt.Errorf("login response from %s contained no token", a.account.LoginEndpoint)
+	}
+
+	// The token is not verified here; its expiry only determines when it is renewed
+	var claims jwt.StandardClaims
+	if _, _, err := new(jwt.Parser).ParseUnverified(auth.Token, &claims); err == nil && claims.ExpiresAt != 0 {
+		expires = time.Unix(claims.ExpiresAt, 0)
+	}
+
+	// Short-lived tokens are renewed half way through their lifetime
+	refreshAt := expires.Add(-refreshBefore)
+	if halfway := now.Add(expires.Sub(now) / 2); refreshAt.Before(halfway) {
+		refreshAt = halfway
+	}
+
+	a.token = auth.Token
+	a.issued = now
+	a.refreshAt = refreshAt
+	a.expires = expires
+	return nil
+}
+
+// authRoundTripper authenticates requests with the token of a service account
+type authRoundTripper struct {
+	next http.RoundTripper
+	auth *Authenticator
+}
+
+// NewAuthRoundTripper returns a RoundTripper which adds the token of auth to
+// each request. A request which is unauthorized is retried once with a new
+// token.
+func NewAuthRoundTripper(rt http.RoundTripper, auth *Authenticator) http.RoundTripper {
+	return &authRoundTripper{next: rt, auth: auth}
+}
+
+// RoundTrip is an implementation of the RoundTripper interface.
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := rt.auth.Token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := rt.next.RoundTrip(withToken(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// The token may have been revoked; a request can only be retried if its
+	// body can be sent again
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	rt.auth.Invalidate(token)
+	token, err = rt.auth.Token(req.Context())
+	if err != nil {
+		return resp, nil
+	}
+
+	retry := withToken(req, token)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	return rt.next.RoundTrip(retry)
+}
+
+// withToken returns a copy of req which is authorized with token
+func withToken(req *http.Request, token string) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Authorization", "token="+token)
+	return r
+}
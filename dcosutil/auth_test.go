@@ -0,0 +1,193 @@
+package dcosutil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+var pki = testutil.NewPKI("../testutil/pki")
+
+// iamServer is a login endpoint which issues numbered tokens
+type iamServer struct {
+	*httptest.Server
+
+	expires int64
+
+	mu     sync.Mutex
+	logins int
+	status int
+}
+
+func newIAMServer() *iamServer {
+	s := &iamServer{
+		expires: time.Now().Add(time.Hour).Unix(),
+		status:  http.StatusOK,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.status != http.StatusOK {
+			w.WriteHeader(s.status)
+			fmt.Fprint(w, `{"title": "Invalid authentication credentials", "description": "Unknown uid"}`)
+			return
+		}
+		s.logins++
+		fmt.Fprintf(w, `{"token": "%s"}`, s.token(s.logins))
+	}))
+	return s
+}
+
+// token returns the nth token issued by the server
+func (s *iamServer) token(n int) string {
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
+		Subject:   fmt.Sprintf("token-%d", n),
+		ExpiresAt: s.expires,
+	}).SignedString([]byte("secret"))
+	return token
+}
+
+func (s *iamServer) loginCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+func (s *iamServer) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func newTestAuthenticator(t *testing.T, uid string, endpoint string) *Authenticator {
+	account, err := NewServiceAccount(uid, []byte(pki.ReadServerKey()), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewAuthenticator(account, &http.Transport{})
+}
+
+func TestReadIAMConfig(t *testing.T) {
+	account, err := ReadIAMConfig(pki.IAMAccountPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.UID == "" || account.PrivateKey == nil {
+		t.Fatalf("expected a uid and private key, got %+v", account)
+	}
+	if account.LoginEndpoint != "http://127.0.0.1:8101/acs/api/v1/auth/login" {
+		t.Fatalf("unexpected login endpoint %s", account.LoginEndpoint)
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	server := newIAMServer()
+	defer server.Close()
+
+	a := newTestAuthenticator(t, "authenticator", server.URL)
+	ctx := context.Background()
+
+	// The token is cached until it is due to be renewed
+	for i := 0; i < 2; i++ {
+		token, err := a.Token(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != server.token(1) {
+			t.Fatalf("expected the first token, got %s", token)
+		}
+	}
+	if a.expires.Sub(time.Now()) < 59*time.Minute {
+		t.Fatalf("expected the token to expire in an hour, got %s", a.expires)
+	}
+
+	a.refreshAt = time.Now().Add(-time.Second)
+	if token, err := a.Token(ctx); err != nil || token != server.token(2) {
+		t.Fatalf("expected the second token, got %s %v", token, err)
+	}
+
+	// A token which could not be renewed is used until it expires
+	server.setStatus(http.StatusUnauthorized)
+	a.refreshAt = time.Now().Add(-time.Second)
+	if token, err := a.Token(ctx); err != nil || token != server.token(2) {
+		t.Fatalf("expected the second token, got %s %v", token, err)
+	}
+
+	a.expires = time.Now().Add(-time.Second)
+	_, err := a.Token(ctx)
+	iamErr, ok := err.(*IAMError)
+	if !ok || iamErr.StatusCode != http.StatusUnauthorized || iamErr.Description != "Unknown uid" {
+		t.Fatalf("expected an IAM error, got %v", err)
+	}
+
+	if failures := a.loginFailures.Get(); failures != 2 {
+		t.Fatalf("expected 2 login failures, got %d", failures)
+	}
+	if logins := server.loginCount(); logins != 2 {
+		t.Fatalf("expected 2 logins, got %d", logins)
+	}
+}
+
+func TestAuthRoundTripper(t *testing.T) {
+	server := newIAMServer()
+	defer server.Close()
+
+	a := newTestAuthenticator(t, "roundtripper", server.URL)
+
+	var body []byte
+	resource := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token="+server.token(server.loginCount()) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer resource.Close()
+
+	client := &http.Client{Transport: NewAuthRoundTripper(&http.Transport{}, a)}
+
+	// A revoked token is replaced, and the request is retried with its body
+	a.mu.Lock()
+	a.token = "revoked"
+	a.refreshAt = time.Now().Add(time.Hour)
+	a.expires = time.Now().Add(time.Hour)
+	a.mu.Unlock()
+
+	resp, err := client.Post(resource.URL, "text/plain", bytes.NewReader([]byte("payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+	}
+	if string(body) != "payload" {
+		t.Fatalf("expected the request body to be sent again, got %q", body)
+	}
+
+	// A request is retried only once
+	server.setStatus(http.StatusUnauthorized)
+	a.mu.Lock()
+	a.token = "revoked"
+	a.mu.Unlock()
+	resp, err = client.Get(resource.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected status code 401, got %d", resp.StatusCode)
+	}
+	if logins := server.loginCount(); logins != 1 {
+		t.Fatalf("expected 1 login, got %d", logins)
+	}
+}
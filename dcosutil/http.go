@@ -12,7 +12,6 @@ import (
 
 	"github.com/influxdata/telegraf/internal"
 
-	"github.com/dcos/dcos-go/dcos/http/transport"
 	"github.com/mesos/mesos-go/api/v1/lib/httpcli"
 )
 
@@ -57,7 +56,8 @@ func GetUserAgent(override string) string {
 	return fmt.Sprintf("%s/%s", userAgent, internal.Version())
 }
 
-// Transport returns a transport implementing http.RoundTripper
+// Transport returns a transport implementing http.RoundTripper. If an IAM
+// config is set, requests are authenticated as its service account.
 func (c *DCOSConfig) Transport() (http.RoundTripper, error) {
 	tr, err := getTransport(c.CACertificatePath)
 	if err != nil {
@@ -65,15 +65,12 @@ func (c *DCOSConfig) Transport() (http.RoundTripper, error) {
 	}
 
 	if c.IAMConfigPath != "" {
-		rt, err := transport.NewRoundTripper(
-			tr,
-			transport.OptionReadIAMConfig(c.IAMConfigPath),
-			transport.OptionUserAgent(GetUserAgent(c.UserAgent)),
-		)
+		account, err := ReadIAMConfig(c.IAMConfigPath)
 		if err != nil {
 			return nil, err
 		}
-		return rt, nil
+		auth := NewAuthenticator(account, NewRoundTripper(tr, c.UserAgent))
+		return NewRoundTripper(NewAuthRoundTripper(tr, auth), c.UserAgent), nil
 	}
 
 	return tr, nil
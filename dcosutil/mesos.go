@@ -0,0 +1,108 @@
+package dcosutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/mesos/mesos-go/api/v1/lib"
+	"github.com/mesos/mesos-go/api/v1/lib/agent"
+	"github.com/mesos/mesos-go/api/v1/lib/agent/calls"
+	"github.com/mesos/mesos-go/api/v1/lib/httpcli"
+	"github.com/mesos/mesos-go/api/v1/lib/httpcli/httpagent"
+	"github.com/mesos/mesos-go/api/v1/lib/master"
+)
+
+// ProcessResponse reads the response from a triggered request, verifies its
+// type, and returns an agent response
+func ProcessResponse(resp mesos.Response, t agent.Response_Type) (agent.Response, error) {
+	var r agent.Response
+	defer func() {
+		if resp != nil {
+			resp.Close()
+		}
+	}()
+	for {
+		if err := resp.Decode(&r); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return r, err
+		}
+	}
+	if r.GetType() != t {
+		return r, fmt.Errorf("processResponse expected type %q, got %q", t, r.GetType())
+	}
+	return r, nil
+}
+
+// ProcessMasterResponse reads the response from a triggered request to the
+// mesos master, verifies its type, and returns a master response
+func ProcessMasterResponse(resp mesos.Response, t master.Response_Type) (master.Response, error) {
+	var r master.Response
+	defer func() {
+		if resp != nil {
+			resp.Close()
+		}
+	}()
+	for {
+		if err := resp.Decode(&r); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return r, err
+		}
+	}
+	if r.GetType() != t {
+		return r, fmt.Errorf("processResponse expected type %q, got %q", t, r.GetType())
+	}
+	return r, nil
+}
+
+// GetAgentState requests the state of the mesos agent from its operator API
+func GetAgentState(ctx context.Context, client *httpcli.Client) (*agent.Response_GetState, error) {
+	cli := httpagent.NewSender(client.Send)
+	resp, err := cli.Send(ctx, calls.NonStreaming(calls.GetState()))
+	if err != nil {
+		return nil, err
+	}
+	r, err := ProcessResponse(resp, agent.Response_GET_STATE)
+	if err != nil {
+		return nil, err
+	}
+
+	gs := r.GetGetState()
+	if gs == nil {
+		return nil, errors.New("the getState response from the mesos agent was empty")
+	}
+	return gs, nil
+}
+
+// WatchAgentState requests the state of the mesos agent immediately and then
+// every interval until ctx is cancelled, and passes each state, or the error
+// which prevented its retrieval, to handle. The operator API of the mesos
+// agent does not offer an event stream, so changes are seen up to an
+// interval after they happen.
+func WatchAgentState(ctx context.Context, client *httpcli.Client, timeout, interval time.Duration,
+	handle func(*agent.Response_GetState, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		reqCtx, cancel := context.WithTimeout(ctx, timeout)
+		gs, err := GetAgentState(reqCtx, client)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		handle(gs, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
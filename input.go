@@ -1,5 +1,7 @@
 package telegraf
 
+import "context"
+
 type Input interface {
 	// SampleConfig returns the default configuration of the Input
 	SampleConfig() string
@@ -22,3 +24,13 @@ type ServiceInput interface {
 	// Stop stops the services and closes any necessary channels and connections
 	Stop()
 }
+
+// ContextInput is an Input whose gather can be canceled.
+type ContextInput interface {
+	Input
+
+	// GatherContext is called instead of Gather. The context is canceled
+	// when the input's gather_timeout passes or the input is stopped, after
+	// which the Accumulator should no longer be used.
+	GatherContext(context.Context, Accumulator) error
+}
@@ -12,6 +12,10 @@ import (
 	"github.com/influxdata/telegraf/plugins/inputs/procstat"
 	"github.com/influxdata/telegraf/plugins/parsers"
 
+	_ "github.com/influxdata/telegraf/plugins/aggregators/minmax"
+	_ "github.com/influxdata/telegraf/plugins/outputs/file"
+	_ "github.com/influxdata/telegraf/plugins/processors/rename"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -177,3 +181,50 @@ func TestConfig_LoadDirectory(t *testing.T) {
 	assert.Equal(t, pConfig, c.Inputs[3].Config,
 		"Merged Testdata did not produce correct procstat metadata.")
 }
+
+func TestConfig_PluginDigest(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/single_plugin.toml"))
+	assert.NoError(t, c.LoadDirectory("./testdata/subconfig"))
+
+	c2 := NewConfig()
+	assert.NoError(t, c2.LoadConfig("./testdata/single_plugin.toml"))
+
+	// Loading the same table gives the same digest
+	assert.NotEmpty(t, c.Inputs[0].Digest)
+	assert.Equal(t, c.Inputs[0].Digest, c2.Inputs[0].Digest)
+
+	// The memcached inputs only differ in their servers
+	assert.Equal(t, "memcached", c.Inputs[2].Config.Name)
+	assert.NotEqual(t, c.Inputs[0].Digest, c.Inputs[2].Digest)
+}
+
+func TestConfig_LoadInputSchedule(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/input_schedule.toml"))
+
+	memcached := inputs.Inputs["memcached"]().(*memcached.Memcached)
+	memcached.Servers = []string{"localhost"}
+	assert.Equal(t, memcached, c.Inputs[0].Input)
+
+	conf := c.Inputs[0].Config
+	assert.Equal(t, time.Minute, conf.GatherTimeout)
+	assert.NotNil(t, conf.Schedule)
+	now := time.Date(2018, time.October, 17, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2018, time.October, 18, 2, 0, 0, 0, time.UTC),
+		conf.Schedule.Next(now))
+}
+
+func TestConfig_LoadPipeline(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/pipeline.toml"))
+
+	assert.Equal(t, int64(1), c.Aggregators[0].Config.Stage)
+
+	conf := c.Processors[0].Config
+	assert.Equal(t, int64(1), conf.Order)
+	assert.Equal(t, int64(2), conf.Stage)
+	assert.Equal(t, "rename_min", conf.Alias)
+
+	assert.Equal(t, []string{"rename_min"}, c.Outputs[0].Config.Processors)
+}
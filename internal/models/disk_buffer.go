@@ -0,0 +1,600 @@
+package models
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/parsers/influx"
+	influxserializer "github.com/influxdata/telegraf/plugins/serializers/influx"
+	"github.com/influxdata/telegraf/selfstat"
+)
+
+const (
+	// Default maximum size of a disk buffer, in bytes.
+	DEFAULT_METRIC_BUFFER_MAX_SIZE = 512 * 1024 * 1024
+
+	// segmentsPerBuffer is the number of segments a full disk buffer is split
+	// into. The oldest segment is discarded whole when the buffer is full.
+	segmentsPerBuffer = 16
+
+	segmentExt = ".wal"
+	cursorFile = "cursor"
+	// lockFile is held while the buffer is open, so that a directory is not
+	// used by two buffers at once
+	lockFile = "lock"
+)
+
+// segment is a file of a disk buffer, holding metrics in line protocol.
+type segment struct {
+	id    uint64
+	size  int64 // number of bytes in the segment
+	count int   // number of metrics in the segment
+}
+
+// position is the position of a metric in a disk buffer.
+type position struct {
+	segment uint64 // id of the segment
+	offset  int64  // byte offset of the metric within the segment
+	index   int    // index of the metric within the segment
+}
+
+// DiskBuffer stores metrics in a write-ahead log on disk, so that they
+// survive a restart and outages longer than a Buffer can hold. The log is
+// split into segments, which are deleted once all of their metrics are
+// written, or discarded oldest first when the buffer exceeds its maximum size.
+//
+// Unlike a Buffer, a DiskBuffer batches the oldest metrics first. Metrics are
+// accepted once they are written to disk, and their value types are limited
+// to those of the line protocol.
+type DiskBuffer struct {
+	sync.Mutex
+	name         string
+	dir          string
+	maxSize      int64
+	segmentSize  int64
+	segments     []*segment // oldest first; metrics are added to the last
+	file         *os.File   // the last segment, open for appending
+	size         int64      // number of bytes in all segments
+	count        int        // number of metrics in all segments
+	head         position   // the oldest metric which has not been written
+	oldest       time.Time  // timestamp of the metric at head
+	batchEnd     position   // one after the last metric in the batch
+	batchSize    int        // number of metrics currently in the batch
+	batchDropped int        // number of metrics of the batch discarded by trim
+	lock         *os.File
+	serializer   *influxserializer.Serializer
+	parser       *influx.Parser
+	lastSegment  uint64
+	cursorFailed bool
+
+	MetricsAdded    selfstat.Stat
+	MetricsWritten  selfstat.Stat
+	MetricsDropped  selfstat.Stat
+	BufferSize      selfstat.Stat
+	BufferDiskBytes selfstat.Stat
+	BufferOldestAge selfstat.Stat
+	BufferDiskLimit selfstat.Stat
+}
+
+// NewDiskBuffer opens the disk buffer in the given directory, creating it if
+// necessary, and replays the metrics which it holds.
+func NewDiskBuffer(name string, dir string, maxSize int64) (*DiskBuffer, error) {
+	if maxSize <= 0 {
+		maxSize = DEFAULT_METRIC_BUFFER_MAX_SIZE
+	}
+	tags := map[string]string{"output": name}
+	b := &DiskBuffer{
+		name:        name,
+		dir:         dir,
+		maxSize:     maxSize,
+		segmentSize: maxSize / segmentsPerBuffer,
+		serializer:  influxserializer.NewSerializer(),
+		parser:      influx.NewParser(influx.NewMetricHandler()),
+
+		MetricsAdded:    selfstat.Register("write", "metrics_added", tags),
+		MetricsWritten:  selfstat.Register("write", "metrics_written", tags),
+		MetricsDropped:  selfstat.Register("write", "metrics_dropped", tags),
+		BufferSize:      selfstat.Register("write", "buffer_size", tags),
+		BufferDiskBytes: selfstat.Register("write", "buffer_disk_bytes", tags),
+		BufferOldestAge: selfstat.Register("write", "buffer_oldest_metric_age_seconds", tags),
+		BufferDiskLimit: selfstat.Register("write", "buffer_disk_limit", tags),
+	}
+	b.serializer.SetFieldTypeSupport(influxserializer.UintSupport)
+	b.BufferDiskLimit.Set(maxSize)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	lock, err := openLockFile(filepath.Join(dir, lockFile))
+	if err != nil {
+		return nil, fmt.Errorf("could not lock buffer %s, it may be used by another output: %s", dir, err)
+	}
+	b.lock = lock
+	if err := b.load(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	if err := b.rotate(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	b.trim()
+	b.updateOldest()
+	b.updateStats()
+
+	if n := b.length(); n > 0 {
+		log.Printf("I! [outputs.%s] replaying %d metrics from buffer %s", name, n, dir)
+	}
+	return b, nil
+}
+
+// load reads the segments and the cursor in the buffer's directory.
+func (b *DiskBuffer) load() error {
+	files, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != segmentExt {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		s, err := b.loadSegment(id)
+		if err != nil {
+			return err
+		}
+		b.segments = append(b.segments, s)
+		b.size += s.size
+		b.count += s.count
+	}
+	sort.Slice(b.segments, func(i, j int) bool {
+		return b.segments[i].id < b.segments[j].id
+	})
+	if len(b.segments) > 0 {
+		b.lastSegment = b.segments[len(b.segments)-1].id
+		b.head = position{segment: b.segments[0].id}
+	}
+
+	cursor, err := b.readCursor()
+	if err != nil {
+		log.Printf("E! [outputs.%s] could not read position in buffer, replaying all metrics: %s", b.name, err)
+		return nil
+	}
+	// Segments before the cursor were written but not yet deleted. If the
+	// cursor's segment was discarded, all remaining metrics are replayed.
+	for _, s := range b.segments {
+		if s.id == cursor.segment && cursor.offset <= s.size && cursor.index <= s.count {
+			for b.segments[0].id != cursor.segment {
+				b.removeFirst()
+			}
+			b.head = cursor
+			break
+		}
+	}
+	return nil
+}
+
+// loadSegment counts the metrics in a segment. A metric which was only
+// partially written is removed.
+func (b *DiskBuffer) loadSegment(id uint64) (*segment, error) {
+	data, err := ioutil.ReadFile(b.segmentPath(id))
+	if err != nil {
+		return nil, err
+	}
+	size := bytes.LastIndexByte(data, '\n') + 1
+	if size < len(data) {
+		if err := os.Truncate(b.segmentPath(id), int64(size)); err != nil {
+			return nil, err
+		}
+	}
+	return &segment{
+		id:    id,
+		size:  int64(size),
+		count: bytes.Count(data[:size], []byte{'\n'}),
+	}, nil
+}
+
+// Len returns the number of metrics currently in the buffer.
+func (b *DiskBuffer) Len() int {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.length()
+}
+
+func (b *DiskBuffer) length() int {
+	return b.count - b.head.index
+}
+
+func (b *DiskBuffer) metricAdded() {
+	b.MetricsAdded.Incr(1)
+}
+
+func (b *DiskBuffer) metricWritten(metric telegraf.Metric) {
+	AgentMetricsWritten.Incr(1)
+	b.MetricsWritten.Incr(1)
+	metric.Accept()
+}
+
+func (b *DiskBuffer) metricsDropped(n int) {
+	AgentMetricsDropped.Incr(int64(n))
+	b.MetricsDropped.Incr(int64(n))
+}
+
+// Add appends metrics to the buffer. The metrics are written to disk
+// together, rather than one at a time, but are only synced when a batch is
+// accepted, a segment is completed or the buffer is closed. If the buffer
+// exceeds its maximum size, the oldest segments are discarded.
+func (b *DiskBuffer) Add(metrics ...telegraf.Metric) {
+	b.Lock()
+	defer b.Unlock()
+
+	var buf bytes.Buffer
+	pending := make([]telegraf.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		line, err := b.serializer.Serialize(m)
+		if err != nil {
+			b.addFailed(err, m)
+			continue
+		}
+
+		s := b.segments[len(b.segments)-1]
+		if s.size+int64(buf.Len()) >= b.segmentSize && s.count+len(pending) > 0 {
+			b.write(&buf, pending)
+			pending = pending[:0]
+			if err := b.rotate(); err != nil {
+				b.addFailed(err, m)
+				continue
+			}
+		}
+		buf.Write(line)
+		pending = append(pending, m)
+	}
+	b.write(&buf, pending)
+
+	b.trim()
+	b.updateStats()
+}
+
+// write appends the serialized metrics in buf to the last segment, and resets
+// buf. If the write fails, none of the metrics are added.
+func (b *DiskBuffer) write(buf *bytes.Buffer, metrics []telegraf.Metric) {
+	if len(metrics) == 0 {
+		return
+	}
+	defer buf.Reset()
+
+	s := b.segments[len(b.segments)-1]
+	n, err := b.file.Write(buf.Bytes())
+	if err != nil {
+		// remove the partially written metrics, so that they are not read
+		b.file.Truncate(s.size)
+		b.addFailed(err, metrics...)
+		return
+	}
+
+	if b.length() == 0 {
+		b.oldest = metrics[0].Time()
+	}
+	s.size += int64(n)
+	b.size += int64(n)
+	s.count += len(metrics)
+	b.count += len(metrics)
+	for _, m := range metrics {
+		b.metricAdded()
+		m.Accept()
+	}
+}
+
+// addFailed drops metrics which could not be added to the buffer.
+func (b *DiskBuffer) addFailed(err error, metrics ...telegraf.Metric) {
+	log.Printf("E! [outputs.%s] could not add %d metrics to buffer: %s", b.name, len(metrics), err)
+	b.metricsDropped(len(metrics))
+	for _, m := range metrics {
+		m.Reject()
+	}
+}
+
+// rotate syncs and closes the segment which metrics are added to, and starts
+// a new one.
+func (b *DiskBuffer) rotate() error {
+	id := b.lastSegment + 1
+	f, err := os.OpenFile(b.segmentPath(id), os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	b.syncDir()
+	if b.file != nil {
+		b.syncSegment()
+		b.file.Close()
+	}
+	b.file = f
+	b.lastSegment = id
+	b.segments = append(b.segments, &segment{id: id})
+	if len(b.segments) == 1 {
+		b.head = position{segment: id}
+	}
+	return nil
+}
+
+// trim discards the oldest segments while the buffer exceeds its maximum
+// size. The segment which metrics are added to is never discarded.
+func (b *DiskBuffer) trim() {
+	if b.size <= b.maxSize || len(b.segments) == 1 {
+		return
+	}
+	for b.size > b.maxSize && len(b.segments) > 1 {
+		s := b.segments[0]
+		b.metricsDropped(s.count - b.head.index)
+		if b.batchSize > 0 && b.batchEnd.segment == s.id {
+			// the whole batch was discarded
+			batchSize := b.batchSize
+			b.resetBatch()
+			b.batchDropped = batchSize
+		} else if b.batchSize > 0 && b.head.segment == s.id {
+			// the batch starts at head, so its metrics in the segment were
+			// discarded
+			b.batchDropped += s.count - b.head.index
+		}
+		b.removeFirst()
+		b.updateOldest()
+	}
+	b.saveCursor()
+}
+
+// removeFirst deletes the oldest segment. If head was within it, it is moved
+// to the start of the next segment.
+func (b *DiskBuffer) removeFirst() {
+	s := b.segments[0]
+	if err := os.Remove(b.segmentPath(s.id)); err != nil {
+		log.Printf("E! [outputs.%s] could not remove buffer segment: %s", b.name, err)
+	}
+	b.segments = b.segments[1:]
+	b.size -= s.size
+	b.count -= s.count
+	if b.head.segment <= s.id {
+		b.head = position{segment: b.segments[0].id}
+	}
+}
+
+// Batch returns a slice containing up to batchSize of the oldest metrics in
+// the buffer, ordered from oldest to newest.
+func (b *DiskBuffer) Batch(batchSize int) []telegraf.Metric {
+	b.Lock()
+	defer b.Unlock()
+
+	out, end, err := b.read(b.head, batchSize)
+	if err != nil {
+		log.Printf("E! [outputs.%s] could not read metrics from buffer: %s", b.name, err)
+	}
+	b.batchEnd = end
+	b.batchSize = len(out)
+	b.batchDropped = 0
+	b.updateStats()
+	return out
+}
+
+// read reads up to n metrics from the given position, returning them and the
+// position after the last. Metrics which can not be parsed are skipped.
+func (b *DiskBuffer) read(pos position, n int) ([]telegraf.Metric, position, error) {
+	out := make([]telegraf.Metric, 0, min(n, b.length()))
+	for i, s := range b.segments {
+		if s.id < pos.segment {
+			continue
+		}
+		if s.id > pos.segment {
+			pos = position{segment: s.id}
+		}
+		if len(out) == n {
+			break
+		}
+		if pos.index == s.count {
+			if i < len(b.segments)-1 {
+				continue
+			}
+			break
+		}
+
+		f, err := os.Open(b.segmentPath(s.id))
+		if err != nil {
+			return out, pos, err
+		}
+		if _, err := f.Seek(pos.offset, io.SeekStart); err != nil {
+			f.Close()
+			return out, pos, err
+		}
+		r := bufio.NewReader(f)
+		for pos.index < s.count && len(out) < n {
+			line, err := r.ReadBytes('\n')
+			if err != nil {
+				f.Close()
+				return out, pos, err
+			}
+			pos.offset += int64(len(line))
+			pos.index++
+
+			metrics, err := b.parser.Parse(line)
+			if err != nil || len(metrics) != 1 {
+				log.Printf("E! [outputs.%s] could not parse metric in buffer: %q", b.name, line)
+				continue
+			}
+			out = append(out, metrics[0])
+		}
+		f.Close()
+	}
+	return out, pos, nil
+}
+
+// Accept removes the batch, acquired from Batch(), from the buffer. Metrics
+// of the batch which were discarded while it was written are not counted as
+// written, as they were already counted as dropped.
+func (b *DiskBuffer) Accept(batch []telegraf.Metric) {
+	b.Lock()
+	defer b.Unlock()
+
+	for i, m := range batch {
+		if i < b.batchDropped {
+			m.Accept()
+			continue
+		}
+		b.metricWritten(m)
+	}
+
+	if b.batchSize > 0 {
+		b.head = b.batchEnd
+	}
+	b.resetBatch()
+
+	// Delete the segments which have been written
+	for len(b.segments) > 1 &&
+		(b.head.segment > b.segments[0].id || b.head.index == b.segments[0].count) {
+		b.removeFirst()
+	}
+	b.syncSegment()
+	b.saveCursor()
+	b.updateOldest()
+	b.updateStats()
+}
+
+// Reject leaves the batch, acquired from Batch(), in the buffer, to be
+// returned by the next call to Batch().
+func (b *DiskBuffer) Reject(batch []telegraf.Metric) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.resetBatch()
+	b.updateStats()
+}
+
+// Close syncs and closes the segment which metrics are added to, and releases
+// the buffer's directory.
+func (b *DiskBuffer) Close() error {
+	b.Lock()
+	defer b.Unlock()
+
+	err := b.file.Sync()
+	if cerr := b.file.Close(); err == nil {
+		err = cerr
+	}
+	b.lock.Close()
+	return err
+}
+
+func (b *DiskBuffer) resetBatch() {
+	b.batchEnd = position{}
+	b.batchSize = 0
+	b.batchDropped = 0
+}
+
+// updateOldest reads the timestamp of the oldest metric in the buffer.
+func (b *DiskBuffer) updateOldest() {
+	b.oldest = time.Time{}
+	metrics, _, err := b.read(b.head, 1)
+	if err == nil && len(metrics) == 1 {
+		b.oldest = metrics[0].Time()
+	}
+}
+
+func (b *DiskBuffer) updateStats() {
+	b.BufferSize.Set(int64(b.length()))
+	b.BufferDiskBytes.Set(b.size)
+	if b.oldest.IsZero() || b.length() == 0 {
+		b.BufferOldestAge.Set(0)
+	} else {
+		b.BufferOldestAge.Set(int64(time.Since(b.oldest).Seconds()))
+	}
+}
+
+// saveCursor records head on disk, so that written metrics are not replayed
+// on restart. The cursor is synced and replaced atomically.
+func (b *DiskBuffer) saveCursor() {
+	tmp := filepath.Join(b.dir, cursorFile+".tmp")
+	data := fmt.Sprintf("%d %d %d\n", b.head.segment, b.head.offset, b.head.index)
+	err := writeSynced(tmp, []byte(data))
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(b.dir, cursorFile))
+	}
+	if err == nil {
+		b.syncDir()
+	}
+	// only log the first of consecutive failures, as this is called often
+	if err != nil && !b.cursorFailed {
+		log.Printf("E! [outputs.%s] could not save position in buffer: %s", b.name, err)
+	}
+	b.cursorFailed = err != nil
+}
+
+// readCursor reads the position of head recorded by saveCursor.
+func (b *DiskBuffer) readCursor() (position, error) {
+	var pos position
+	data, err := ioutil.ReadFile(filepath.Join(b.dir, cursorFile))
+	if os.IsNotExist(err) {
+		return pos, nil
+	}
+	if err != nil {
+		return pos, err
+	}
+	_, err = fmt.Sscanf(string(data), "%d %d %d", &pos.segment, &pos.offset, &pos.index)
+	return pos, err
+}
+
+// syncSegment flushes the segment which metrics are added to to disk.
+func (b *DiskBuffer) syncSegment() {
+	if err := b.file.Sync(); err != nil {
+		log.Printf("E! [outputs.%s] could not sync buffer segment: %s", b.name, err)
+	}
+}
+
+// syncDir flushes the buffer's directory to disk, so that created, renamed
+// and removed files persist. Directories cannot be synced on Windows.
+func (b *DiskBuffer) syncDir() {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	d, err := os.Open(b.dir)
+	if err == nil {
+		err = d.Sync()
+		d.Close()
+	}
+	if err != nil {
+		log.Printf("E! [outputs.%s] could not sync buffer directory: %s", b.name, err)
+	}
+}
+
+// writeSynced writes data to the file at path, and syncs it to disk before
+// closing it.
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (b *DiskBuffer) segmentPath(id uint64) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
@@ -0,0 +1,24 @@
+// +build !windows
+
+package models
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// openLockFile opens the file at path, creating it if necessary, and takes an
+// exclusive lock on it without blocking. The lock is released when the file
+// is closed, or when the process exits.
+func openLockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
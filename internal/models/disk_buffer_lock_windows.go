@@ -0,0 +1,22 @@
+package models
+
+import (
+	"os"
+	"syscall"
+)
+
+// openLockFile opens the file at path, creating it if necessary, without
+// sharing it, so that it cannot be opened again until it is closed or the
+// process exits.
+func openLockFile(path string) (*os.File, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(p, syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
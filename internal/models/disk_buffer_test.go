@@ -0,0 +1,271 @@
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestDiskBuffer(t *testing.T, name string, dir string, maxSize int64) *DiskBuffer {
+	b, err := NewDiskBuffer(name, dir, maxSize)
+	require.NoError(t, err)
+	b.MetricsAdded.Set(0)
+	b.MetricsWritten.Set(0)
+	b.MetricsDropped.Set(0)
+	return b
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "disk_buffer")
+	require.NoError(t, err)
+	return dir
+}
+
+func times(metrics []telegraf.Metric) []int64 {
+	out := make([]int64, len(metrics))
+	for i, m := range metrics {
+		out[i] = m.Time().Unix()
+	}
+	return out
+}
+
+func TestDiskBuffer_BatchOldestFirst(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	b := newTestDiskBuffer(t, "disk_batch", dir, 0)
+	defer b.Close()
+
+	for i := int64(1); i <= 5; i++ {
+		b.Add(MetricTime(i))
+	}
+	require.Equal(t, 5, b.Len())
+
+	batch := b.Batch(2)
+	require.Equal(t, []int64{1, 2}, times(batch))
+	require.Equal(t, 5, b.Len())
+	b.Accept(batch)
+	require.Equal(t, 3, b.Len())
+
+	batch = b.Batch(10)
+	require.Equal(t, []int64{3, 4, 5}, times(batch))
+	require.Equal(t, int64(5), b.MetricsAdded.Get())
+	require.Equal(t, int64(0), b.MetricsDropped.Get())
+}
+
+func TestDiskBuffer_RejectLeavesBatch(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	b := newTestDiskBuffer(t, "disk_reject", dir, 0)
+	defer b.Close()
+
+	b.Add(MetricTime(1), MetricTime(2), MetricTime(3))
+	batch := b.Batch(2)
+	b.Reject(batch)
+	require.Equal(t, 3, b.Len())
+
+	// Metrics added while the batch is out are sent after it
+	batch = b.Batch(2)
+	b.Add(MetricTime(4))
+	b.Accept(batch)
+
+	require.Equal(t, []int64{3, 4}, times(b.Batch(10)))
+	require.Equal(t, int64(2), b.MetricsWritten.Get())
+}
+
+func TestDiskBuffer_AddAcceptsMetric(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	b := newTestDiskBuffer(t, "disk_add_accept", dir, 0)
+	defer b.Close()
+
+	var accept int
+	mm := &MockMetric{
+		Metric: Metric(),
+		AcceptF: func() {
+			accept++
+		},
+	}
+	b.Add(mm)
+	require.Equal(t, 1, accept)
+}
+
+func TestDiskBuffer_PreservesFieldTypes(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	b := newTestDiskBuffer(t, "disk_types", dir, 0)
+	defer b.Close()
+
+	m := MetricTime(1)
+	m.AddTag("host", "localhost")
+	m.AddField("int", int64(-1))
+	m.AddField("uint", uint64(1))
+	m.AddField("string", "a \"quoted\" string")
+	m.AddField("bool", true)
+	b.Add(m)
+
+	batch := b.Batch(1)
+	require.Len(t, batch, 1)
+	require.Equal(t, m.Name(), batch[0].Name())
+	require.Equal(t, m.Tags(), batch[0].Tags())
+	require.Equal(t, m.Fields(), batch[0].Fields())
+	require.True(t, m.Time().Equal(batch[0].Time()))
+}
+
+func TestDiskBuffer_Replay(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	b := newTestDiskBuffer(t, "disk_replay", dir, 0)
+
+	for i := int64(1); i <= 5; i++ {
+		b.Add(MetricTime(i))
+	}
+	b.Accept(b.Batch(2))
+	// the rejected batch is replayed
+	b.Batch(2)
+	require.NoError(t, b.Close())
+
+	b = newTestDiskBuffer(t, "disk_replay", dir, 0)
+	defer b.Close()
+	require.Equal(t, 3, b.Len())
+	b.Add(MetricTime(6))
+	require.Equal(t, []int64{3, 4, 5, 6}, times(b.Batch(10)))
+}
+
+func TestDiskBuffer_ReplayRemovesPartialMetric(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	b := newTestDiskBuffer(t, "disk_partial", dir, 0)
+	b.Add(MetricTime(1))
+	require.NoError(t, b.Close())
+
+	// a metric was being written when telegraf stopped
+	f, err := os.OpenFile(b.segmentPath(1), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("cpu value=4")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	b = newTestDiskBuffer(t, "disk_partial", dir, 0)
+	defer b.Close()
+	require.Equal(t, 1, b.Len())
+	b.Add(MetricTime(2))
+	require.Equal(t, []int64{1, 2}, times(b.Batch(10)))
+}
+
+func TestDiskBuffer_MaxSizeDropsOldest(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// each metric takes about 24 bytes, so each segment holds one metric
+	b := newTestDiskBuffer(t, "disk_max_size", dir, 160)
+	defer b.Close()
+
+	for i := int64(1); i <= 10; i++ {
+		b.Add(MetricTime(i))
+	}
+	n := b.Len()
+	require.True(t, n < 10)
+	require.Equal(t, int64(10-n), b.MetricsDropped.Get())
+	require.True(t, b.BufferDiskBytes.Get() <= 160)
+
+	batch := b.Batch(10)
+	require.Len(t, batch, n)
+	require.Equal(t, int64(10), batch[n-1].Time().Unix())
+	require.Equal(t, int64(10-n+1), batch[0].Time().Unix())
+}
+
+func TestDiskBuffer_DiscardedBatchIsNotWritten(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	b := newTestDiskBuffer(t, "disk_discarded_batch", dir, 160)
+	defer b.Close()
+
+	b.Add(MetricTime(1))
+	batch := b.Batch(10)
+	require.Len(t, batch, 1)
+
+	// The batch is discarded while it is being written
+	for i := int64(2); i <= 10; i++ {
+		b.Add(MetricTime(i))
+	}
+	n := b.Len()
+	require.Equal(t, int64(10-n), b.MetricsDropped.Get())
+
+	b.Accept(batch)
+	require.Equal(t, int64(0), b.MetricsWritten.Get())
+	require.Equal(t, n, b.Len())
+}
+
+func TestDiskBuffer_LocksDirectory(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	b := newTestDiskBuffer(t, "disk_lock", dir, 0)
+
+	_, err := NewDiskBuffer("disk_lock_other", dir, 0)
+	require.Error(t, err)
+
+	require.NoError(t, b.Close())
+	b = newTestDiskBuffer(t, "disk_lock_other", dir, 0)
+	require.NoError(t, b.Close())
+}
+
+func TestDiskBuffer_AcceptRemovesSegments(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	b := newTestDiskBuffer(t, "disk_accept_segments", dir, 160)
+	defer b.Close()
+
+	for i := int64(1); i <= 5; i++ {
+		b.Add(MetricTime(i))
+	}
+	b.Accept(b.Batch(10))
+	require.Equal(t, 0, b.Len())
+
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+}
+
+func TestDiskBuffer_AddManyRotatesSegments(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// each segment holds one metric, although all are added in one call
+	b := newTestDiskBuffer(t, "disk_add_many", dir, 160)
+
+	b.Add(MetricTime(1), MetricTime(2), MetricTime(3))
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	require.NoError(t, err)
+	require.Len(t, segments, 3)
+	require.Equal(t, int64(3), b.MetricsAdded.Get())
+	require.NoError(t, b.Close())
+
+	b = newTestDiskBuffer(t, "disk_add_many", dir, 160)
+	defer b.Close()
+	require.Equal(t, []int64{1, 2, 3}, times(b.Batch(10)))
+}
+
+func TestDiskBuffer_Stats(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	b := newTestDiskBuffer(t, "disk_stats", dir, 0)
+	defer b.Close()
+
+	require.Equal(t, int64(0), b.BufferDiskBytes.Get())
+	require.Equal(t, int64(0), b.BufferOldestAge.Get())
+
+	old := time.Now().Add(-time.Hour).Unix()
+	b.Add(MetricTime(old), MetricTime(old+60))
+	require.Equal(t, int64(2), b.BufferSize.Get())
+	require.True(t, b.BufferDiskBytes.Get() > 0)
+	require.InDelta(t, 3600, b.BufferOldestAge.Get(), 5)
+
+	b.Accept(b.Batch(1))
+	require.InDelta(t, 3540, b.BufferOldestAge.Get(), 5)
+	b.Accept(b.Batch(1))
+	require.Equal(t, int64(0), b.BufferOldestAge.Get())
+	require.Equal(t, int64(0), b.BufferSize.Get())
+}
@@ -12,6 +12,7 @@ type RunningAggregator struct {
 	sync.Mutex
 	Aggregator  telegraf.Aggregator
 	Config      *AggregatorConfig
+	Digest      string
 	periodStart time.Time
 	periodEnd   time.Time
 
@@ -57,6 +58,8 @@ type AggregatorConfig struct {
 	DropOriginal bool
 	Period       time.Duration
 	Delay        time.Duration
+	// Stage is the stage of the pipeline the aggregator runs in
+	Stage int64
 
 	NameOverride      string
 	MeasurementPrefix string
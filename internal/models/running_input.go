@@ -1,9 +1,11 @@
 package models
 
 import (
+	"context"
 	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/schedule"
 	"github.com/influxdata/telegraf/selfstat"
 )
 
@@ -12,11 +14,15 @@ var GlobalMetricsGathered = selfstat.Register("agent", "metrics_gathered", map[s
 type RunningInput struct {
 	Input  telegraf.Input
 	Config *InputConfig
+	// Digest of the input's table, which changes with its settings
+	Digest string
 
 	defaultTags map[string]string
 
 	MetricsGathered selfstat.Stat
 	GatherTime      selfstat.Stat
+	GatherTimeouts  selfstat.Stat
+	GathersSkipped  selfstat.Stat
 }
 
 func NewRunningInput(input telegraf.Input, config *InputConfig) *RunningInput {
@@ -33,6 +39,16 @@ func NewRunningInput(input telegraf.Input, config *InputConfig) *RunningInput {
 			"gather_time_ns",
 			map[string]string{"input": config.Name},
 		),
+		GatherTimeouts: selfstat.Register(
+			"gather",
+			"gather_timeouts",
+			map[string]string{"input": config.Name},
+		),
+		GathersSkipped: selfstat.Register(
+			"gather",
+			"gathers_skipped",
+			map[string]string{"input": config.Name},
+		),
 	}
 }
 
@@ -40,6 +56,10 @@ func NewRunningInput(input telegraf.Input, config *InputConfig) *RunningInput {
 type InputConfig struct {
 	Name     string
 	Interval time.Duration
+	// GatherTimeout is the period after which a gather is canceled, if set
+	GatherTimeout time.Duration
+	// Schedule, if set, is used instead of Interval to run the input
+	Schedule schedule.Schedule
 
 	NameOverride      string
 	MeasurementPrefix string
@@ -82,8 +102,19 @@ func (r *RunningInput) MakeMetric(metric telegraf.Metric) telegraf.Metric {
 }
 
 func (r *RunningInput) Gather(acc telegraf.Accumulator) error {
+	return r.GatherContext(context.Background(), acc)
+}
+
+// GatherContext gathers from the input, passing the context to it if it is
+// a telegraf.ContextInput.
+func (r *RunningInput) GatherContext(ctx context.Context, acc telegraf.Accumulator) error {
 	start := time.Now()
-	err := r.Input.Gather(acc)
+	var err error
+	if ci, ok := r.Input.(telegraf.ContextInput); ok {
+		err = ci.GatherContext(ctx, acc)
+	} else {
+		err = r.Input.Gather(acc)
+	}
 	elapsed := time.Since(start)
 	r.GatherTime.Incr(elapsed.Nanoseconds())
 	return err
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"sync"
 	"sync/atomic"
@@ -26,6 +28,31 @@ type OutputConfig struct {
 	FlushInterval     time.Duration
 	MetricBufferLimit int
 	MetricBatchSize   int
+
+	// The directory of the output's disk buffer, if metrics are buffered on
+	// disk rather than in memory, and its maximum size in bytes
+	MetricBufferPath    string
+	MetricBufferMaxSize int64
+
+	// Processors are the names of the processors which only process the
+	// metrics of this output, in the order they are applied
+	Processors []string
+}
+
+// MetricBuffer stores the metrics which have not yet been written by an
+// output.
+type MetricBuffer interface {
+	// Len returns the number of metrics in the buffer.
+	Len() int
+	// Add adds metrics to the buffer.
+	Add(metrics ...telegraf.Metric)
+	// Batch returns up to batchSize metrics, which must be passed to Accept
+	// or Reject.
+	Batch(batchSize int) []telegraf.Metric
+	// Accept removes the batch from the buffer.
+	Accept(batch []telegraf.Metric)
+	// Reject returns the batch to the buffer.
+	Reject(batch []telegraf.Metric)
 }
 
 // RunningOutput contains the output configuration
@@ -39,12 +66,16 @@ type RunningOutput struct {
 	MetricBufferLimit int
 	MetricBatchSize   int
 
+	// Digest of the output's table, so that an output is only restarted on
+	// reload if its settings changed
+	Digest string
+
 	MetricsFiltered selfstat.Stat
 	WriteTime       selfstat.Stat
 
 	BatchReady chan time.Time
 
-	buffer *Buffer
+	buffer MetricBuffer
 
 	aggMutex sync.Mutex
 }
@@ -70,7 +101,6 @@ func NewRunningOutput(
 	}
 	ro := &RunningOutput{
 		Name:              name,
-		buffer:            NewBuffer(name, bufferLimit),
 		BatchReady:        make(chan time.Time, 1),
 		Output:            output,
 		Config:            conf,
@@ -87,10 +117,39 @@ func NewRunningOutput(
 			map[string]string{"output": name},
 		),
 	}
+	// a disk buffer is opened by InitBuffer
+	if conf.MetricBufferPath == "" {
+		ro.buffer = NewBuffer(name, bufferLimit)
+	}
 
 	return ro
 }
 
+// InitBuffer opens the output's disk buffer, if one is configured, replaying
+// the metrics which it holds. It must be called before metrics are added.
+func (ro *RunningOutput) InitBuffer() error {
+	if ro.Config.MetricBufferPath == "" || ro.buffer != nil {
+		return nil
+	}
+	buffer, err := NewDiskBuffer(ro.Name, ro.Config.MetricBufferPath, ro.Config.MetricBufferMaxSize)
+	if err != nil {
+		return fmt.Errorf("could not open buffer of output %s: %s", ro.Name, err)
+	}
+	ro.buffer = buffer
+	return nil
+}
+
+// Close closes the output and its buffer.
+func (ro *RunningOutput) Close() error {
+	err := ro.Output.Close()
+	if closer, ok := ro.buffer.(io.Closer); ok {
+		if cerr := closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
 func (ro *RunningOutput) metricFiltered(metric telegraf.Metric) {
 	ro.MetricsFiltered.Incr(1)
 	metric.Drop()
@@ -195,6 +254,11 @@ func (ro *RunningOutput) write(metrics []telegraf.Metric) error {
 
 func (ro *RunningOutput) LogBufferStatus() {
 	nBuffer := ro.buffer.Len()
+	if ro.Config.MetricBufferPath != "" {
+		log.Printf("D! [outputs.%s] buffer fullness: %d metrics on disk. ",
+			ro.Name, nBuffer)
+		return
+	}
 	log.Printf("D! [outputs.%s] buffer fullness: %d / %d metrics. ",
 		ro.Name, nBuffer, ro.MetricBufferLimit)
 }
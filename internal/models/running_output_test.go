@@ -2,6 +2,7 @@ package models
 
 import (
 	"fmt"
+	"os"
 	"sync"
 	"testing"
 
@@ -270,6 +271,42 @@ func TestRunningOutputWriteFail(t *testing.T) {
 	assert.Len(t, m.Metrics(), 10)
 }
 
+// Test that metrics which could not be written are kept in the disk buffer
+// after a restart.
+func TestRunningOutputDiskBuffer(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	conf := &OutputConfig{
+		Filter:           Filter{},
+		MetricBufferPath: dir,
+	}
+
+	m := &mockOutput{}
+	m.failWrite = true
+	ro := NewRunningOutput("test_disk", m, conf, 4, 12)
+	require.NoError(t, ro.InitBuffer())
+
+	for _, metric := range first5 {
+		ro.AddMetric(metric)
+	}
+	require.Error(t, ro.Write())
+	require.NoError(t, ro.Close())
+
+	m.failWrite = false
+	ro = NewRunningOutput("test_disk", m, conf, 4, 12)
+	require.NoError(t, ro.InitBuffer())
+	defer ro.Close()
+	for _, metric := range next5 {
+		ro.AddMetric(metric)
+	}
+	require.NoError(t, ro.Write())
+
+	// Metrics are written oldest first
+	assert.Len(t, m.Metrics(), 10)
+	assert.Equal(t, first5[0].Name(), m.Metrics()[0].Name())
+	assert.Equal(t, next5[4].Name(), m.Metrics()[9].Name())
+}
+
 // Verify that the order of points is preserved during a write failure.
 func TestRunningOutputWriteFailOrder(t *testing.T) {
 	conf := &OutputConfig{
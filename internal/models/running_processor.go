@@ -12,6 +12,7 @@ type RunningProcessor struct {
 	sync.Mutex
 	Processor telegraf.Processor
 	Config    *ProcessorConfig
+	Digest    string
 }
 
 type RunningProcessors []*RunningProcessor
@@ -25,6 +26,11 @@ type ProcessorConfig struct {
 	Name   string
 	Order  int64
 	Filter Filter
+
+	// Alias is the name by which outputs reference the processor
+	Alias string
+	// Stage is the stage of the pipeline the processor runs in
+	Stage int64
 }
 
 func (rp *RunningProcessor) metricFiltered(metric telegraf.Metric) {
@@ -0,0 +1,248 @@
+// Package schedule parses cron expressions and calendar-style schedules.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule returns the times at which something runs.
+type Schedule interface {
+	// Next returns the first time in the schedule after t, in t's location,
+	// or the zero time if there is none within five years.
+	Next(t time.Time) time.Time
+}
+
+// descriptors are the cron expressions of the predefined schedules.
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a schedule, which is either:
+//
+//   - a cron expression of five fields: minute, hour, day of month, month and
+//     day of week, such as "*/15 * * * *" or "0 2 * * mon-fri"
+//   - a predefined schedule: @yearly, @monthly, @weekly, @daily or @hourly
+//   - a time of day, such as "at 02:00 daily" or "at 18:30 on mon,thu",
+//     where the days are given as in the day of week field of a cron
+//     expression
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if expr, ok := descriptors[spec]; ok {
+		return parseCron(expr)
+	}
+	if strings.HasPrefix(spec, "at ") {
+		return parseCalendar(spec)
+	}
+	return parseCron(spec)
+}
+
+// cron matches the times whose fields are set in its bitsets.
+type cron struct {
+	minute, hour, dom, month, dow uint64
+	// A time matches either day field if both are restricted, and both of
+	// them if either is *.
+	domStar, dowStar bool
+}
+
+type bounds struct {
+	min, max uint
+	names    map[string]uint
+	// ends holds the values of names which end a range that they would
+	// otherwise precede
+	ends map[string]uint
+}
+
+var (
+	minutes = bounds{0, 59, nil, nil}
+	hours   = bounds{0, 23, nil, nil}
+	doms    = bounds{1, 31, nil, nil}
+	months  = bounds{1, 12, map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}, nil}
+	// Both 0 and 7 are Sunday, so that it can end a range such as fri-sun
+	dows = bounds{0, 7, map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}, map[string]uint{"sun": 7}}
+)
+
+func parseCron(spec string) (*cron, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields, found %d",
+			spec, len(fields))
+	}
+
+	var c cron
+	var err error
+	if c.minute, err = parseField(fields[0], minutes); err != nil {
+		return nil, err
+	}
+	if c.hour, err = parseField(fields[1], hours); err != nil {
+		return nil, err
+	}
+	if c.dom, err = parseField(fields[2], doms); err != nil {
+		return nil, err
+	}
+	if c.month, err = parseField(fields[3], months); err != nil {
+		return nil, err
+	}
+	if c.dow, err = parseDays(fields[4]); err != nil {
+		return nil, err
+	}
+	c.domStar = fields[2] == "*"
+	c.dowStar = fields[4] == "*"
+	return &c, nil
+}
+
+// parseCalendar parses "at HH:MM daily" and "at HH:MM on DAYS".
+func parseCalendar(spec string) (*cron, error) {
+	fields := strings.Fields(spec)
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid schedule %q", spec)
+	}
+
+	tod, err := time.Parse("15:04", fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid time of day in schedule %q", spec)
+	}
+
+	c := &cron{
+		minute:  1 << uint(tod.Minute()),
+		hour:    1 << uint(tod.Hour()),
+		dom:     span(doms.min, doms.max, 1),
+		month:   span(months.min, months.max, 1),
+		domStar: true,
+	}
+
+	switch {
+	case len(fields) == 3 && fields[2] == "daily":
+		c.dow = span(0, 6, 1)
+		c.dowStar = true
+	case len(fields) == 4 && fields[2] == "on":
+		if c.dow, err = parseDays(fields[3]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("invalid schedule %q, expected daily or on DAYS after the time", spec)
+	}
+	return c, nil
+}
+
+// parseDays parses a day of week field, counting Sunday as 0.
+func parseDays(field string) (uint64, error) {
+	bits, err := parseField(field, dows)
+	if err != nil {
+		return 0, err
+	}
+	if bits&(1<<7) != 0 {
+		bits = bits&^(1<<7) | 1
+	}
+	return bits, nil
+}
+
+// parseField parses a comma separated list of values, ranges and steps,
+// such as "1,5-10,*/15", into a bitset.
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangeAndStep := strings.SplitN(part, "/", 2)
+
+		lo, hi := b.min, b.max
+		if rangeAndStep[0] != "*" {
+			ends := strings.SplitN(rangeAndStep[0], "-", 2)
+			var err error
+			if lo, err = b.value(ends[0]); err != nil {
+				return 0, err
+			}
+			switch {
+			case len(ends) == 2:
+				if hi, err = b.value(ends[1]); err != nil {
+					return 0, err
+				}
+				if end, ok := b.ends[strings.ToLower(ends[1])]; ok && hi < lo {
+					hi = end
+				}
+			case len(rangeAndStep) == 1:
+				hi = lo
+			}
+		}
+
+		step := uint64(1)
+		if len(rangeAndStep) == 2 {
+			var err error
+			step, err = strconv.ParseUint(rangeAndStep[1], 10, 8)
+			if err != nil || step == 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		if lo > hi {
+			return 0, fmt.Errorf("invalid range in %q", part)
+		}
+		bits |= span(lo, hi, uint(step))
+	}
+	return bits, nil
+}
+
+// value parses a number or name within the bounds.
+func (b bounds) value(s string) (uint, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil || uint(v) < b.min || uint(v) > b.max {
+		return 0, fmt.Errorf("invalid value %q, must be between %d and %d",
+			s, b.min, b.max)
+	}
+	return uint(v), nil
+}
+
+// span returns a bitset of every step from lo to hi.
+func span(lo, hi, step uint) uint64 {
+	var bits uint64
+	for v := lo; v <= hi; v += step {
+		bits |= 1 << v
+	}
+	return bits
+}
+
+func (c *cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+
+	for t.Before(end) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
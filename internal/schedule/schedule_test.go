@@ -0,0 +1,109 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNext(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2018, time.October, 17, 10, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		next time.Time
+	}{
+		{"*/15 * * * *", time.Date(2018, time.October, 17, 10, 15, 0, 0, time.UTC)},
+		{"* * * * *", time.Date(2018, time.October, 17, 10, 8, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2018, time.October, 17, 10, 25, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2018, time.October, 18, 2, 0, 0, 0, time.UTC)},
+		{"0 9-17 * * mon-fri", time.Date(2018, time.October, 17, 11, 0, 0, 0, time.UTC)},
+		{"30 6 * * sat,sun", time.Date(2018, time.October, 20, 6, 30, 0, 0, time.UTC)},
+		{"30 6 * * sat-sun", time.Date(2018, time.October, 20, 6, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2018, time.October, 21, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2019, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 * *", time.Date(2018, time.October, 31, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are restricted
+		{"0 0 1 * fri", time.Date(2018, time.October, 19, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2018, time.October, 17, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2018, time.October, 18, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2018, time.October, 21, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2018, time.November, 1, 0, 0, 0, 0, time.UTC)},
+		{"at 02:00 daily", time.Date(2018, time.October, 18, 2, 0, 0, 0, time.UTC)},
+		{"at 18:30 daily", time.Date(2018, time.October, 17, 18, 30, 0, 0, time.UTC)},
+		{"at 02:00 on mon,thu", time.Date(2018, time.October, 18, 2, 0, 0, 0, time.UTC)},
+		{"at 10:00 on wed", time.Date(2018, time.October, 24, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			require.NoError(t, err)
+			assert.Equal(t, tt.next, s.Next(now))
+		})
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	s, err := Parse("0 0 30 feb *")
+	require.NoError(t, err)
+	assert.True(t, s.Next(time.Now()).IsZero())
+}
+
+func TestNextKeepsLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	s, err := Parse("at 02:00 daily")
+	require.NoError(t, err)
+
+	now := time.Date(2018, time.October, 17, 10, 0, 0, 0, loc)
+	assert.Equal(t, time.Date(2018, time.October, 18, 2, 0, 0, 0, loc), s.Next(now))
+}
+
+func TestParseDays(t *testing.T) {
+	tests := []struct {
+		field string
+		days  []uint
+	}{
+		{"fri-sun", []uint{0, 5, 6}},
+		{"sat-sun", []uint{0, 6}},
+		{"sun-tue", []uint{0, 1, 2}},
+		{"sun", []uint{0}},
+		{"5-7", []uint{0, 5, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			var expected uint64
+			for _, d := range tt.days {
+				expected |= 1 << d
+			}
+			bits, err := parseDays(tt.field)
+			require.NoError(t, err)
+			assert.Equal(t, expected, bits)
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"10-5 * * * *",
+		"* * * foo *",
+		"at 25:00 daily",
+		"at 02:00",
+		"at 02:00 weekly",
+		"at 02:00 on someday",
+	} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
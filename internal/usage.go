@@ -25,6 +25,8 @@ The commands & flags are:
   --pprof-addr <address>         pprof address to listen on, don't activate pprof if empty
   --processor-filter <filter>    filter the processors to enable, separator is :
   --quiet                        run in quiet mode
+  --reload-mode <mode>           how to reload the config on SIGHUP: restart all
+                                 plugins, or diff to only restart changed plugins
   --sample-config                print out full sample configuration
   --test                         gather metrics, print them out, and exit;
                                  processors, aggregators, and outputs are not run
@@ -22,7 +22,9 @@ import (
 	_ "github.com/influxdata/telegraf/plugins/inputs/cpu"
 	_ "github.com/influxdata/telegraf/plugins/inputs/dcos"
 	_ "github.com/influxdata/telegraf/plugins/inputs/dcos_containers"
+	_ "github.com/influxdata/telegraf/plugins/inputs/dcos_mesos_master"
 	_ "github.com/influxdata/telegraf/plugins/inputs/dcos_statsd"
+	_ "github.com/influxdata/telegraf/plugins/inputs/dcos_task_events"
 	_ "github.com/influxdata/telegraf/plugins/inputs/disk"
 	_ "github.com/influxdata/telegraf/plugins/inputs/diskio"
 	_ "github.com/influxdata/telegraf/plugins/inputs/disque"
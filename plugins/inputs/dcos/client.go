@@ -1,7 +1,6 @@
 package dcos
 
 import (
-	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
@@ -10,19 +9,13 @@ import (
 	"net/url"
 	"time"
 
-	jwt "github.com/dgrijalva/jwt-go"
-)
-
-const (
-	// How long to stayed logged in for
-	loginDuration = 65 * time.Minute
+	"github.com/influxdata/telegraf/dcosutil"
 )
 
 // Client is an interface for communicating with the DC/OS API.
 type Client interface {
 	SetToken(token string)
 
-	Login(ctx context.Context, sa *ServiceAccount) (*AuthToken, error)
 	GetSummary(ctx context.Context) (*Summary, error)
 	GetContainers(ctx context.Context, node string) ([]Container, error)
 	GetNodeMetrics(ctx context.Context, node string) (*Metrics, error)
@@ -37,24 +30,6 @@ type APIError struct {
 	Description string
 }
 
-// Login is request data for logging in.
-type Login struct {
-	UID   string `json:"uid"`
-	Exp   int64  `json:"exp"`
-	Token string `json:"token"`
-}
-
-// LoginError is the response when login fails.
-type LoginError struct {
-	Title       string `json:"title"`
-	Description string `json:"description"`
-}
-
-// LoginAuth is the response to a successful login.
-type LoginAuth struct {
-	Token string `json:"token"`
-}
-
 // Slave is a node in the cluster.
 type Slave struct {
 	ID string `json:"id"`
@@ -84,24 +59,12 @@ type Metrics struct {
 	Dimensions map[string]interface{} `json:"dimensions"`
 }
 
-// AuthToken is the authentication token.
-type AuthToken struct {
-	Text   string
-	Expire time.Time
-}
-
 // ClusterClient is a Client that uses the cluster URL.
 type ClusterClient struct {
-	clusterURL  *url.URL
-	httpClient  *http.Client
-	credentials *Credentials
-	token       string
-	semaphore   chan struct{}
-}
-
-type claims struct {
-	UID string `json:"uid"`
-	jwt.StandardClaims
+	clusterURL *url.URL
+	httpClient *http.Client
+	token      string
+	semaphore  chan struct{}
 }
 
 func (e APIError) Error() string {
@@ -138,73 +101,11 @@ func (c *ClusterClient) SetToken(token string) {
 	c.token = token
 }
 
-func (c *ClusterClient) Login(ctx context.Context, sa *ServiceAccount) (*AuthToken, error) {
-	token, err := c.createLoginToken(sa)
-	if err != nil {
-		return nil, err
-	}
-
-	exp := time.Now().Add(loginDuration)
-
-	body := &Login{
-		UID:   sa.AccountID,
-		Exp:   exp.Unix(),
-		Token: token,
-	}
-
-	octets, err := json.Marshal(body)
-	if err != nil {
-		return nil, err
-	}
-
-	loc := c.url("/acs/api/v1/auth/login")
-	req, err := http.NewRequest("POST", loc, bytes.NewBuffer(octets))
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Add("Content-Type", "application/json")
-
-	req = req.WithContext(ctx)
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusOK {
-		auth := &LoginAuth{}
-		dec := json.NewDecoder(resp.Body)
-		err = dec.Decode(auth)
-		if err != nil {
-			return nil, err
-		}
-
-		token := &AuthToken{
-			Text:   auth.Token,
-			Expire: exp,
-		}
-		return token, nil
-	}
-
-	loginError := &LoginError{}
-	dec := json.NewDecoder(resp.Body)
-	err = dec.Decode(loginError)
-	if err != nil {
-		err := &APIError{
-			URL:        loc,
-			StatusCode: resp.StatusCode,
-			Title:      resp.Status,
-		}
-		return nil, err
-	}
-
-	err = &APIError{
-		URL:         loc,
-		StatusCode:  resp.StatusCode,
-		Title:       loginError.Title,
-		Description: loginError.Description,
-	}
-	return nil, err
+// Authenticate logs in to the cluster as a service account, whose token
+// authorizes all subsequent requests
+func (c *ClusterClient) Authenticate(account dcosutil.ServiceAccount) {
+	auth := dcosutil.NewAuthenticator(account, c.httpClient.Transport)
+	c.httpClient.Transport = dcosutil.NewAuthRoundTripper(c.httpClient.Transport, auth)
 }
 
 func (c *ClusterClient) GetSummary(ctx context.Context) (*Summary, error) {
@@ -324,14 +225,3 @@ func (c *ClusterClient) url(path string) string {
 	url.Path = path
 	return url.String()
 }
-
-func (c *ClusterClient) createLoginToken(sa *ServiceAccount) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims{
-		UID: sa.AccountID,
-		StandardClaims: jwt.StandardClaims{
-			// How long we have to login with this token
-			ExpiresAt: time.Now().Add(5 * time.Minute).Unix(),
-		},
-	})
-	return token.SignedString(sa.PrivateKey)
-}
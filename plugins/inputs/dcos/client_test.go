@@ -8,14 +8,14 @@ import (
 	"net/url"
 	"testing"
 
-	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/influxdata/telegraf/dcosutil"
 	"github.com/influxdata/telegraf/testutil"
 	"github.com/stretchr/testify/require"
 )
 
 var privateKey = testutil.NewPKI("../../../testutil/pki").ReadServerKey()
 
-func TestLogin(t *testing.T) {
+func TestAuthenticate(t *testing.T) {
 	ts := httptest.NewServer(http.NotFoundHandler())
 	defer ts.Close()
 
@@ -37,7 +37,7 @@ func TestLogin(t *testing.T) {
 			name:         "Unauthorized Error",
 			responseCode: http.StatusUnauthorized,
 			responseBody: `{"title": "x", "description": "y"}`,
-			expectedError: &APIError{
+			expectedError: &dcosutil.IAMError{
 				URL:         ts.URL + "/acs/api/v1/auth/login",
 				StatusCode:  http.StatusUnauthorized,
 				Title:       "x",
@@ -47,33 +47,36 @@ func TestLogin(t *testing.T) {
 		},
 	}
 
-	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(privateKey))
-	require.NoError(t, err)
-
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			var token string
 			ts.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(tt.responseCode)
-				fmt.Fprintln(w, tt.responseBody)
+				if r.URL.Path == "/acs/api/v1/auth/login" {
+					w.WriteHeader(tt.responseCode)
+					fmt.Fprintln(w, tt.responseBody)
+					return
+				}
+				token = r.Header.Get("Authorization")
+				fmt.Fprintln(w, `{"cluster": "a", "slaves": []}`)
 			})
 
 			u, err := url.Parse(ts.URL)
 			require.NoError(t, err)
 
+			account, err := dcosutil.NewServiceAccount("telegraf", []byte(privateKey), ts.URL+"/acs/api/v1/auth/login")
+			require.NoError(t, err)
+
 			ctx := context.Background()
-			sa := &ServiceAccount{
-				AccountID:  "telegraf",
-				PrivateKey: key,
-			}
 			client := NewClusterClient(u, defaultResponseTimeout, 1, nil)
-			auth, err := client.Login(ctx, sa)
-
-			require.Equal(t, tt.expectedError, err)
+			client.Authenticate(account)
+			_, err = client.GetSummary(ctx)
 
-			if tt.expectedToken != "" {
-				require.Equal(t, tt.expectedToken, auth.Text)
+			if tt.expectedError != nil {
+				require.IsType(t, &url.Error{}, err)
+				require.Equal(t, tt.expectedError, err.(*url.Error).Err)
 			} else {
-				require.Nil(t, auth)
+				require.NoError(t, err)
+				require.Equal(t, "token="+tt.expectedToken, token)
 			}
 		})
 	}
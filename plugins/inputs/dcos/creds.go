@@ -2,29 +2,16 @@ package dcos
 
 import (
 	"context"
-	"crypto/rsa"
 	"fmt"
 	"io/ioutil"
 	"strings"
-	"time"
 	"unicode/utf8"
 )
 
-const (
-	// How long before expiration to renew token
-	relogDuration = 5 * time.Minute
-)
-
+// Credentials provide the token with which requests are made. Service accounts
+// are authenticated by the client itself; see ClusterClient.Authenticate.
 type Credentials interface {
-	Token(ctx context.Context, client Client) (string, error)
-	IsExpired() bool
-}
-
-type ServiceAccount struct {
-	AccountID  string
-	PrivateKey *rsa.PrivateKey
-
-	auth *AuthToken
+	Token(ctx context.Context) (string, error)
 }
 
 type TokenCreds struct {
@@ -34,20 +21,7 @@ type TokenCreds struct {
 type NullCreds struct {
 }
 
-func (c *ServiceAccount) Token(ctx context.Context, client Client) (string, error) {
-	auth, err := client.Login(ctx, c)
-	if err != nil {
-		return "", err
-	}
-	c.auth = auth
-	return auth.Text, nil
-}
-
-func (c *ServiceAccount) IsExpired() bool {
-	return c.auth.Text != "" || c.auth.Expire.Add(relogDuration).After(time.Now())
-}
-
-func (c *TokenCreds) Token(ctx context.Context, client Client) (string, error) {
+func (c *TokenCreds) Token(ctx context.Context) (string, error) {
 	octets, err := ioutil.ReadFile(c.Path)
 	if err != nil {
 		return "", fmt.Errorf("Error reading token file %q: %s", c.Path, err)
@@ -59,14 +33,6 @@ func (c *TokenCreds) Token(ctx context.Context, client Client) (string, error) {
 	return token, nil
 }
 
-func (c *TokenCreds) IsExpired() bool {
-	return true
-}
-
-func (c *NullCreds) Token(ctx context.Context, client Client) (string, error) {
+func (c *NullCreds) Token(ctx context.Context) (string, error) {
 	return "", nil
 }
-
-func (c *NullCreds) IsExpired() bool {
-	return true
-}
@@ -9,8 +9,8 @@ import (
 	"sync"
 	"time"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/dcosutil"
 	"github.com/influxdata/telegraf/filter"
 	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/internal/tls"
@@ -20,6 +20,9 @@ import (
 const (
 	defaultMaxConnections  = 10
 	defaultResponseTimeout = 20 * time.Second
+
+	// loginPath is the path of the IAM login endpoint on the cluster
+	loginPath = "/acs/api/v1/auth/login"
 )
 
 var (
@@ -128,7 +131,7 @@ func (d *DCOS) Gather(acc telegraf.Accumulator) error {
 
 	ctx := context.Background()
 
-	token, err := d.creds.Token(ctx, d.client)
+	token, err := d.creds.Token(ctx)
 	if err != nil {
 		return err
 	}
@@ -365,26 +368,26 @@ func (d *DCOS) createClient() (Client, error) {
 		tlsCfg,
 	)
 
-	return client, nil
-}
-
-func (d *DCOS) createCredentials() (Credentials, error) {
 	if d.ServiceAccountID != "" && d.ServiceAccountPrivateKey != "" {
 		bs, err := ioutil.ReadFile(d.ServiceAccountPrivateKey)
 		if err != nil {
 			return nil, err
 		}
 
-		privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(bs)
+		account, err := dcosutil.NewServiceAccount(d.ServiceAccountID, bs, client.url(loginPath))
 		if err != nil {
 			return nil, err
 		}
+		client.Authenticate(account)
+	}
 
-		creds := &ServiceAccount{
-			AccountID:  d.ServiceAccountID,
-			PrivateKey: privateKey,
-		}
-		return creds, nil
+	return client, nil
+}
+
+func (d *DCOS) createCredentials() (Credentials, error) {
+	if d.ServiceAccountID != "" && d.ServiceAccountPrivateKey != "" {
+		// The client authenticates the service account itself
+		return &NullCreds{}, nil
 	} else if d.TokenFile != "" {
 		creds := &TokenCreds{
 			Path: d.TokenFile,
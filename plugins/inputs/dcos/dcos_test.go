@@ -11,7 +11,6 @@ import (
 
 type mockClient struct {
 	SetTokenF            func(token string)
-	LoginF               func(ctx context.Context, sa *ServiceAccount) (*AuthToken, error)
 	GetSummaryF          func(ctx context.Context) (*Summary, error)
 	GetContainersF       func(ctx context.Context, node string) ([]Container, error)
 	GetNodeMetricsF      func(ctx context.Context, node string) (*Metrics, error)
@@ -23,10 +22,6 @@ func (c *mockClient) SetToken(token string) {
 	c.SetTokenF(token)
 }
 
-func (c *mockClient) Login(ctx context.Context, sa *ServiceAccount) (*AuthToken, error) {
-	return c.LoginF(ctx, sa)
-}
-
 func (c *mockClient) GetSummary(ctx context.Context) (*Summary, error) {
 	return c.GetSummaryF(ctx)
 }
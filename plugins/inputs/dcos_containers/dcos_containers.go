@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"math"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,6 +29,12 @@ const sampleConfig = `
   # timeout = "10s"
   ## The user agent to send with requests
   user_agent = "Telegraf-dcos-containers"
+  ## Add fields derived from consecutive samples and container limits, such
+  ## as cpu usage in cores and memory usage as a percent of the limit
+  derived_fields = true
+  ## Add a measurement for each pod, tagged with the pod's executor container
+  ## ID, which sums the resource usage of the pod's tasks
+  # pod_aggregates = false
   ## Optional IAM configuration
   # ca_certificate_path = "/run/dcos/pki/CA/ca-bundle.crt"
   # iam_config_path = "/run/dcos/etc/dcos-telegraf/service_account.json"
@@ -37,7 +44,11 @@ const sampleConfig = `
 type DCOSContainers struct {
 	MesosAgentUrl string
 	Timeout       internal.Duration
+	DerivedFields bool
+	PodAggregates bool
 	client        *httpcli.Client
+	// samples holds the previous sample of each container, by container ID
+	samples map[string]containerSample
 	dcosutil.DCOSConfig
 }
 
@@ -97,23 +108,55 @@ func (dc *DCOSContainers) Gather(acc telegraf.Accumulator) error {
 		return err
 	}
 
+	samples := map[string]containerSample{}
+	pods := map[string]*podAggregate{}
 	for _, c := range gc.Containers {
 		ts, tsOK := cTS(c)
 		tags := cTags(c)
-		for _, m := range cMeasurements(c) {
-			if len(m.fields) > 0 {
-				if tsOK {
-					acc.AddFields(m.name, m.fields, m.combineTags(tags), ts)
-				} else {
-					acc.AddFields(m.name, m.fields, m.combineTags(tags))
-				}
+		ms := cMeasurements(c)
+		if dc.DerivedFields {
+			dc.derive(c, ms, samples)
+		}
+		addMeasurements(acc, ms, tags, ts, tsOK)
+
+		// the tasks of a pod are the direct children of its executor container
+		if dc.PodAggregates && containerLevel(c.ContainerID) == 1 {
+			pid := c.ContainerID.GetParent().GetValue()
+			if _, ok := pods[pid]; !ok {
+				pods[pid] = newPodAggregate()
 			}
+			pods[pid].add(ms, ts, tsOK)
+		}
+	}
+	// samples of containers which have gone away are discarded
+	dc.samples = samples
+
+	for pid, pa := range pods {
+		ms := make([]measurement, 0, len(pa.measurements))
+		for _, m := range pa.measurements {
+			ms = append(ms, m)
 		}
+		tags := map[string]string{"container_id": pid, "aggregate": "pod"}
+		addMeasurements(acc, ms, tags, pa.ts, pa.tsOK)
 	}
 
 	return nil
 }
 
+// addMeasurements adds each measurement which has fields to the accumulator,
+// combined with the container's tags
+func addMeasurements(acc telegraf.Accumulator, ms []measurement, tags map[string]string, ts time.Time, tsOK bool) {
+	for _, m := range ms {
+		if len(m.fields) > 0 {
+			if tsOK {
+				acc.AddFields(m.name, m.fields, m.combineTags(tags), ts)
+			} else {
+				acc.AddFields(m.name, m.fields, m.combineTags(tags))
+			}
+		}
+	}
+}
+
 // getContainers requests a list of containers from the operator API
 func (dc *DCOSContainers) getContainers(ctx context.Context, cli calls.Sender) (*agent.Response_GetContainers, error) {
 	resp, err := cli.Send(ctx, calls.NonStreaming(calls.GetContainers()))
@@ -528,9 +571,16 @@ func cNetTrafficControlStatistics(tcs []mesos.TrafficControlStatistics) []measur
 	return results
 }
 
-// cTags extracts relevant metadata from a Container object as a map of tags
+// cTags extracts relevant metadata from a Container object as a map of tags.
+// Nested containers are also tagged with the ID of their parent container and
+// their depth in the hierarchy.
 func cTags(c agent.Response_GetContainers_Container) map[string]string {
-	return map[string]string{"container_id": c.ContainerID.Value}
+	tags := map[string]string{"container_id": c.ContainerID.Value}
+	if p := c.ContainerID.GetParent(); p != nil {
+		tags["parent_container_id"] = p.GetValue()
+		tags["container_level"] = strconv.Itoa(containerLevel(c.ContainerID))
+	}
+	return tags
 }
 
 // cTS retrieves the timestamp from a Container object as a time rounded to the
@@ -586,7 +636,8 @@ func warnIfNotSet(err error) {
 func init() {
 	inputs.Add("dcos_containers", func() telegraf.Input {
 		return &DCOSContainers{
-			Timeout: internal.Duration{Duration: 10 * time.Second},
+			Timeout:       internal.Duration{Duration: 10 * time.Second},
+			DerivedFields: true,
 		}
 	})
 }
@@ -6,6 +6,8 @@ import (
 
 	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/testutil"
+	"github.com/mesos/mesos-go/api/v1/lib"
+	"github.com/mesos/mesos-go/api/v1/lib/agent"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -259,6 +261,65 @@ func TestGather(t *testing.T) {
 	}
 }
 
+func TestGatherNested(t *testing.T) {
+	server := startTestServer(t, "nested")
+	defer server.Close()
+
+	dc := DCOSContainers{
+		MesosAgentUrl: server.URL,
+		Timeout:       internal.Duration{Duration: 500 * time.Millisecond},
+		PodAggregates: true,
+	}
+
+	var acc testutil.Accumulator
+	assert.Nil(t, acc.GatherError(dc.Gather))
+
+	// The executor container has no parent
+	acc.AssertContainsTaggedFields(t, "cpus",
+		map[string]interface{}{
+			"limit":            0.1,
+			"system_time_secs": 0.5,
+			"user_time_secs":   1.5,
+		},
+		map[string]string{"container_id": "abc123"})
+	// The tasks of the pod are children of the executor container
+	acc.AssertContainsTaggedFields(t, "cpus",
+		map[string]interface{}{
+			"limit":            0.5,
+			"system_time_secs": float64(1),
+			"user_time_secs":   float64(4),
+		},
+		map[string]string{
+			"container_id":        "ghi789",
+			"parent_container_id": "abc123",
+			"container_level":     "1",
+		})
+	// The debug container is a child of a task
+	acc.AssertContainsTaggedFields(t, "mem",
+		map[string]interface{}{
+			"rss_bytes": uint64(1048576),
+		},
+		map[string]string{
+			"container_id":        "jkl012",
+			"parent_container_id": "def456",
+			"container_level":     "2",
+		})
+	// The pod aggregate sums the tasks, but not the executor or debug container
+	acc.AssertContainsTaggedFields(t, "cpus",
+		map[string]interface{}{
+			"limit":            1.5,
+			"system_time_secs": float64(3),
+			"user_time_secs":   float64(14),
+		},
+		map[string]string{"container_id": "abc123", "aggregate": "pod"})
+	acc.AssertContainsTaggedFields(t, "mem",
+		map[string]interface{}{
+			"limit_bytes": uint64(402653184),
+			"rss_bytes":   uint64(157286400),
+		},
+		map[string]string{"container_id": "abc123", "aggregate": "pod"})
+}
+
 func TestSetIfNotNil(t *testing.T) {
 	t.Run("Legal set methods which return concrete values", func(t *testing.T) {
 		mmap := make(map[string]interface{})
@@ -308,6 +369,74 @@ func TestSetIfNotNil(t *testing.T) {
 	})
 }
 
+func TestDerive(t *testing.T) {
+	dc := DCOSContainers{DerivedFields: true}
+
+	newContainer := func(ts, user, system, throttled float64, rx, tx uint64) agent.Response_GetContainers_Container {
+		return agent.Response_GetContainers_Container{
+			ContainerID: mesos.ContainerID{Value: "abc123"},
+			ResourceStatistics: &mesos.ResourceStatistics{
+				Timestamp:             ts,
+				CPUsUserTimeSecs:      &user,
+				CPUsSystemTimeSecs:    &system,
+				CPUsLimit:             float64p(2),
+				CPUsThrottledTimeSecs: &throttled,
+				MemTotalBytes:         uint64p(512 * 1024 * 1024),
+				MemLimitBytes:         uint64p(1024 * 1024 * 1024),
+				NetRxBytes:            &rx,
+				NetTxBytes:            &tx,
+			},
+		}
+	}
+	fields := func(ms []measurement, name string) map[string]interface{} {
+		for _, m := range ms {
+			if m.name == name && len(m.tags) == 0 {
+				return m.fields
+			}
+		}
+		return nil
+	}
+
+	// The first sample only yields fields relative to the container's limits
+	samples := map[string]containerSample{}
+	c := newContainer(100, 10, 5, 1, 1000, 2000)
+	ms := cMeasurements(c)
+	dc.derive(c, ms, samples)
+	dc.samples = samples
+	assert.Equal(t, float64(50), fields(ms, "mem")["usage_percent"])
+	assert.NotContains(t, fields(ms, "cpus"), "usage_cores")
+	assert.NotContains(t, fields(ms, "net"), "rx_bytes_per_sec")
+
+	// The second sample yields rates
+	samples = map[string]containerSample{}
+	c = newContainer(110, 20, 10, 3, 11000, 2000)
+	ms = cMeasurements(c)
+	dc.derive(c, ms, samples)
+	dc.samples = samples
+	assert.InDelta(t, 1.5, fields(ms, "cpus")["usage_cores"], 1e-9)
+	assert.InDelta(t, 75, fields(ms, "cpus")["usage_percent"], 1e-9)
+	assert.InDelta(t, 0.2, fields(ms, "cpus")["throttled_time_ratio"], 1e-9)
+	assert.InDelta(t, 1000, fields(ms, "net")["rx_bytes_per_sec"], 1e-9)
+	assert.InDelta(t, 0, fields(ms, "net")["tx_bytes_per_sec"], 1e-9)
+}
+
+func TestDeriveCleanup(t *testing.T) {
+	server := startTestServer(t, "empty")
+	defer server.Close()
+
+	dc := DCOSContainers{
+		MesosAgentUrl: server.URL,
+		Timeout:       internal.Duration{Duration: 500 * time.Millisecond},
+		DerivedFields: true,
+		samples:       map[string]containerSample{"abc123": {}},
+	}
+
+	// Samples of containers which are no longer running are discarded
+	var acc testutil.Accumulator
+	assert.Nil(t, acc.GatherError(dc.Gather))
+	assert.Empty(t, dc.samples)
+}
+
 func TestGetClient(t *testing.T) {
 	dc := DCOSContainers{}
 	client1, err1 := dc.getClient()
@@ -330,3 +459,11 @@ func assertHasTimestamp(t *testing.T, acc *testutil.Accumulator, measurement str
 	}
 	t.Errorf("%s could not be retrieved while attempting to assert it had timestamp", measurement)
 }
+
+func float64p(f float64) *float64 {
+	return &f
+}
+
+func uint64p(u uint64) *uint64 {
+	return &u
+}
@@ -0,0 +1,115 @@
+package dcos_containers
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mesos/mesos-go/api/v1/lib/agent"
+)
+
+// rateFields are the counter fields, by measurement name, for which a per
+// second rate is derived. The rate is added to the same measurement as the
+// counter, with a _per_sec suffix.
+var rateFields = map[string][]string{
+	"net":   {"rx_bytes", "tx_bytes"},
+	"blkio": {"io_service_bytes_read", "io_service_bytes_write", "io_service_bytes_total"},
+}
+
+// containerSample holds the counters of a container from a previous call to
+// Gather, from which rates are derived
+type containerSample struct {
+	timestamp     float64
+	cpusTimeSecs  float64
+	throttledSecs float64
+	// counters holds the values of rateFields, keyed by counterKey
+	counters map[string]uint64
+}
+
+// derive adds fields derived from a container's resource statistics to its
+// measurements. Fields which are relative to a container's limits are derived
+// from the current sample alone. Rates are derived from the difference to the
+// previous sample of the same container, if there was one. The current sample
+// is added to next.
+func (dc *DCOSContainers) derive(c agent.Response_GetContainers_Container,
+	ms []measurement, next map[string]containerSample) {
+	rs := c.GetResourceStatistics()
+	if rs == nil {
+		return
+	}
+
+	cid := c.ContainerID.Value
+	cur := containerSample{
+		timestamp:     rs.GetTimestamp(),
+		cpusTimeSecs:  rs.GetCPUsUserTimeSecs() + rs.GetCPUsSystemTimeSecs(),
+		throttledSecs: rs.GetCPUsThrottledTimeSecs(),
+		counters:      map[string]uint64{},
+	}
+	for _, m := range ms {
+		for _, f := range rateFields[m.name] {
+			if v, ok := m.fields[f].(uint64); ok {
+				cur.counters[counterKey(m, f)] = v
+			}
+		}
+	}
+	next[cid] = cur
+
+	for _, m := range ms {
+		switch m.name {
+		case "mem":
+			if limit := rs.GetMemLimitBytes(); limit > 0 && rs.GetMemTotalBytes() > 0 {
+				m.fields["usage_percent"] = float64(rs.GetMemTotalBytes()) / float64(limit) * 100
+			}
+		}
+	}
+
+	prev, ok := dc.samples[cid]
+	if !ok {
+		return
+	}
+	elapsed := cur.timestamp - prev.timestamp
+	if elapsed <= 0 {
+		return
+	}
+
+	for _, m := range ms {
+		switch {
+		case m.name == "cpus" && len(m.tags) == 0:
+			if delta := cur.cpusTimeSecs - prev.cpusTimeSecs; delta >= 0 && cur.cpusTimeSecs > 0 {
+				usage := delta / elapsed
+				m.fields["usage_cores"] = usage
+				if limit := rs.GetCPUsLimit(); limit > 0 {
+					m.fields["usage_percent"] = usage / limit * 100
+				}
+			}
+			if delta := cur.throttledSecs - prev.throttledSecs; delta >= 0 && cur.throttledSecs > 0 {
+				m.fields["throttled_time_ratio"] = delta / elapsed
+			}
+		default:
+			for _, f := range rateFields[m.name] {
+				key := counterKey(m, f)
+				v, ok := cur.counters[key]
+				if !ok {
+					continue
+				}
+				pv, ok := prev.counters[key]
+				// counters which went backwards were reset, and yield no rate
+				if !ok || v < pv {
+					continue
+				}
+				m.fields[f+"_per_sec"] = float64(v-pv) / elapsed
+			}
+		}
+	}
+}
+
+// counterKey uniquely identifies a counter field of a measurement within a
+// container, so that measurements which share a name but differ by tags, such
+// as blkio measurements for each device, are kept apart
+func counterKey(m measurement, field string) string {
+	tags := make([]string, 0, len(m.tags))
+	for k, v := range m.tags {
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+	return m.name + "," + strings.Join(tags, ",") + "," + field
+}
@@ -0,0 +1,97 @@
+package dcos_containers
+
+import (
+	"time"
+
+	"github.com/mesos/mesos-go/api/v1/lib"
+)
+
+// podMeasurements are the measurements which are summed across the children
+// of a pod's executor container. Nested containers share the network
+// namespace of their parent, so net measurements would be counted once for
+// each child, and are not summed.
+var podMeasurements = []string{"container", "cpus", "mem", "disk"}
+
+// nonAdditiveFields are fields which are meaningless when summed across
+// containers, and are omitted from pod aggregates
+var nonAdditiveFields = map[string]bool{
+	"usage_percent":        true,
+	"throttled_time_ratio": true,
+}
+
+// podAggregate accumulates the measurements of the tasks in a pod, that is
+// the direct children of an executor container
+type podAggregate struct {
+	ts           time.Time
+	tsOK         bool
+	measurements map[string]measurement
+}
+
+// newPodAggregate returns an empty podAggregate
+func newPodAggregate() *podAggregate {
+	pa := &podAggregate{measurements: map[string]measurement{}}
+	for _, name := range podMeasurements {
+		pa.measurements[name] = newMeasurement(name)
+	}
+	return pa
+}
+
+// add sums a child container's measurements into the aggregate. Measurements
+// with tags, such as those for individual devices or volumes, are skipped.
+// The aggregate takes the timestamp of its most recent child.
+func (pa *podAggregate) add(ms []measurement, ts time.Time, tsOK bool) {
+	if tsOK && (!pa.tsOK || ts.After(pa.ts)) {
+		pa.ts = ts
+		pa.tsOK = true
+	}
+	for _, m := range ms {
+		agg, ok := pa.measurements[m.name]
+		if !ok || len(m.tags) > 0 {
+			continue
+		}
+		for k, v := range m.fields {
+			if nonAdditiveFields[k] {
+				continue
+			}
+			if prev, ok := agg.fields[k]; ok {
+				v = sumField(prev, v)
+			}
+			agg.fields[k] = v
+		}
+	}
+}
+
+// sumField adds two field values of the same numeric type. If the types
+// differ, the first value is returned unchanged.
+func sumField(a, b interface{}) interface{} {
+	switch a := a.(type) {
+	case uint32:
+		if b, ok := b.(uint32); ok {
+			return a + b
+		}
+	case uint64:
+		if b, ok := b.(uint64); ok {
+			return a + b
+		}
+	case int64:
+		if b, ok := b.(int64); ok {
+			return a + b
+		}
+	case float64:
+		if b, ok := b.(float64); ok {
+			return a + b
+		}
+	}
+	return a
+}
+
+// containerLevel returns the depth of a container in the hierarchy of nested
+// containers. Top-level containers, such as executor containers, have level
+// 0, and the tasks of a pod have level 1.
+func containerLevel(cid mesos.ContainerID) int {
+	level := 0
+	for p := cid.GetParent(); p != nil; p = p.GetParent() {
+		level++
+	}
+	return level
+}
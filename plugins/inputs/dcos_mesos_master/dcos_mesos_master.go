@@ -0,0 +1,354 @@
+package dcos_mesos_master
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/dcosutil"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/inputs"
+
+	"github.com/mesos/mesos-go/api/v1/lib"
+	"github.com/mesos/mesos-go/api/v1/lib/httpcli"
+	"github.com/mesos/mesos-go/api/v1/lib/httpcli/httpmaster"
+	"github.com/mesos/mesos-go/api/v1/lib/master"
+	"github.com/mesos/mesos-go/api/v1/lib/master/calls"
+)
+
+const sampleConfig = `
+  ## The URL of the leading mesos master
+  mesos_master_url = "http://leader.mesos:5050"
+  ## The period after which requests to mesos master should time out
+  # timeout = "10s"
+  ## The user agent to send with requests
+  user_agent = "Telegraf-dcos-mesos-master"
+  ## Optional IAM configuration
+  # ca_certificate_path = "/run/dcos/pki/CA/ca-bundle.crt"
+  # iam_config_path = "/run/dcos/etc/dcos-telegraf/service_account.json"
+`
+
+// defaultRole is the role of frameworks and resources which do not name one
+const defaultRole = "*"
+
+// DCOSMesosMaster describes the options available to this plugin
+type DCOSMesosMaster struct {
+	MesosMasterUrl string
+	Timeout        internal.Duration
+	client         *httpcli.Client
+	dcosutil.DCOSConfig
+}
+
+// allocation holds the scalar resources of a framework or role, by resource
+// name
+type allocation struct {
+	allocated map[string]float64
+	offered   map[string]float64
+	pending   map[string]float64
+	quota     map[string]float64
+}
+
+// newAllocation is a convenience method for instantiating new allocations
+func newAllocation() *allocation {
+	return &allocation{
+		allocated: make(map[string]float64),
+		offered:   make(map[string]float64),
+		pending:   make(map[string]float64),
+		quota:     make(map[string]float64),
+	}
+}
+
+// addFields adds a field for each resource of the allocation, suffixed with
+// the kind of allocation, eg cpus_allocated
+func (a *allocation) addFields(fields map[string]interface{}) {
+	for suffix, resources := range map[string]map[string]float64{
+		"allocated": a.allocated,
+		"offered":   a.offered,
+		"pending":   a.pending,
+		"quota":     a.quota,
+	} {
+		for name, value := range resources {
+			fields[name+"_"+suffix] = value
+		}
+	}
+}
+
+// frameworkRole identifies the resources of a framework which are allocated
+// to one of its roles. Frameworks are identified by ID, as several may share
+// a name.
+type frameworkRole struct {
+	frameworkID string
+	role        string
+}
+
+// taskGroup identifies the tasks of a framework and role in a state
+type taskGroup struct {
+	frameworkID string
+	role        string
+	state       string
+}
+
+// SampleConfig returns the default configuration
+func (dm *DCOSMesosMaster) SampleConfig() string {
+	return sampleConfig
+}
+
+// Description returns a one-sentence description of dcos_mesos_master
+func (dm *DCOSMesosMaster) Description() string {
+	return "Plugin for monitoring the allocation of resources by the mesos master"
+}
+
+// Gather takes in an accumulator and adds the metrics that the plugin gathers.
+// It is invoked on a schedule (default every 10s) by the telegraf runtime.
+func (dm *DCOSMesosMaster) Gather(acc telegraf.Accumulator) error {
+	client, err := dm.getClient()
+	if err != nil {
+		return err
+	}
+
+	cli := httpmaster.NewSender(client.Send)
+	ctx, cancel := context.WithTimeout(context.Background(), dm.Timeout.Duration)
+	defer cancel()
+
+	gf, err := send(ctx, cli, calls.GetFrameworks(), master.Response_GET_FRAMEWORKS)
+	if err != nil {
+		return err
+	}
+	gr, err := send(ctx, cli, calls.GetRoles(), master.Response_GET_ROLES)
+	if err != nil {
+		return err
+	}
+	ga, err := send(ctx, cli, calls.GetAgents(), master.Response_GET_AGENTS)
+	if err != nil {
+		return err
+	}
+	gt, err := send(ctx, cli, calls.GetTasks(), master.Response_GET_TASKS)
+	if err != nil {
+		return err
+	}
+	// Reading quota requires a separate permission; the remaining metrics are
+	// still reported without it
+	gq, err := send(ctx, cli, calls.GetQuota(), master.Response_GET_QUOTA)
+	if err != nil {
+		acc.AddError(fmt.Errorf("error getting quota from %s: %s", dm.MesosMasterUrl, err))
+	}
+
+	frameworks := gf.GetGetFrameworks().GetFrameworks()
+	tasks := gt.GetGetTasks()
+
+	// framework names and default roles by framework ID
+	names := make(map[string]string, len(frameworks))
+	defaultRoles := make(map[string]string, len(frameworks))
+	for _, f := range frameworks {
+		fi := f.GetFrameworkInfo()
+		names[fi.GetID().GetValue()] = fi.GetName()
+		defaultRoles[fi.GetID().GetValue()] = getFrameworkRole(fi)
+	}
+
+	fAllocations := map[frameworkRole]*allocation{}
+	rAllocations := map[string]*allocation{}
+	fAllocation := func(fid, role string) *allocation {
+		key := frameworkRole{frameworkID: fid, role: role}
+		if _, ok := fAllocations[key]; !ok {
+			fAllocations[key] = newAllocation()
+		}
+		return fAllocations[key]
+	}
+	rAllocation := func(role string) *allocation {
+		if _, ok := rAllocations[role]; !ok {
+			rAllocations[role] = newAllocation()
+		}
+		return rAllocations[role]
+	}
+
+	for _, f := range frameworks {
+		fid := f.GetFrameworkInfo().GetID().GetValue()
+		fAllocation(fid, defaultRoles[fid])
+		for _, r := range f.GetAllocatedResources() {
+			addScalar(fAllocation(fid, getResourceRole(r, defaultRoles[fid])).allocated, r)
+		}
+		for _, r := range f.GetOfferedResources() {
+			role := getResourceRole(r, defaultRoles[fid])
+			addScalar(fAllocation(fid, role).offered, r)
+			addScalar(rAllocation(role).offered, r)
+		}
+	}
+
+	// Pending tasks have been accepted by the master, but not yet launched
+	for _, t := range tasks.GetPendingTasks() {
+		fid := t.GetFrameworkID().GetValue()
+		for _, r := range t.GetResources() {
+			role := getResourceRole(r, defaultRoles[fid])
+			addScalar(fAllocation(fid, role).pending, r)
+			addScalar(rAllocation(role).pending, r)
+		}
+	}
+
+	weights := map[string]float64{}
+	frameworkCounts := map[string]int{}
+	for _, r := range gr.GetGetRoles().GetRoles() {
+		a := rAllocation(r.GetName())
+		for _, res := range r.GetResources() {
+			addScalar(a.allocated, res)
+		}
+		weights[r.GetName()] = r.GetWeight()
+		frameworkCounts[r.GetName()] = len(r.GetFrameworks())
+	}
+
+	for _, q := range gq.GetGetQuota().GetStatus().GetInfos() {
+		a := rAllocation(q.GetRole())
+		for _, r := range q.GetGuarantee() {
+			addScalar(a.quota, r)
+		}
+	}
+
+	frameworkFields := make(map[string]map[string]interface{}, len(frameworks))
+	for _, f := range frameworks {
+		frameworkFields[f.GetFrameworkInfo().GetID().GetValue()] = map[string]interface{}{
+			"active":    f.GetActive(),
+			"connected": f.GetConnected(),
+		}
+	}
+	for key, a := range fAllocations {
+		fields := map[string]interface{}{}
+		for k, v := range frameworkFields[key.frameworkID] {
+			fields[k] = v
+		}
+		a.addFields(fields)
+		acc.AddFields("dcos_mesos_master_framework", fields, map[string]string{
+			"framework_id":   key.frameworkID,
+			"framework_name": names[key.frameworkID],
+			"role":           key.role,
+		})
+	}
+
+	for role, a := range rAllocations {
+		fields := map[string]interface{}{}
+		if w, ok := weights[role]; ok {
+			fields["weight"] = w
+			fields["frameworks"] = frameworkCounts[role]
+		}
+		a.addFields(fields)
+		if len(fields) > 0 {
+			acc.AddFields("dcos_mesos_master_role", fields, map[string]string{"role": role})
+		}
+	}
+
+	agents := ga.GetGetAgents()
+	agentCounts := map[string]int{
+		"active":    0,
+		"inactive":  0,
+		"recovered": len(agents.GetRecoveredAgents()),
+	}
+	for _, a := range agents.GetAgents() {
+		if a.GetActive() {
+			agentCounts["active"]++
+		} else {
+			agentCounts["inactive"]++
+		}
+	}
+	for state, count := range agentCounts {
+		acc.AddFields("dcos_mesos_master_agents", map[string]interface{}{"count": count}, map[string]string{"state": state})
+	}
+
+	taskCounts := map[taskGroup]int{}
+	for _, ts := range [][]mesos.Task{tasks.GetPendingTasks(), tasks.GetTasks(), tasks.GetUnreachableTasks()} {
+		for _, t := range ts {
+			fid := t.GetFrameworkID().GetValue()
+			taskCounts[taskGroup{
+				frameworkID: fid,
+				role:        getTaskRole(t, defaultRoles[fid]),
+				state:       getTaskState(t),
+			}]++
+		}
+	}
+	for key, count := range taskCounts {
+		acc.AddFields("dcos_mesos_master_tasks", map[string]interface{}{"count": count}, map[string]string{
+			"framework_id":   key.frameworkID,
+			"framework_name": names[key.frameworkID],
+			"role":           key.role,
+			"state":          key.state,
+		})
+	}
+
+	return nil
+}
+
+// getClient returns the *httpcli.Client configured to make requests to Mesos that is a member of dm. If it hasn't been
+// created yet, it is created and then returned.
+func (dm *DCOSMesosMaster) getClient() (*httpcli.Client, error) {
+	if dm.client == nil {
+		client, err := dcosutil.MesosClient(dm.MesosMasterUrl, dm.DCOSConfig)
+		if err != nil {
+			return nil, err
+		}
+		dm.client = client
+	}
+	return dm.client, nil
+}
+
+// send makes a non-streaming call to the operator API and returns its
+// response, which must be of type t
+func send(ctx context.Context, cli calls.Sender, call *master.Call, t master.Response_Type) (master.Response, error) {
+	resp, err := cli.Send(ctx, calls.NonStreaming(call))
+	if err != nil {
+		return master.Response{}, err
+	}
+	return dcosutil.ProcessMasterResponse(resp, t)
+}
+
+// addScalar adds the value of a scalar resource to resources
+func addScalar(resources map[string]float64, r mesos.Resource) {
+	if r.GetType() != mesos.SCALAR {
+		return
+	}
+	resources[r.GetName()] += r.GetScalar().GetValue()
+}
+
+// getFrameworkRole returns the role of a framework. The resources of a
+// multi-role framework are attributed to its first role, unless they name
+// another.
+func getFrameworkRole(fi mesos.FrameworkInfo) string {
+	if roles := fi.GetRoles(); len(roles) > 0 {
+		return roles[0]
+	}
+	if role := fi.GetRole(); role != "" {
+		return role
+	}
+	return defaultRole
+}
+
+// getResourceRole returns the role to which a resource is allocated, or
+// fallback if it does not name one
+func getResourceRole(r mesos.Resource, fallback string) string {
+	if role := r.GetAllocationInfo().GetRole(); role != "" {
+		return role
+	}
+	return fallback
+}
+
+// getTaskRole returns the role to which the resources of a task are allocated
+func getTaskRole(t mesos.Task, fallback string) string {
+	for _, r := range t.GetResources() {
+		if role := r.GetAllocationInfo().GetRole(); role != "" {
+			return role
+		}
+	}
+	return fallback
+}
+
+// getTaskState returns the state of a task in lower case, without its TASK_
+// prefix, eg running
+func getTaskState(t mesos.Task) string {
+	return strings.ToLower(strings.TrimPrefix(t.GetState().String(), "TASK_"))
+}
+
+func init() {
+	inputs.Add("dcos_mesos_master", func() telegraf.Input {
+		return &DCOSMesosMaster{
+			Timeout: internal.Duration{Duration: 10 * time.Second},
+		}
+	})
+}
@@ -0,0 +1,328 @@
+package dcos_mesos_master
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/mesos/mesos-go/api/v1/lib"
+	"github.com/mesos/mesos-go/api/v1/lib/master"
+	"github.com/mesos/mesos-go/api/v1/lib/quota"
+	"github.com/stretchr/testify/assert"
+)
+
+type expectedMetric struct {
+	measurement string
+	fields      map[string]interface{}
+	tags        map[string]string
+}
+
+// responses returns the responses of a master with two frameworks: marathon,
+// which has the single role slave_public, and kafka, which is multi-role
+func responses() map[master.Call_Type]master.Response {
+	return map[master.Call_Type]master.Response{
+		master.Call_GET_FRAMEWORKS: {
+			Type: master.Response_GET_FRAMEWORKS,
+			GetFrameworks: &master.Response_GetFrameworks{
+				Frameworks: []master.Response_GetFrameworks_Framework{
+					{
+						FrameworkInfo: mesos.FrameworkInfo{
+							ID:   &mesos.FrameworkID{Value: "marathon.id"},
+							Name: "marathon",
+							Role: stringPtr("slave_public"),
+						},
+						Active:             true,
+						Connected:          true,
+						AllocatedResources: []mesos.Resource{scalar("cpus", 2, ""), scalar("mem", 512, "")},
+						OfferedResources:   []mesos.Resource{scalar("cpus", 1, "")},
+					},
+					{
+						FrameworkInfo: mesos.FrameworkInfo{
+							ID:    &mesos.FrameworkID{Value: "kafka.id"},
+							Name:  "kafka",
+							Roles: []string{"kafka-role", "other"},
+						},
+						Active:             true,
+						Connected:          false,
+						AllocatedResources: []mesos.Resource{scalar("cpus", 4, "kafka-role"), scalar("cpus", 1, "other")},
+					},
+				},
+			},
+		},
+		master.Call_GET_ROLES: {
+			Type: master.Response_GET_ROLES,
+			GetRoles: &master.Response_GetRoles{
+				Roles: []mesos.Role{
+					{
+						Name:       "slave_public",
+						Weight:     1,
+						Frameworks: []mesos.FrameworkID{{Value: "marathon.id"}},
+						Resources:  []mesos.Resource{scalar("cpus", 2, ""), scalar("mem", 512, "")},
+					},
+					{
+						Name:       "kafka-role",
+						Weight:     2,
+						Frameworks: []mesos.FrameworkID{{Value: "kafka.id"}},
+						Resources:  []mesos.Resource{scalar("cpus", 4, "kafka-role")},
+					},
+					{
+						Name:       "other",
+						Weight:     1,
+						Frameworks: []mesos.FrameworkID{{Value: "kafka.id"}},
+						Resources:  []mesos.Resource{scalar("cpus", 1, "other")},
+					},
+				},
+			},
+		},
+		master.Call_GET_QUOTA: {
+			Type: master.Response_GET_QUOTA,
+			GetQuota: &master.Response_GetQuota{
+				Status: quota.QuotaStatus{
+					Infos: []quota.QuotaInfo{
+						{
+							Role:      stringPtr("kafka-role"),
+							Guarantee: []mesos.Resource{scalar("cpus", 8, "")},
+						},
+					},
+				},
+			},
+		},
+		master.Call_GET_AGENTS: {
+			Type: master.Response_GET_AGENTS,
+			GetAgents: &master.Response_GetAgents{
+				Agents: []master.Response_GetAgents_Agent{
+					{AgentInfo: mesos.AgentInfo{Hostname: "agent1"}, Active: true},
+					{AgentInfo: mesos.AgentInfo{Hostname: "agent2"}, Active: true},
+					{AgentInfo: mesos.AgentInfo{Hostname: "agent3"}, Active: false},
+				},
+				RecoveredAgents: []mesos.AgentInfo{{Hostname: "agent4"}},
+			},
+		},
+		master.Call_GET_TASKS: {
+			Type: master.Response_GET_TASKS,
+			GetTasks: &master.Response_GetTasks{
+				PendingTasks: []mesos.Task{
+					newTask("task1", "marathon.id", mesos.TASK_STAGING, scalar("cpus", 0.5, "")),
+				},
+				Tasks: []mesos.Task{
+					newTask("task2", "marathon.id", mesos.TASK_RUNNING, scalar("cpus", 1, "")),
+					newTask("task3", "marathon.id", mesos.TASK_RUNNING, scalar("cpus", 1, "")),
+					newTask("task4", "kafka.id", mesos.TASK_RUNNING, scalar("cpus", 4, "kafka-role")),
+				},
+				UnreachableTasks: []mesos.Task{
+					newTask("task5", "marathon.id", mesos.TASK_UNREACHABLE, scalar("cpus", 1, "")),
+				},
+			},
+		},
+	}
+}
+
+func TestGather(t *testing.T) {
+	server := startTestServer(t, responses())
+	defer server.Close()
+
+	dm := DCOSMesosMaster{
+		MesosMasterUrl: server.URL,
+		Timeout:        internal.Duration{Duration: 100 * time.Millisecond},
+	}
+
+	var acc testutil.Accumulator
+	err := acc.GatherError(dm.Gather)
+	assert.Nil(t, err)
+
+	expected := []expectedMetric{
+		{
+			measurement: "dcos_mesos_master_framework",
+			fields: map[string]interface{}{
+				"active":         true,
+				"connected":      true,
+				"cpus_allocated": 2.0,
+				"mem_allocated":  512.0,
+				"cpus_offered":   1.0,
+				"cpus_pending":   0.5,
+			},
+			tags: map[string]string{"framework_id": "marathon.id", "framework_name": "marathon", "role": "slave_public"},
+		},
+		{
+			measurement: "dcos_mesos_master_framework",
+			fields: map[string]interface{}{
+				"active":         true,
+				"connected":      false,
+				"cpus_allocated": 4.0,
+			},
+			tags: map[string]string{"framework_id": "kafka.id", "framework_name": "kafka", "role": "kafka-role"},
+		},
+		{
+			measurement: "dcos_mesos_master_framework",
+			fields: map[string]interface{}{
+				"active":         true,
+				"connected":      false,
+				"cpus_allocated": 1.0,
+			},
+			tags: map[string]string{"framework_id": "kafka.id", "framework_name": "kafka", "role": "other"},
+		},
+		{
+			measurement: "dcos_mesos_master_role",
+			fields: map[string]interface{}{
+				"weight":         1.0,
+				"frameworks":     1,
+				"cpus_allocated": 2.0,
+				"mem_allocated":  512.0,
+				"cpus_offered":   1.0,
+				"cpus_pending":   0.5,
+			},
+			tags: map[string]string{"role": "slave_public"},
+		},
+		{
+			measurement: "dcos_mesos_master_role",
+			fields: map[string]interface{}{
+				"weight":         2.0,
+				"frameworks":     1,
+				"cpus_allocated": 4.0,
+				"cpus_quota":     8.0,
+			},
+			tags: map[string]string{"role": "kafka-role"},
+		},
+		{
+			measurement: "dcos_mesos_master_role",
+			fields: map[string]interface{}{
+				"weight":         1.0,
+				"frameworks":     1,
+				"cpus_allocated": 1.0,
+			},
+			tags: map[string]string{"role": "other"},
+		},
+		{"dcos_mesos_master_agents", map[string]interface{}{"count": 2}, map[string]string{"state": "active"}},
+		{"dcos_mesos_master_agents", map[string]interface{}{"count": 1}, map[string]string{"state": "inactive"}},
+		{"dcos_mesos_master_agents", map[string]interface{}{"count": 1}, map[string]string{"state": "recovered"}},
+		{
+			measurement: "dcos_mesos_master_tasks",
+			fields:      map[string]interface{}{"count": 1},
+			tags:        map[string]string{"framework_id": "marathon.id", "framework_name": "marathon", "role": "slave_public", "state": "staging"},
+		},
+		{
+			measurement: "dcos_mesos_master_tasks",
+			fields:      map[string]interface{}{"count": 2},
+			tags:        map[string]string{"framework_id": "marathon.id", "framework_name": "marathon", "role": "slave_public", "state": "running"},
+		},
+		{
+			measurement: "dcos_mesos_master_tasks",
+			fields:      map[string]interface{}{"count": 1},
+			tags:        map[string]string{"framework_id": "marathon.id", "framework_name": "marathon", "role": "slave_public", "state": "unreachable"},
+		},
+		{
+			measurement: "dcos_mesos_master_tasks",
+			fields:      map[string]interface{}{"count": 1},
+			tags:        map[string]string{"framework_id": "kafka.id", "framework_name": "kafka", "role": "kafka-role", "state": "running"},
+		},
+	}
+
+	for _, m := range expected {
+		acc.AssertContainsTaggedFields(t, m.measurement, m.fields, m.tags)
+	}
+	assert.Equal(t, uint64(len(expected)), acc.NMetrics())
+}
+
+func TestGatherWithoutQuota(t *testing.T) {
+	// A service account may not be permitted to read quota
+	rs := responses()
+	delete(rs, master.Call_GET_QUOTA)
+	server := startTestServer(t, rs)
+	defer server.Close()
+
+	dm := DCOSMesosMaster{
+		MesosMasterUrl: server.URL,
+		Timeout:        internal.Duration{Duration: 100 * time.Millisecond},
+	}
+
+	var acc testutil.Accumulator
+	err := acc.GatherError(dm.Gather)
+	assert.NotNil(t, err)
+
+	acc.AssertContainsTaggedFields(t, "dcos_mesos_master_role",
+		map[string]interface{}{
+			"weight":         2.0,
+			"frameworks":     1,
+			"cpus_allocated": 4.0,
+		},
+		map[string]string{"role": "kafka-role"},
+	)
+	assert.True(t, acc.HasMeasurement("dcos_mesos_master_tasks"))
+}
+
+func TestGatherFrameworksWithSameName(t *testing.T) {
+	// Frameworks are identified by ID, so those which share a name are
+	// reported separately
+	rs := responses()
+	gf := rs[master.Call_GET_FRAMEWORKS]
+	gf.GetFrameworks.Frameworks = append(gf.GetFrameworks.Frameworks, master.Response_GetFrameworks_Framework{
+		FrameworkInfo: mesos.FrameworkInfo{
+			ID:   &mesos.FrameworkID{Value: "marathon-user.id"},
+			Name: "marathon",
+			Role: stringPtr("slave_public"),
+		},
+		Active:             false,
+		Connected:          false,
+		AllocatedResources: []mesos.Resource{scalar("cpus", 3, "")},
+	})
+	server := startTestServer(t, rs)
+	defer server.Close()
+
+	dm := DCOSMesosMaster{
+		MesosMasterUrl: server.URL,
+		Timeout:        internal.Duration{Duration: 100 * time.Millisecond},
+	}
+
+	var acc testutil.Accumulator
+	err := acc.GatherError(dm.Gather)
+	assert.Nil(t, err)
+
+	acc.AssertContainsTaggedFields(t, "dcos_mesos_master_framework",
+		map[string]interface{}{
+			"active":         false,
+			"connected":      false,
+			"cpus_allocated": 3.0,
+		},
+		map[string]string{"framework_id": "marathon-user.id", "framework_name": "marathon", "role": "slave_public"},
+	)
+	acc.AssertContainsTaggedFields(t, "dcos_mesos_master_framework",
+		map[string]interface{}{
+			"active":         true,
+			"connected":      true,
+			"cpus_allocated": 2.0,
+			"mem_allocated":  512.0,
+			"cpus_offered":   1.0,
+			"cpus_pending":   0.5,
+		},
+		map[string]string{"framework_id": "marathon.id", "framework_name": "marathon", "role": "slave_public"},
+	)
+}
+
+// scalar returns a scalar resource, allocated to role unless it is empty
+func scalar(name string, value float64, role string) mesos.Resource {
+	r := mesos.Resource{
+		Name:   name,
+		Type:   mesos.SCALAR.Enum(),
+		Scalar: &mesos.Value_Scalar{Value: value},
+	}
+	if role != "" {
+		r.AllocationInfo = &mesos.Resource_AllocationInfo{Role: stringPtr(role)}
+	}
+	return r
+}
+
+func newTask(name, frameworkID string, state mesos.TaskState, resources ...mesos.Resource) mesos.Task {
+	return mesos.Task{
+		Name:        name,
+		TaskID:      mesos.TaskID{Value: name},
+		FrameworkID: mesos.FrameworkID{Value: frameworkID},
+		AgentID:     mesos.AgentID{Value: "agent.id"},
+		State:       state.Enum(),
+		Resources:   resources,
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
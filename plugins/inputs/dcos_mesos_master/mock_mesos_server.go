@@ -0,0 +1,45 @@
+package dcos_mesos_master
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mesos/mesos-go/api/v1/lib/master"
+)
+
+// startTestServer starts a server which responds to each call at /api/v1
+// with the response of its type, serialized as protobuf. Calls which have no
+// response are answered with 403 Forbidden, as they would be for a service
+// account which lacks permission.
+func startTestServer(t *testing.T, responses map[master.Call_Type]master.Response) *httptest.Server {
+	router := http.NewServeMux()
+	router.HandleFunc("/api/v1", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		var call master.Call
+		if err := call.Unmarshal(body); err != nil {
+			t.Errorf("Unknown request to mock-mesos-master: %s", body)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		resp, ok := responses[call.GetType()]
+		if !ok {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		data, err := resp.Marshal()
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	})
+	return httptest.NewServer(router)
+}
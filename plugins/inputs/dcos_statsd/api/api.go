@@ -66,8 +66,39 @@ func DescribeContainer(c containers.Controller) http.HandlerFunc {
 	}
 }
 
+// DescribeUsage returns a single container's usage of its limits
+func DescribeUsage(c containers.Controller) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		cid := vars["id"]
+
+		ctr, ok := c.GetContainer(cid)
+		if !ok {
+			log.Printf("I! Could not find requested container %q", cid)
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "Container %q not found", cid)
+			return
+		}
+
+		data, err := json.Marshal(ctr.Usage())
+		if err != nil {
+			log.Printf("E! could not encode json: %s", err)
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "Could not describe usage of container %s", cid)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
+
 // AddContainer adds a container and starts a statsd server. It returns the
-// container definition include the server host and port.
+// container definition include the server host and port. Re-creating an
+// existing container returns the existing container.
 func AddContainer(c containers.Controller) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var ctr containers.Container
@@ -80,20 +111,23 @@ func AddContainer(c containers.Controller) http.HandlerFunc {
 			return
 		}
 
-		// If container already exists, redirect
-		_, ok := c.GetContainer(ctr.Id)
-		if ok {
-			log.Printf("I! Could not add container %q as it already exists", ctr.Id)
-			http.Redirect(w, r, "/container/"+ctr.Id, http.StatusSeeOther)
-			return
-		}
+		_, exists := c.GetContainer(ctr.Id)
 
 		result, err := c.AddContainer(ctr)
 		if err != nil {
 			log.Printf("E! could not add container: %s", err)
 			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Could not add container %s", ctr.Id)
+			switch err {
+			case containers.ErrContainerExists, containers.ErrPortConflict:
+				w.WriteHeader(http.StatusConflict)
+				fmt.Fprintf(w, "Could not add container %s: %s", ctr.Id, err)
+			case containers.ErrNoPortsAvailable:
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintf(w, "Could not add container %s: %s", ctr.Id, err)
+			default:
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprintf(w, "Could not add container %s", ctr.Id)
+			}
 			return
 		}
 
@@ -107,7 +141,11 @@ func AddContainer(c containers.Controller) http.HandlerFunc {
 		}
 
 		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
-		w.WriteHeader(http.StatusCreated)
+		if exists {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
 		w.Write(data)
 	}
 }
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Auth describes how requests to the API are authorized. The zero value
+// allows every request.
+type Auth struct {
+	// AllowedClientCNs is a list of common names, one of which must be present
+	// in the client certificate of a TLS connection. If empty, any client
+	// certificate which was verified during the TLS handshake is allowed.
+	AllowedClientCNs []string
+	// PublicKey is the DC/OS IAM public key against which bearer tokens are
+	// validated. If nil, bearer tokens are not required.
+	PublicKey *rsa.PublicKey
+	// AllowedUIDs is a list of user IDs, one of which must be the subject of
+	// the bearer token. If empty, any valid token is allowed.
+	AllowedUIDs []string
+}
+
+// tokenClaims are the claims made by a DC/OS IAM token
+type tokenClaims struct {
+	UID string `json:"uid"`
+	jwt.StandardClaims
+}
+
+// Authorize wraps a handler, such that requests which fail authorization are
+// rejected. Requests with a missing or invalid token are rejected as 401
+// Unauthorized; requests from a client which is not allowed are rejected as
+// 403 Forbidden.
+func Authorize(inner http.Handler, a Auth) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := a.checkClientCert(r); err != nil {
+			log.Printf("I! Rejected request to %s: %s", r.RequestURI, err)
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "Forbidden")
+			return
+		}
+
+		if a.PublicKey != nil {
+			uid, err := a.checkToken(r)
+			if err != nil {
+				log.Printf("I! Rejected request to %s: %s", r.RequestURI, err)
+				w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+				w.Header().Set("WWW-Authenticate", `Bearer realm="dcos_statsd"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, "Unauthorized")
+				return
+			}
+			if len(a.AllowedUIDs) > 0 && !contains(a.AllowedUIDs, uid) {
+				log.Printf("I! Rejected request to %s from %q: uid is not allowed", r.RequestURI, uid)
+				w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+				w.WriteHeader(http.StatusForbidden)
+				fmt.Fprint(w, "Forbidden")
+				return
+			}
+		}
+
+		inner.ServeHTTP(w, r)
+	})
+}
+
+// checkClientCert returns an error if the request was made over TLS with a
+// client certificate whose common name is not allowed
+func (a Auth) checkClientCert(r *http.Request) error {
+	if len(a.AllowedClientCNs) == 0 {
+		return nil
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return errors.New("no client certificate was presented")
+	}
+	cn := r.TLS.PeerCertificates[0].Subject.CommonName
+	if !contains(a.AllowedClientCNs, cn) {
+		return fmt.Errorf("client certificate common name %q is not allowed", cn)
+	}
+	return nil
+}
+
+// checkToken validates the bearer token of a request and returns its uid.
+// DC/OS clients send tokens as either "Bearer <token>" or "token=<token>".
+func (a Auth) checkToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	var raw string
+	switch {
+	case strings.HasPrefix(header, "Bearer "):
+		raw = strings.TrimPrefix(header, "Bearer ")
+	case strings.HasPrefix(header, "token="):
+		raw = strings.TrimPrefix(header, "token=")
+	default:
+		return "", errors.New("no bearer token was presented")
+	}
+
+	var claims tokenClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Header["alg"])
+		}
+		return a.PublicKey, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("invalid bearer token: %s", err)
+	}
+	return claims.UID, nil
+}
+
+// contains returns true if s is in list
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -65,6 +65,13 @@ var routes = Routes{
 		DescribeContainer,
 	},
 
+	Route{
+		"DescribeUsage",
+		strings.ToUpper("Get"),
+		"/container/{id}/usage",
+		DescribeUsage,
+	},
+
 	Route{
 		"AddContainer",
 		strings.ToUpper("Post"),
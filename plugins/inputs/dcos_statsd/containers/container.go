@@ -8,6 +8,44 @@ type Container struct {
 	Id         string `json:"container_id"`
 	StatsdHost string `json:"statsd_host,omitempty"`
 	StatsdPort int    `json:"statsd_port,omitempty"`
+	// MaxSeries and MaxPacketsPerSecond override the plugin's limits for this
+	// container's server. A negative value removes the limit.
+	MaxSeries           int `json:"max_series,omitempty"`
+	MaxPacketsPerSecond int `json:"max_packets_per_second,omitempty"`
 	// Server is a telegraf statsd input plugin instance
 	Server *statsd.Statsd `json:"-"`
 }
+
+// Usage describes the consumption of a container's server against its limits
+type Usage struct {
+	Series              int   `json:"series"`
+	MaxSeries           int   `json:"max_series"`
+	DroppedSeries       int64 `json:"dropped_series"`
+	PacketsPerSecond    int   `json:"packets_per_second"`
+	MaxPacketsPerSecond int   `json:"max_packets_per_second"`
+	RateLimitedPackets  int64 `json:"rate_limited_packets"`
+}
+
+// Usage returns the current usage of the container's server. Limits which
+// are disabled are reported as 0.
+func (c *Container) Usage() Usage {
+	u := Usage{}
+	if c.Server == nil {
+		return u
+	}
+	u.Series = c.Server.Series()
+	u.PacketsPerSecond = c.Server.PacketRate()
+	if c.Server.MaxSeries > 0 {
+		u.MaxSeries = c.Server.MaxSeries
+	}
+	if c.Server.MaxPacketsPerSecond > 0 {
+		u.MaxPacketsPerSecond = c.Server.MaxPacketsPerSecond
+	}
+	if c.Server.DroppedSeries != nil {
+		u.DroppedSeries = c.Server.DroppedSeries.Get()
+	}
+	if c.Server.RateLimitedPackets != nil {
+		u.RateLimitedPackets = c.Server.RateLimitedPackets.Get()
+	}
+	return u
+}
@@ -1,5 +1,7 @@
 package containers
 
+import "errors"
+
 // Controller is the interface for controlling containers. We define it in order
 // to pass a DCOSStatsd instance into the API. We cannot directly require the
 // dcos_statsd package without encountering a circular import.
@@ -9,3 +11,14 @@ type Controller interface {
 	AddContainer(c Container) (*Container, error)
 	RemoveContainer(c Container) error
 }
+
+var (
+	// ErrContainerExists is returned when a container is re-created with an
+	// address which differs from that of the existing container
+	ErrContainerExists = errors.New("container already exists with a different address")
+	// ErrPortConflict is returned when the requested port is already in use
+	ErrPortConflict = errors.New("the requested port is already in use")
+	// ErrNoPortsAvailable is returned when every port in the statsd port range
+	// is in use
+	ErrNoPortsAvailable = errors.New("no ports are available in the statsd port range")
+)
@@ -2,6 +2,7 @@ package dcos_statsd
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,13 +17,17 @@ import (
 	"sync"
 	"time"
 
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/dcosutil"
 	"github.com/influxdata/telegraf/internal"
+	tlsint "github.com/influxdata/telegraf/internal/tls"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/influxdata/telegraf/plugins/inputs/dcos_statsd/api"
 	"github.com/influxdata/telegraf/plugins/inputs/dcos_statsd/containers"
 	"github.com/influxdata/telegraf/plugins/inputs/statsd"
+	"github.com/influxdata/telegraf/selfstat"
+	"github.com/mesos/mesos-go/api/v1/lib/httpcli"
 )
 
 const sampleConfig = `
@@ -36,8 +41,40 @@ containers_dir = "/run/dcos/telegraf/dcos_statsd/containers"
 timeout = "15s"
 ## The hostname or IP address on which to host statsd servers
 statsd_host = "198.51.100.1"
+## The range of ports from which statsd servers are assigned a port, as
+## "min-max". Leave unset to let the operating system choose a port.
+#statsd_port_range = "61000-61999"
 ## The number of pending messages each statsd server can hold
 allowed_pending_messages = 10000
+## The maximum number of distinct series each statsd server will cache per
+## interval. Metrics which would add a series beyond the limit are dropped,
+## and series not updated within an interval are removed. 0 means unlimited.
+#max_series = 0
+## The maximum number of packets each statsd server will accept per second.
+## Packets beyond the limit are dropped. 0 means unlimited.
+#max_packets_per_second = 0
+## Optional TLS configuration for the command API. Set tls_allowed_cacerts to
+## require clients to present a certificate signed by one of the given CAs.
+#tls_cert = "/run/dcos/pki/tls/certs/dcos-statsd.crt"
+#tls_key = "/run/dcos/pki/tls/private/dcos-statsd.key"
+#tls_allowed_cacerts = ["/run/dcos/pki/CA/ca-bundle.crt"]
+## The common names which are allowed in client certificates. Leave unset to
+## allow any certificate signed by tls_allowed_cacerts.
+#tls_allowed_client_cns = ["dcos-mesos-slave"]
+## The path to the DC/OS IAM public key, against which bearer tokens are
+## validated. Leave unset to accept requests without a token.
+#iam_public_key_path = "/run/dcos/pki/tls/certs/iam-public-key.pem"
+## The user IDs which are allowed to use the command API. Leave unset to allow
+## any user with a valid token.
+#allowed_uids = ["dcos_mesos_agent"]
+## The URL of the local mesos agent. If set, statsd servers are removed once
+## their container is no longer running.
+#mesos_agent_url = "http://$NODE_PRIVATE_IP:5051"
+## The period at which containers are reconciled with the mesos agent
+#reconcile_interval = "1m"
+## Optional IAM configuration for requests to the mesos agent
+#ca_certificate_path = "/run/dcos/pki/CA/ca-bundle.crt"
+#iam_config_path = "/run/dcos/etc/dcos-telegraf/service_account.json"
 `
 
 type DCOSStatsd struct {
@@ -46,13 +83,47 @@ type DCOSStatsd struct {
 	Listen            string
 	SystemdSocketName string
 	// ContainersDir is the directory in which container information is stored
-	ContainersDir          string
-	Timeout                internal.Duration
-	StatsdHost             string
+	ContainersDir string
+	Timeout       internal.Duration
+	StatsdHost    string
+	// StatsdPortRange is the range of ports, as "min-max", from which statsd
+	// servers are assigned a port
+	StatsdPortRange        string
 	AllowedPendingMessages int
-	apiServer              *http.Server
-	containers             map[string]containers.Container
-	rwmu                   sync.RWMutex
+	// MaxSeries and MaxPacketsPerSecond are the default limits for each
+	// container's statsd server, which can be overridden per container
+	MaxSeries           int
+	MaxPacketsPerSecond int
+	tlsint.ServerConfig
+	// TLSAllowedClientCNs restricts the client certificates accepted by the
+	// command API to those with one of these common names
+	TLSAllowedClientCNs []string `toml:"tls_allowed_client_cns"`
+	// IAMPublicKeyPath is the path to the DC/OS IAM public key, against which
+	// bearer tokens sent to the command API are validated
+	IAMPublicKeyPath string   `toml:"iam_public_key_path"`
+	AllowedUIDs      []string `toml:"allowed_uids"`
+	// MesosAgentUrl is the URL of the local mesos agent, against which
+	// containers are reconciled every ReconcileInterval
+	MesosAgentUrl     string
+	ReconcileInterval internal.Duration
+	dcosutil.DCOSConfig
+	apiServer  *http.Server
+	containers map[string]containers.Container
+	// ports allocates ports from StatsdPortRange; it is nil if no range is set
+	ports *portAllocator
+	// ctrmu serializes adding and removing containers
+	ctrmu sync.Mutex
+	// added holds the time at which each container was added
+	added map[string]time.Time
+	// missing holds the containers which were not running on the mesos agent
+	// at the last reconciliation
+	missing map[string]bool
+	client  *httpcli.Client
+	rwmu    sync.RWMutex
+	// evictions counts containers removed by reconciliation
+	evictions selfstat.Stat
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
 }
 
 // SampleConfig returns the default configuration
@@ -72,12 +143,36 @@ func (ds *DCOSStatsd) Start(acc telegraf.Accumulator) error {
 	if ds.containers == nil {
 		ds.containers = map[string]containers.Container{}
 	}
+	ds.added = map[string]time.Time{}
+	tlsConfig, err := ds.ServerConfig.TLSConfig()
+	if err != nil {
+		return err
+	}
+	auth, err := ds.getAuth()
+	if err != nil {
+		return err
+	}
+
 	router := api.NewRouter(ds)
 	ds.apiServer = &http.Server{
-		Handler:      router,
+		Handler:      api.Authorize(router, auth),
 		Addr:         ds.Listen,
 		WriteTimeout: ds.Timeout.Duration,
 		ReadTimeout:  ds.Timeout.Duration,
+		TLSConfig:    tlsConfig,
+	}
+
+	if ds.StatsdPortRange != "" {
+		min, max, err := parsePortRange(ds.StatsdPortRange)
+		if err != nil {
+			return err
+		}
+		// previous allocations are restored before containers are loaded, so
+		// that loaded containers retain their ports
+		ds.ports, err = newPortAllocator(min, max, ds.ContainersDir)
+		if err != nil {
+			return err
+		}
 	}
 
 	// default to 10,000 allowed pending messages per statsd server
@@ -113,6 +208,9 @@ func (ds *DCOSStatsd) Start(acc telegraf.Accumulator) error {
 			log.Fatalf("E! Could not find systemd socket: %s", ds.SystemdSocketName)
 		}
 		ln := l[0]
+		if tlsConfig != nil {
+			ln = tls.NewListener(ln, tlsConfig)
+		}
 
 		go func() {
 			err := ds.apiServer.Serve(ln)
@@ -123,7 +221,13 @@ func (ds *DCOSStatsd) Start(acc telegraf.Accumulator) error {
 		// Use the listen param to decide where to listen.
 		go func() {
 			if strings.Contains(ds.Listen, ":") {
-				err := ds.apiServer.ListenAndServe()
+				var err error
+				if tlsConfig != nil {
+					// the certificate and key are already loaded into tlsConfig
+					err = ds.apiServer.ListenAndServeTLS("", "")
+				} else {
+					err = ds.apiServer.ListenAndServe()
+				}
 				log.Printf("I! dcos_statsd API server closed: %s", err)
 			} else {
 				ln, err := net.Listen("unix", ds.Listen)
@@ -132,6 +236,9 @@ func (ds *DCOSStatsd) Start(acc telegraf.Accumulator) error {
 					// command server
 					log.Fatalf("E! Could not listen on unix socket %s", ds.Listen)
 				}
+				if tlsConfig != nil {
+					ln = tls.NewListener(ln, tlsConfig)
+				}
 
 				defer func() {
 					if r := recover(); r != nil {
@@ -148,9 +255,48 @@ func (ds *DCOSStatsd) Start(acc telegraf.Accumulator) error {
 		log.Printf("I! dcos_statsd API server listening on %s", ds.Listen)
 	}
 
+	if ds.MesosAgentUrl != "" {
+		if ds.ReconcileInterval.Duration == 0 {
+			ds.ReconcileInterval.Duration = time.Minute
+		}
+		ds.evictions = selfstat.Register("dcos_statsd", "evicted_containers", map[string]string{})
+		ctx, cancel := context.WithCancel(context.Background())
+		ds.cancel = cancel
+		ds.wg.Add(1)
+		go ds.watch(ctx)
+	}
+
 	return nil
 }
 
+// getAuth returns the configuration with which requests to the command API
+// are authorized
+func (ds *DCOSStatsd) getAuth() (api.Auth, error) {
+	auth := api.Auth{
+		AllowedClientCNs: ds.TLSAllowedClientCNs,
+		AllowedUIDs:      ds.AllowedUIDs,
+	}
+
+	// client certificates are only verified if allowed CAs are configured
+	if len(ds.TLSAllowedClientCNs) > 0 && len(ds.TLSAllowedCACerts) == 0 {
+		return auth, errors.New("tls_allowed_client_cns requires tls_allowed_cacerts to be set")
+	}
+
+	if ds.IAMPublicKeyPath != "" {
+		data, err := ioutil.ReadFile(ds.IAMPublicKeyPath)
+		if err != nil {
+			return auth, fmt.Errorf("could not read IAM public key: %s", err)
+		}
+		key, err := jwt.ParseRSAPublicKeyFromPEM(data)
+		if err != nil {
+			return auth, fmt.Errorf("could not parse IAM public key: %s", err)
+		}
+		auth.PublicKey = key
+	}
+
+	return auth, nil
+}
+
 // Gather takes in an accumulator and adds the metrics that the plugin gathers.
 // It is invoked on a schedule (default every 10s) by the telegraf runtime.
 func (ds *DCOSStatsd) Gather(acc telegraf.Accumulator) error {
@@ -180,6 +326,11 @@ func (ds *DCOSStatsd) Stop() {
 	defer cancel()
 	ds.apiServer.Shutdown(ctx)
 
+	if ds.cancel != nil {
+		ds.cancel()
+		ds.wg.Wait()
+	}
+
 	ds.rwmu.RLock()
 	for _, c := range ds.containers {
 		c.Server.Stop()
@@ -189,6 +340,9 @@ func (ds *DCOSStatsd) Stop() {
 
 // ListContainers returns a list of known containers
 func (ds *DCOSStatsd) ListContainers() []containers.Container {
+	ds.rwmu.RLock()
+	defer ds.rwmu.RUnlock()
+
 	ctrs := []containers.Container{}
 	for _, c := range ds.containers {
 		ctrs = append(ctrs, c)
@@ -205,20 +359,28 @@ func (ds *DCOSStatsd) GetContainer(cid string) (*containers.Container, bool) {
 	return &ctr, ok
 }
 
-// AddContainer takes a container definition and adds a container, if one does
-// not exist with the same ID. If the statsd_host and statsd_port fields are
-// defined, it will attempt to start a server on the defined address. If this
-// fails, it will error and the container will not be added. If the fields are
-// not defined, it wil attempt to start a server on a random port and the
-// default host. If this fails, it will error and the container will not be
-// added. If the operation was successful, it will return the container.
+// AddContainer takes a container definition and adds a container. If a
+// container with the same ID exists, it is returned unchanged, unless the
+// statsd_host or statsd_port fields conflict with its address. If the
+// statsd_host and statsd_port fields are defined, it will attempt to start a
+// server on the defined address. If this fails, it will error and the
+// container will not be added. If the fields are not defined, it will attempt
+// to start a server on the default host and on a port from statsd_port_range,
+// or on a random port if no range is set. If this fails, it will error and the
+// container will not be added. If the operation was successful, it will return
+// the container.
 func (ds *DCOSStatsd) AddContainer(ctr containers.Container) (*containers.Container, error) {
-	ctr.Server = &statsd.Statsd{
-		Protocol:               "udp",
-		ServiceAddress:         fmt.Sprintf(":%d", ctr.StatsdPort),
-		ParseDataDogTags:       true,
-		AllowedPendingMessages: ds.AllowedPendingMessages,
-		MetricSeparator:        ".",
+	ds.ctrmu.Lock()
+	defer ds.ctrmu.Unlock()
+
+	if existing, ok := ds.GetContainer(ctr.Id); ok {
+		if (ctr.StatsdHost != "" && ctr.StatsdHost != existing.StatsdHost) ||
+			(ctr.StatsdPort != 0 && ctr.StatsdPort != existing.StatsdPort) {
+			log.Printf("E! Container %s already exists on %s:%d", ctr.Id, existing.StatsdHost, existing.StatsdPort)
+			return nil, containers.ErrContainerExists
+		}
+		log.Printf("I! Container %s already exists", ctr.Id)
+		return existing, nil
 	}
 
 	// statsd will crash the whole Telegraf process if it attempts to listen on
@@ -226,7 +388,59 @@ func (ds *DCOSStatsd) AddContainer(ctr containers.Container) (*containers.Contai
 	// user.
 	if ctr.StatsdPort != 0 && !checkPort(ctr.StatsdPort) {
 		log.Printf("E! Attempted to start a server on an occupied port: %d", ctr.StatsdPort)
-		return nil, fmt.Errorf("could not start server on occupied port %d", ctr.StatsdPort)
+		return nil, containers.ErrPortConflict
+	}
+
+	if ds.ports != nil {
+		if ctr.StatsdPort == 0 {
+			port, err := ds.ports.allocate(ctr.Id)
+			if err != nil {
+				log.Printf("E! Could not allocate a port for container %s from %s: %s", ctr.Id, ds.StatsdPortRange, err)
+				return nil, err
+			}
+			ctr.StatsdPort = port
+		} else if err := ds.ports.reserve(ctr.Id, ctr.StatsdPort); err != nil {
+			log.Printf("E! Could not reserve port %d for container %s: %s", ctr.StatsdPort, ctr.Id, err)
+			return nil, err
+		}
+	}
+	// if the container could not be added, its server is stopped before its
+	// port is released, so that the port is not handed out while still bound,
+	// and the stats registered for it are removed
+	added, started := false, false
+	defer func() {
+		if added {
+			return
+		}
+		if started {
+			ctr.Server.Stop()
+		}
+		if ds.ports != nil {
+			ds.ports.release(ctr.Id, ctr.StatsdPort)
+		}
+		selfstat.Unregister("dcos_statsd", map[string]string{"container_id": ctr.Id})
+	}()
+
+	maxSeries := ds.MaxSeries
+	if ctr.MaxSeries != 0 {
+		maxSeries = ctr.MaxSeries
+	}
+	maxPacketsPerSecond := ds.MaxPacketsPerSecond
+	if ctr.MaxPacketsPerSecond != 0 {
+		maxPacketsPerSecond = ctr.MaxPacketsPerSecond
+	}
+
+	tags := map[string]string{"container_id": ctr.Id}
+	ctr.Server = &statsd.Statsd{
+		Protocol:               "udp",
+		ServiceAddress:         fmt.Sprintf(":%d", ctr.StatsdPort),
+		ParseDataDogTags:       true,
+		AllowedPendingMessages: ds.AllowedPendingMessages,
+		MetricSeparator:        ".",
+		MaxSeries:              maxSeries,
+		MaxPacketsPerSecond:    maxPacketsPerSecond,
+		DroppedSeries:          selfstat.Register("dcos_statsd", "dropped_series", tags),
+		RateLimitedPackets:     selfstat.Register("dcos_statsd", "rate_limited_packets", tags),
 	}
 
 	// Statsd.Start discards its accumulator
@@ -235,6 +449,7 @@ func (ds *DCOSStatsd) AddContainer(ctr containers.Container) (*containers.Contai
 		log.Printf("E! Could not start server for container %s", ctr.Id)
 		return nil, err
 	}
+	started = true
 	log.Printf("I! Added container %s", ctr.Id)
 
 	if ctr.StatsdHost == "" {
@@ -266,7 +481,9 @@ func (ds *DCOSStatsd) AddContainer(ctr containers.Container) (*containers.Contai
 
 	ds.rwmu.Lock()
 	ds.containers[ctr.Id] = ctr
+	ds.added[ctr.Id] = time.Now()
 	ds.rwmu.Unlock()
+	added = true
 
 	return &ctr, nil
 }
@@ -274,6 +491,9 @@ func (ds *DCOSStatsd) AddContainer(ctr containers.Container) (*containers.Contai
 // Remove container will remove a container and stop any associated server. the
 // host and port need not be present in the container argument.
 func (ds *DCOSStatsd) RemoveContainer(c containers.Container) error {
+	ds.ctrmu.Lock()
+	defer ds.ctrmu.Unlock()
+
 	ctr, ok := ds.GetContainer(c.Id)
 	if !ok {
 		return fmt.Errorf("container %s not found", c.Id)
@@ -286,9 +506,14 @@ func (ds *DCOSStatsd) RemoveContainer(c containers.Container) error {
 		}
 	}
 	ctr.Server.Stop()
+	if ds.ports != nil {
+		ds.ports.release(ctr.Id, ctr.StatsdPort)
+	}
+	selfstat.Unregister("dcos_statsd", map[string]string{"container_id": c.Id})
 
 	ds.rwmu.Lock()
 	delete(ds.containers, c.Id)
+	delete(ds.added, c.Id)
 	ds.rwmu.Unlock()
 
 	return nil
@@ -303,6 +528,11 @@ func (ds *DCOSStatsd) loadContainers() error {
 	}
 
 	for _, fInfo := range files {
+		// Hidden files, such as port allocations, are not containers
+		if strings.HasPrefix(fInfo.Name(), ".") {
+			continue
+		}
+
 		// No need for filepath.Join - this simple concat works on Windows
 		fPath := fmt.Sprintf("%s/%s", ds.ContainersDir, fInfo.Name())
 
@@ -365,10 +595,11 @@ func checkPort(port int) bool {
 func init() {
 	inputs.Add("dcos_statsd", func() telegraf.Input {
 		return &DCOSStatsd{
-			ContainersDir: "/run/dcos/telegraf/dcos_statsd/containers",
-			Timeout:       internal.Duration{Duration: 10 * time.Second},
-			StatsdHost:    "198.51.100.1",
-			containers:    map[string]containers.Container{},
+			ContainersDir:     "/run/dcos/telegraf/dcos_statsd/containers",
+			Timeout:           internal.Duration{Duration: 10 * time.Second},
+			StatsdHost:        "198.51.100.1",
+			containers:        map[string]containers.Container{},
+			ReconcileInterval: internal.Duration{Duration: time.Minute},
 		}
 	})
 }
@@ -2,7 +2,12 @@ package dcos_statsd
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
@@ -10,15 +15,22 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/plugins/inputs/dcos_statsd/containers"
+	"github.com/influxdata/telegraf/selfstat"
 	"github.com/influxdata/telegraf/testutil"
+	"github.com/mesos/mesos-go/api/v1/lib/agent"
 	"github.com/stretchr/testify/assert"
 )
 
+var pki = testutil.NewPKI("../../../testutil/pki")
+
 func TestStart(t *testing.T) {
 	t.Run("Server with no saved state", func(t *testing.T) {
 		ds := DCOSStatsd{}
@@ -196,6 +208,295 @@ func TestGather(t *testing.T) {
 
 }
 
+func TestLimits(t *testing.T) {
+	ds := DCOSStatsd{StatsdHost: "127.0.0.1", MaxSeries: 100, MaxPacketsPerSecond: 1000}
+	addr := startTestServer(t, &ds)
+	defer ds.Stop()
+
+	t.Log("A container with the default limits")
+	_, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(`{"container_id":"abc123"}`)))
+	assert.Nil(t, err)
+	abc := ds.containers["abc123"]
+	assert.Equal(t, 100, abc.Server.MaxSeries)
+	assert.Equal(t, 1000, abc.Server.MaxPacketsPerSecond)
+
+	t.Log("A container which overrides the limits")
+	xyzjson := `{"container_id":"xyz123","max_series":5,"max_packets_per_second":-1}`
+	resp, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(xyzjson)))
+	assert.Nil(t, err)
+	xyz := parseContainer(t, resp.Body)
+	assert.Equal(t, 5, xyz.MaxSeries)
+	assert.Equal(t, 5, ds.containers["xyz123"].Server.MaxSeries)
+	assert.Equal(t, -1, ds.containers["xyz123"].Server.MaxPacketsPerSecond)
+
+	t.Log("Usage is reported against the effective limits")
+	resp, err = http.Get(addr + "/container/xyz123/usage")
+	assertResponseWas(t, resp, err,
+		`{"series":0,"max_series":5,"dropped_series":0,"packets_per_second":0,"max_packets_per_second":0,"rate_limited_packets":0}`)
+
+	resp, err = http.Get(addr + "/container/qqq123/usage")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestAuth(t *testing.T) {
+	t.Run("Mutual TLS with a client certificate allowlist", func(t *testing.T) {
+		ds := DCOSStatsd{
+			ServerConfig:        *pki.TLSServerConfig(),
+			TLSAllowedClientCNs: []string{"client.localdomain"},
+		}
+		addr := strings.Replace(startTestServer(t, &ds), "http://", "https://", 1)
+		defer ds.Stop()
+
+		tlsConfig, err := pki.TLSClientConfig().TLSConfig()
+		assert.Nil(t, err)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := client.Get(addr + "/containers")
+		assertResponseWas(t, resp, err, "[]")
+
+		// A client without a certificate fails the handshake
+		tlsConfig.Certificates = nil
+		client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		_, err = client.Get(addr + "/containers")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("Mutual TLS with a disallowed client certificate", func(t *testing.T) {
+		ds := DCOSStatsd{
+			ServerConfig:        *pki.TLSServerConfig(),
+			TLSAllowedClientCNs: []string{"agent.localdomain"},
+		}
+		addr := strings.Replace(startTestServer(t, &ds), "http://", "https://", 1)
+		defer ds.Stop()
+
+		tlsConfig, err := pki.TLSClientConfig().TLSConfig()
+		assert.Nil(t, err)
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+		resp, err := client.Get(addr + "/containers")
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("Bearer tokens", func(t *testing.T) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.Nil(t, err)
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.Nil(t, err)
+
+		der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		assert.Nil(t, err)
+		keyFile, err := ioutil.TempFile("", "iam-public-key")
+		assert.Nil(t, err)
+		defer os.Remove(keyFile.Name())
+		pem.Encode(keyFile, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+		keyFile.Close()
+
+		ds := DCOSStatsd{
+			IAMPublicKeyPath: keyFile.Name(),
+			AllowedUIDs:      []string{"dcos_mesos_agent"},
+		}
+		addr := startTestServer(t, &ds)
+		defer ds.Stop()
+
+		get := func(header string) int {
+			req, err := http.NewRequest("GET", addr+"/containers", nil)
+			assert.Nil(t, err)
+			if header != "" {
+				req.Header.Set("Authorization", header)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			assert.Nil(t, err)
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		assert.Equal(t, http.StatusUnauthorized, get(""))
+		assert.Equal(t, http.StatusUnauthorized, get("Bearer "+signToken(t, otherKey, "dcos_mesos_agent")))
+		assert.Equal(t, http.StatusForbidden, get("Bearer "+signToken(t, key, "someone_else")))
+		assert.Equal(t, http.StatusOK, get("Bearer "+signToken(t, key, "dcos_mesos_agent")))
+		assert.Equal(t, http.StatusOK, get("token="+signToken(t, key, "dcos_mesos_agent")))
+	})
+}
+
+func TestPortRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containers")
+	if err != nil {
+		assert.Fail(t, fmt.Sprintf("Could not create temp dir: %s", err))
+	}
+	defer os.RemoveAll(dir)
+
+	port := findFreePort()
+	ds := DCOSStatsd{
+		StatsdHost:      "127.0.0.1",
+		ContainersDir:   dir,
+		StatsdPortRange: fmt.Sprintf("%d-%d", port, port),
+	}
+	addr := startTestServer(t, &ds)
+	defer ds.Stop()
+
+	t.Log("A container is given a port from the range")
+	abcjson := `{"container_id": "abc123"}`
+	resp, err := http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(abcjson)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	abc := parseContainer(t, resp.Body)
+	assert.Equal(t, port, abc.StatsdPort)
+
+	t.Log("Re-creating the container is idempotent")
+	resp, err = http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(abcjson)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, abc, parseContainer(t, resp.Body))
+
+	t.Log("Re-creating the container on a different port conflicts")
+	movedjson := fmt.Sprintf(`{"container_id": "abc123", "statsd_port": %d}`, port+1)
+	resp, err = http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(movedjson)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	t.Log("The range is exhausted")
+	xyzjson := `{"container_id": "xyz123"}`
+	resp, err = http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(xyzjson)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, 1, len(ds.containers))
+
+	t.Log("Port allocations are persisted alongside containers")
+	_, err = os.Stat(dir + "/" + portsFile)
+	assert.Nil(t, err)
+
+	t.Log("A removed container's port is released")
+	_, err = httpDelete(t, addr+"/container/abc123")
+	assert.Nil(t, err)
+	resp, err = http.Post(addr+"/container", "application/json", bytes.NewBuffer([]byte(xyzjson)))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	xyz := parseContainer(t, resp.Body)
+	assert.Equal(t, port, xyz.StatsdPort)
+}
+
+func TestAddContainerFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containers")
+	if err != nil {
+		assert.Fail(t, fmt.Sprintf("Could not create temp dir: %s", err))
+	}
+	defer os.RemoveAll(dir)
+
+	port := findFreePort()
+	ds := DCOSStatsd{
+		StatsdHost:      "127.0.0.1",
+		ContainersDir:   dir,
+		StatsdPortRange: fmt.Sprintf("%d-%d", port, port),
+	}
+	startTestServer(t, &ds)
+	defer ds.Stop()
+
+	t.Log("A container which cannot be written to disk is not added")
+	assert.Nil(t, os.Mkdir(dir+"/abc123", 0755))
+	_, err = ds.AddContainer(containers.Container{Id: "abc123"})
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(ds.containers))
+
+	t.Log("Its server was stopped, its port released and its stats removed")
+	assert.True(t, checkPort(port))
+	for _, m := range selfstat.Metrics() {
+		assert.NotEqual(t, "abc123", m.Tags()["container_id"])
+	}
+	ctr, err := ds.AddContainer(containers.Container{Id: "xyz123"})
+	assert.Nil(t, err)
+	assert.Equal(t, port, ctr.StatsdPort)
+}
+
+// signToken returns a DC/OS IAM token for uid, signed with key
+func signToken(t *testing.T, key *rsa.PrivateKey, uid string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"uid": uid,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	signed, err := token.SignedString(key)
+	assert.Nil(t, err)
+	return signed
+}
+
+func TestReconcile(t *testing.T) {
+	server := startTestMesosServer(t, "nested")
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "containers")
+	if err != nil {
+		assert.Fail(t, fmt.Sprintf("Could not create temp dir: %s", err))
+	}
+	defer os.RemoveAll(dir)
+
+	ds := DCOSStatsd{
+		StatsdHost:        "127.0.0.1",
+		ContainersDir:     dir,
+		Timeout:           internal.Duration{Duration: 500 * time.Millisecond},
+		MesosAgentUrl:     server.URL,
+		ReconcileInterval: internal.Duration{Duration: time.Hour},
+	}
+	startTestServer(t, &ds)
+	defer ds.Stop()
+
+	for _, cid := range []string{"abc123", "xyz123", "qqq123", "new123"} {
+		_, err := ds.AddContainer(containers.Container{Id: cid})
+		assert.Nil(t, err)
+	}
+	// all but the newest container were added before the last reconciliation
+	for _, cid := range []string{"abc123", "xyz123", "qqq123"} {
+		ds.added[cid] = time.Now().Add(-2 * time.Hour)
+	}
+
+	// qqq123 is not removed until it has been missing from two reconciliations
+	assert.Nil(t, ds.reconcile(context.Background()))
+	_, ok := ds.GetContainer("qqq123")
+	assert.True(t, ok)
+	assert.Nil(t, ds.reconcile(context.Background()))
+
+	// abc123 is running, and is nested in xyz123; new123 is too new to be
+	// reported by the agent
+	_, ok = ds.GetContainer("abc123")
+	assert.True(t, ok)
+	_, ok = ds.GetContainer("xyz123")
+	assert.True(t, ok)
+	_, ok = ds.GetContainer("new123")
+	assert.True(t, ok)
+	_, ok = ds.GetContainer("qqq123")
+	assert.False(t, ok)
+
+	files, err := ioutil.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(files))
+	assert.Equal(t, int64(1), ds.evictions.Get())
+}
+
+func TestReconcileWithoutRunningContainers(t *testing.T) {
+	server := testutil.NewMesosAgent(t)
+	defer server.Close()
+	server.SetState(agent.Response_GetState{})
+
+	ds := DCOSStatsd{
+		StatsdHost:        "127.0.0.1",
+		Timeout:           internal.Duration{Duration: 500 * time.Millisecond},
+		MesosAgentUrl:     server.URL,
+		ReconcileInterval: internal.Duration{Duration: time.Hour},
+	}
+	startTestServer(t, &ds)
+	defer ds.Stop()
+
+	_, err := ds.AddContainer(containers.Container{Id: "abc123"})
+	assert.Nil(t, err)
+	ds.added["abc123"] = time.Now().Add(-2 * time.Hour)
+
+	// an agent which reports no running containers may be recovering after a
+	// restart, so none are removed
+	assert.Nil(t, ds.reconcile(context.Background()))
+	assert.Nil(t, ds.reconcile(context.Background()))
+	_, ok := ds.GetContainer("abc123")
+	assert.True(t, ok)
+	assert.Equal(t, int64(0), ds.evictions.Get())
+}
+
 // startTestServer starts a server on the specified DCOSStatsd on a randomly
 // selected port and returns the address on which it will be served. It also
 // runs a test against the /health endpoint to ensure that the command API is
@@ -0,0 +1,225 @@
+package dcos_statsd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/influxdata/telegraf/plugins/inputs/dcos_statsd/containers"
+)
+
+// portsFile is the name of the file in containers_dir in which port
+// allocations are persisted. It is hidden so that it is not loaded as a
+// container.
+const portsFile = ".ports"
+
+// portAllocator hands out ports for statsd servers from a fixed range. The
+// container to which each port was last allocated is persisted, so that a
+// container which is re-created, for example after its task restarts, is given
+// its previous port where possible.
+type portAllocator struct {
+	min, max int
+	// path is the file in which allocations are persisted. If empty,
+	// allocations are not persisted.
+	path string
+	// free checks whether a port is free to listen on
+	free func(port int) bool
+
+	mu sync.Mutex
+	// inUse maps ports to the containers currently using them
+	inUse map[int]string
+	// lastOwner maps ports to the container they were last allocated to
+	lastOwner map[int]string
+	// next is the port from which the search for a free port begins
+	next int
+}
+
+// portsState is the on-disk representation of a portAllocator
+type portsState struct {
+	LastOwner map[string]string `json:"last_owner"`
+}
+
+// parsePortRange parses a range of ports in the form "min-max"
+func parsePortRange(r string) (int, int, error) {
+	parts := strings.SplitN(r, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid port range %q: expected min-max", r)
+	}
+	min, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %s", r, err)
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %s", r, err)
+	}
+	if min < 1 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("invalid port range %q", r)
+	}
+	return min, max, nil
+}
+
+// newPortAllocator returns a portAllocator for the given range, restoring
+// previous allocations from dir if it is not empty
+func newPortAllocator(min, max int, dir string) (*portAllocator, error) {
+	pa := &portAllocator{
+		min:       min,
+		max:       max,
+		free:      checkPort,
+		inUse:     map[int]string{},
+		lastOwner: map[int]string{},
+		next:      min,
+	}
+	if dir == "" {
+		return pa, nil
+	}
+	pa.path = filepath.Join(dir, portsFile)
+
+	data, err := ioutil.ReadFile(pa.path)
+	if os.IsNotExist(err) {
+		return pa, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ps portsState
+	if err := json.Unmarshal(data, &ps); err != nil {
+		return nil, fmt.Errorf("could not decode %s: %s", pa.path, err)
+	}
+	for p, cid := range ps.LastOwner {
+		port, err := strconv.Atoi(p)
+		if err != nil || !pa.inRange(port) {
+			continue
+		}
+		pa.lastOwner[port] = cid
+	}
+	return pa, nil
+}
+
+// inRange returns true if port is within the allocator's range
+func (pa *portAllocator) inRange(port int) bool {
+	return port >= pa.min && port <= pa.max
+}
+
+// allocate returns a free port for a container. The port previously
+// allocated to the container is preferred. Otherwise, ports which were never
+// allocated, or whose previous owner is not running, are chosen over ports
+// which another container may yet come back for. If no port in the range is
+// free, containers.ErrNoPortsAvailable is returned.
+func (pa *portAllocator) allocate(cid string) (int, error) {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+
+	for port, owner := range pa.lastOwner {
+		if owner != cid {
+			continue
+		}
+		if _, used := pa.inUse[port]; !used && pa.free(port) {
+			pa.take(cid, port)
+			return port, nil
+		}
+	}
+
+	// The first pass skips ports remembered by other containers
+	size := pa.max - pa.min + 1
+	for pass := 0; pass < 2; pass++ {
+		for i := 0; i < size; i++ {
+			port := pa.min + (pa.next-pa.min+i)%size
+			if _, used := pa.inUse[port]; used {
+				continue
+			}
+			if _, owned := pa.lastOwner[port]; owned && pass == 0 {
+				continue
+			}
+			if !pa.free(port) {
+				continue
+			}
+			pa.next = port + 1
+			if pa.next > pa.max {
+				pa.next = pa.min
+			}
+			pa.take(cid, port)
+			return port, nil
+		}
+	}
+
+	return 0, containers.ErrNoPortsAvailable
+}
+
+// reserve marks a port which was chosen by a container as in use. Ports
+// outside the range are ignored. If the port is used by another container,
+// containers.ErrPortConflict is returned.
+func (pa *portAllocator) reserve(cid string, port int) error {
+	if !pa.inRange(port) {
+		return nil
+	}
+
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+
+	if owner, used := pa.inUse[port]; used && owner != cid {
+		return containers.ErrPortConflict
+	}
+	pa.take(cid, port)
+	return nil
+}
+
+// release marks a port which is used by a container as no longer in use. The
+// container remains its last owner.
+func (pa *portAllocator) release(cid string, port int) {
+	pa.mu.Lock()
+	defer pa.mu.Unlock()
+	if pa.inUse[port] == cid {
+		delete(pa.inUse, port)
+	}
+}
+
+// take allocates a port to a container and persists the allocation. A failure
+// to persist is logged, as the allocation remains valid until telegraf
+// restarts. It must be called while pa.mu is held.
+func (pa *portAllocator) take(cid string, port int) {
+	pa.inUse[port] = cid
+	pa.lastOwner[port] = cid
+	if err := pa.save(); err != nil {
+		log.Printf("E! Could not persist statsd port allocations: %s", err)
+	}
+}
+
+// save atomically writes the last owner of each port to disk, by writing a
+// temporary file and renaming it. It must be called while pa.mu is held.
+func (pa *portAllocator) save() error {
+	if pa.path == "" {
+		return nil
+	}
+
+	ps := portsState{LastOwner: map[string]string{}}
+	for port, cid := range pa.lastOwner {
+		ps.LastOwner[strconv.Itoa(port)] = cid
+	}
+	data, err := json.Marshal(ps)
+	if err != nil {
+		return err
+	}
+
+	// the temporary file is hidden so that it is not loaded as a container
+	tmp, err := ioutil.TempFile(filepath.Dir(pa.path), portsFile+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), pa.path)
+}
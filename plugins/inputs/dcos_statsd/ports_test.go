@@ -0,0 +1,74 @@
+package dcos_statsd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/influxdata/telegraf/plugins/inputs/dcos_statsd/containers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePortRange(t *testing.T) {
+	min, max, err := parsePortRange("61000-61999")
+	assert.Nil(t, err)
+	assert.Equal(t, 61000, min)
+	assert.Equal(t, 61999, max)
+
+	for _, r := range []string{"61000", "a-b", "61999-61000", "0-100", "65000-70000"} {
+		_, _, err := parsePortRange(r)
+		assert.NotNil(t, err, r)
+	}
+}
+
+func TestPortAllocator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "containers")
+	if err != nil {
+		assert.Fail(t, fmt.Sprintf("Could not create temp dir: %s", err))
+	}
+	defer os.RemoveAll(dir)
+
+	pa, err := newPortAllocator(10000, 10002, dir)
+	assert.Nil(t, err)
+	pa.free = func(int) bool { return true }
+
+	t.Log("Ports are allocated in order")
+	for i, cid := range []string{"abc123", "xyz123", "qqq123"} {
+		port, err := pa.allocate(cid)
+		assert.Nil(t, err)
+		assert.Equal(t, 10000+i, port)
+	}
+
+	t.Log("The range is exhausted")
+	_, err = pa.allocate("new123")
+	assert.Equal(t, containers.ErrNoPortsAvailable, err)
+
+	t.Log("Allocations are restored from disk")
+	pa, err = newPortAllocator(10000, 10002, dir)
+	assert.Nil(t, err)
+	pa.free = func(int) bool { return true }
+
+	t.Log("A container is given its previous port")
+	port, err := pa.allocate("xyz123")
+	assert.Nil(t, err)
+	assert.Equal(t, 10001, port)
+
+	t.Log("A reserved port cannot be taken by another container")
+	assert.Equal(t, containers.ErrPortConflict, pa.reserve("abc123", 10001))
+	assert.Nil(t, pa.reserve("abc123", 10000))
+	assert.Nil(t, pa.reserve("abc123", 20000))
+
+	t.Log("Ports remembered by other containers are allocated last")
+	pa.release("abc123", 10000)
+	pa.release("xyz123", 10001)
+	port, err = pa.allocate("new123")
+	assert.Nil(t, err)
+	assert.Equal(t, 10000, port)
+
+	t.Log("Ports which are not free are skipped")
+	pa.free = func(p int) bool { return p != 10001 }
+	port, err = pa.allocate("xyz123")
+	assert.Nil(t, err)
+	assert.Equal(t, 10002, port)
+}
@@ -0,0 +1,120 @@
+package dcos_statsd
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/influxdata/telegraf/dcosutil"
+	"github.com/mesos/mesos-go/api/v1/lib/httpcli"
+)
+
+// watch reconciles containers against the mesos agent every
+// reconcile_interval until ctx is cancelled
+func (ds *DCOSStatsd) watch(ctx context.Context) {
+	defer ds.wg.Done()
+	ticker := time.NewTicker(ds.ReconcileInterval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ds.reconcile(ctx); err != nil {
+				log.Printf("E! Could not reconcile containers with mesos agent: %s", err)
+			}
+		}
+	}
+}
+
+// reconcile removes containers which are no longer running on the mesos
+// agent, stopping their servers and removing their state. Containers which
+// were added within the last reconcile_interval are kept, as the agent may
+// not yet report them, and a container is only removed once it has been
+// missing from two consecutive reconciliations. Nothing is removed while the
+// agent reports no running containers at all, as it does while it recovers
+// its tasks after a restart.
+func (ds *DCOSStatsd) reconcile(ctx context.Context) error {
+	running, err := ds.getRunningContainers(ctx)
+	if err != nil {
+		return err
+	}
+	if len(running) == 0 {
+		log.Printf("I! The mesos agent reports no running containers; skipping reconciliation")
+		return nil
+	}
+
+	missing := map[string]bool{}
+	now := time.Now()
+	for _, ctr := range ds.ListContainers() {
+		if running[ctr.Id] {
+			continue
+		}
+		ds.rwmu.RLock()
+		added := ds.added[ctr.Id]
+		ds.rwmu.RUnlock()
+		if now.Sub(added) < ds.ReconcileInterval.Duration {
+			continue
+		}
+		if !ds.missing[ctr.Id] {
+			missing[ctr.Id] = true
+			continue
+		}
+
+		if err := ds.RemoveContainer(ctr); err != nil {
+			log.Printf("E! Could not remove container %s: %s", ctr.Id, err)
+			missing[ctr.Id] = true
+			continue
+		}
+		ds.evictions.Incr(1)
+		log.Printf("I! Removed container %s as it is no longer running", ctr.Id)
+	}
+	ds.missing = missing
+	return nil
+}
+
+// getRunningContainers requests the state of the mesos agent and returns the
+// IDs of the containers of its running tasks, including the parents of nested
+// containers
+func (ds *DCOSStatsd) getRunningContainers(ctx context.Context) (map[string]bool, error) {
+	client, err := ds.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ds.Timeout.Duration)
+	defer cancel()
+
+	gs, err := dcosutil.GetAgentState(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	running := map[string]bool{}
+	for _, t := range gs.GetGetTasks().GetLaunchedTasks() {
+		for _, s := range t.GetStatuses() {
+			cs := s.GetContainerStatus()
+			if cs == nil {
+				continue
+			}
+			for cid := cs.GetContainerID(); cid != nil; cid = cid.GetParent() {
+				running[cid.GetValue()] = true
+			}
+		}
+	}
+	return running, nil
+}
+
+// getClient returns the *httpcli.Client configured to make requests to the
+// mesos agent. If it hasn't been created yet, it is created and then returned.
+func (ds *DCOSStatsd) getClient() (*httpcli.Client, error) {
+	if ds.client == nil {
+		client, err := dcosutil.MesosClient(ds.MesosAgentUrl, ds.DCOSConfig)
+		if err != nil {
+			return nil, err
+		}
+		ds.client = client
+	}
+	return ds.client, nil
+}
@@ -0,0 +1,217 @@
+package dcos_task_events
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/dcosutil"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/inputs"
+
+	"github.com/mesos/mesos-go/api/v1/lib"
+	"github.com/mesos/mesos-go/api/v1/lib/httpcli"
+)
+
+const sampleConfig = `
+  ## The URL of the local mesos agent
+  mesos_agent_url = "http://$NODE_PRIVATE_IP:5051"
+  ## The period between requests for the state of the mesos agent; status
+  ## updates are seen up to this period after they happen
+  watch_interval = "5s"
+  ## The period after which requests to mesos should time out
+  timeout = "10s"
+  ## The period for which the transition counts of a task are reported after
+  ## its last transition, once it has left the state of the mesos agent
+  counter_expiry = "10m"
+  ## Add a task_event metric, with the status message, for each transition
+  # emit_events = false
+  ## The user agent to send with requests
+  user_agent = "Telegraf-dcos-task-events"
+  ## Optional IAM configuration
+  # ca_certificate_path = "/run/dcos/pki/CA/ca-bundle.crt"
+  # iam_config_path = "/run/dcos/etc/dcos-telegraf/service_account.json"
+`
+
+// DCOSTaskEvents describes the options available to this plugin
+type DCOSTaskEvents struct {
+	MesosAgentUrl string
+	WatchInterval internal.Duration
+	Timeout       internal.Duration
+	CounterExpiry internal.Duration
+	EmitEvents    bool
+	dcosutil.DCOSConfig
+
+	acc    telegraf.Accumulator
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	client *httpcli.Client
+
+	mu sync.Mutex
+	// counters holds the number of transitions of each task into each state
+	counters map[transition]*counter
+	// present holds the IDs of the tasks in the latest state of the agent
+	present map[string]bool
+}
+
+// transition identifies the transitions of a task into a state, for a reason
+type transition struct {
+	containerID   string
+	frameworkName string
+	taskName      string
+	state         string
+	reason        string
+}
+
+// tags returns the tags of metrics about the transition, omitting those which
+// are unknown
+func (tr transition) tags() map[string]string {
+	tags := map[string]string{}
+	for k, v := range map[string]string{
+		"container_id":   tr.containerID,
+		"framework_name": tr.frameworkName,
+		"task_name":      tr.taskName,
+		"state":          tr.state,
+		"reason":         tr.reason,
+	} {
+		if v != "" {
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
+// counter is the number of transitions seen, the time of the latest, and the
+// task which made them
+type counter struct {
+	taskID  string
+	count   int64
+	updated time.Time
+}
+
+// SampleConfig returns the default configuration
+func (te *DCOSTaskEvents) SampleConfig() string {
+	return sampleConfig
+}
+
+// Description returns a one-sentence description of dcos_task_events
+func (te *DCOSTaskEvents) Description() string {
+	return "Plugin for counting the state transitions of mesos tasks on the local agent"
+}
+
+// Start watches the state of the mesos agent until Stop is called
+func (te *DCOSTaskEvents) Start(acc telegraf.Accumulator) error {
+	if te.WatchInterval.Duration <= 0 {
+		return fmt.Errorf("watch_interval must be positive, got %s", te.WatchInterval.Duration)
+	}
+	te.acc = acc
+	te.counters = map[transition]*counter{}
+	te.present = map[string]bool{}
+
+	var ctx context.Context
+	ctx, te.cancel = context.WithCancel(context.Background())
+	te.wg.Add(1)
+	go func() {
+		defer te.wg.Done()
+		te.watch(ctx)
+	}()
+	return nil
+}
+
+// Stop ends the watch of the mesos agent's state
+func (te *DCOSTaskEvents) Stop() {
+	if te.cancel != nil {
+		te.cancel()
+		te.wg.Wait()
+	}
+}
+
+// Gather adds a counter for each transition. Counters are discarded once
+// they have expired and their task has left the state of the agent, so that
+// no further transitions can restart them.
+func (te *DCOSTaskEvents) Gather(acc telegraf.Accumulator) error {
+	te.mu.Lock()
+	defer te.mu.Unlock()
+
+	now := time.Now()
+	for tr, c := range te.counters {
+		if now.Sub(c.updated) > te.CounterExpiry.Duration && !te.present[c.taskID] {
+			delete(te.counters, tr)
+			continue
+		}
+		acc.AddCounter("task_transitions", map[string]interface{}{"count": c.count}, tr.tags())
+	}
+	return nil
+}
+
+// record counts a transition of a task and, if emit_events is set, adds an
+// event with the status which caused it
+func (te *DCOSTaskEvents) record(taskID string, tr transition, status mesos.TaskStatus) {
+	te.mu.Lock()
+	c, ok := te.counters[tr]
+	if !ok {
+		c = &counter{taskID: taskID}
+		te.counters[tr] = c
+	}
+	c.count++
+	c.updated = time.Now()
+	te.mu.Unlock()
+
+	if !te.EmitEvents {
+		return
+	}
+	fields := map[string]interface{}{
+		"message": status.GetMessage(),
+	}
+	if status.Source != nil {
+		fields["source"] = enumName(status.GetSource().String(), "SOURCE_")
+	}
+	if ts := status.GetTimestamp(); ts > 0 {
+		te.acc.AddFields("task_event", fields, tr.tags(), time.Unix(0, int64(ts*float64(time.Second))))
+	} else {
+		te.acc.AddFields("task_event", fields, tr.tags())
+	}
+}
+
+// getClient returns the *httpcli.Client configured to make requests to Mesos that is a member of te. If it hasn't been
+// created yet, it is created and then returned.
+func (te *DCOSTaskEvents) getClient() (*httpcli.Client, error) {
+	if te.client == nil {
+		client, err := dcosutil.MesosClient(te.MesosAgentUrl, te.DCOSConfig)
+		if err != nil {
+			return nil, err
+		}
+		te.client = client
+	}
+	return te.client, nil
+}
+
+// setPresent records the tasks in the latest state of the agent
+func (te *DCOSTaskEvents) setPresent(tasks map[string]taskInfo) {
+	te.mu.Lock()
+	defer te.mu.Unlock()
+	te.present = map[string]bool{}
+	for tid := range tasks {
+		te.present[tid] = true
+	}
+}
+
+// enumName returns the name of a mesos enum value in lower case, without its
+// prefix, eg TASK_FAILED becomes failed
+func enumName(name string, prefix string) string {
+	return strings.ToLower(strings.TrimPrefix(name, prefix))
+}
+
+func init() {
+	inputs.Add("dcos_task_events", func() telegraf.Input {
+		return &DCOSTaskEvents{
+			WatchInterval: internal.Duration{Duration: 5 * time.Second},
+			Timeout:       internal.Duration{Duration: 10 * time.Second},
+			CounterExpiry: internal.Duration{Duration: 10 * time.Minute},
+		}
+	})
+}
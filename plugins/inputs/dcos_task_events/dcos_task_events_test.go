@@ -0,0 +1,175 @@
+package dcos_task_events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/mesos/mesos-go/api/v1/lib"
+	"github.com/mesos/mesos-go/api/v1/lib/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatch(t *testing.T) {
+	server := testutil.NewMesosAgent(t)
+	defer server.Close()
+
+	setTasks := func(launched, completed []mesos.Task) {
+		server.SetState(agent.Response_GetState{
+			GetTasks: &agent.Response_GetTasks{
+				LaunchedTasks:  launched,
+				CompletedTasks: completed,
+			},
+			GetFrameworks: &agent.Response_GetFrameworks{
+				Frameworks: []agent.Response_GetFrameworks_Framework{{
+					FrameworkInfo: mesos.FrameworkInfo{
+						ID:   &mesos.FrameworkID{Value: "framework.id"},
+						Name: "marathon",
+					},
+				}},
+			},
+		})
+	}
+
+	// The states of the tasks when the plugin starts are not transitions
+	running := newTaskStatus("task1", "abc123", mesos.TASK_RUNNING, 1)
+	setTasks(
+		[]mesos.Task{newTask("task1", running)},
+		[]mesos.Task{newTask("task2", newTaskStatus("task2", "xyz123", mesos.TASK_FAILED, 1))},
+	)
+
+	te := DCOSTaskEvents{
+		MesosAgentUrl: server.URL,
+		WatchInterval: internal.Duration{Duration: 50 * time.Millisecond},
+		Timeout:       internal.Duration{Duration: 500 * time.Millisecond},
+		CounterExpiry: internal.Duration{Duration: time.Minute},
+		EmitEvents:    true,
+	}
+	var acc testutil.Accumulator
+	assert.Nil(t, te.Start(&acc))
+	defer te.Stop()
+	waitForPresent(t, &te, 2, 2*time.Second)
+
+	// The task is killed for exceeding its memory limit, and replaced
+	oom := newTaskStatus("task1", "abc123", mesos.TASK_FAILED, 1388534400)
+	oom.Reason = mesos.REASON_CONTAINER_LIMITATION_MEMORY.Enum()
+	oom.Source = mesos.SOURCE_AGENT.Enum()
+	oom.Message = stringPtr("Memory limit exceeded")
+	setTasks(
+		[]mesos.Task{newTask("task3", newTaskStatus("task3", "def456", mesos.TASK_RUNNING, 1388534401))},
+		[]mesos.Task{
+			newTask("task1", running, oom),
+			newTask("task2", newTaskStatus("task2", "xyz123", mesos.TASK_FAILED, 1)),
+		},
+	)
+
+	waitForMetrics(t, &acc, 2, 2*time.Second)
+
+	failed := map[string]string{
+		"container_id":   "abc123",
+		"framework_name": "marathon",
+		"task_name":      "task1",
+		"state":          "failed",
+		"reason":         "container_limitation_memory",
+	}
+	started := map[string]string{
+		"container_id":   "def456",
+		"framework_name": "marathon",
+		"task_name":      "task3",
+		"state":          "running",
+	}
+
+	acc.AssertContainsTaggedFields(t, "task_event",
+		map[string]interface{}{"message": "Memory limit exceeded", "source": "agent"}, failed)
+	acc.AssertContainsTaggedFields(t, "task_event",
+		map[string]interface{}{"message": ""}, started)
+	assert.True(t, acc.HasTimestamp("task_event", time.Unix(1388534400, 0)))
+
+	// Status updates which were already seen are not counted again
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, uint64(2), acc.NMetrics())
+
+	var counters testutil.Accumulator
+	assert.Nil(t, te.Gather(&counters))
+	counters.AssertContainsTaggedFields(t, "task_transitions", map[string]interface{}{"count": int64(1)}, failed)
+	counters.AssertContainsTaggedFields(t, "task_transitions", map[string]interface{}{"count": int64(1)}, started)
+	assert.Equal(t, uint64(2), counters.NMetrics())
+
+	// Expired counters are still reported while their task is on the agent
+	te.mu.Lock()
+	te.CounterExpiry.Duration = 0
+	te.mu.Unlock()
+	counters.ClearMetrics()
+	assert.Nil(t, te.Gather(&counters))
+	assert.Equal(t, uint64(2), counters.NMetrics())
+
+	// Counters are discarded once they expire and their task has left the
+	// agent
+	setTasks(nil, nil)
+	waitForPresent(t, &te, 0, 2*time.Second)
+	counters.ClearMetrics()
+	assert.Nil(t, te.Gather(&counters))
+	assert.Equal(t, uint64(0), counters.NMetrics())
+}
+
+func TestStartRequiresWatchInterval(t *testing.T) {
+	te := DCOSTaskEvents{}
+	assert.Error(t, te.Start(&testutil.Accumulator{}))
+}
+
+// newTask returns a task with the given status updates
+func newTask(name string, statuses ...mesos.TaskStatus) mesos.Task {
+	return mesos.Task{
+		Name:        name,
+		TaskID:      mesos.TaskID{Value: name},
+		FrameworkID: mesos.FrameworkID{Value: "framework.id"},
+		State:       statuses[len(statuses)-1].State,
+		Statuses:    statuses,
+	}
+}
+
+// newTaskStatus returns a task status holding the given container ID
+func newTaskStatus(taskID, containerID string, state mesos.TaskState, timestamp float64) mesos.TaskStatus {
+	return mesos.TaskStatus{
+		TaskID:    mesos.TaskID{Value: taskID},
+		State:     state.Enum(),
+		Timestamp: &timestamp,
+		ContainerStatus: &mesos.ContainerStatus{
+			ContainerID: &mesos.ContainerID{Value: containerID},
+		},
+	}
+}
+
+// waitForPresent waits for n tasks to be present in the latest state of the
+// agent, or times out
+func waitForPresent(t *testing.T, te *DCOSTaskEvents, n int, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		te.mu.Lock()
+		present := len(te.present)
+		te.mu.Unlock()
+		if present == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d tasks, got %d", n, present)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitForMetrics waits for the accumulator to hold n metrics, or times out
+func waitForMetrics(t *testing.T, acc *testutil.Accumulator, n uint64, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for acc.NMetrics() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d metrics, got %d", n, acc.NMetrics())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
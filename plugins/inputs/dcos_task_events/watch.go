@@ -0,0 +1,125 @@
+package dcos_task_events
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	"github.com/influxdata/telegraf/dcosutil"
+
+	"github.com/mesos/mesos-go/api/v1/lib"
+	"github.com/mesos/mesos-go/api/v1/lib/agent"
+)
+
+// taskInfo is what is known of a task on the local agent
+type taskInfo struct {
+	containerID string
+	state       mesos.TaskState
+	// updated is the timestamp of the latest status update seen
+	updated float64
+}
+
+// watchState is the state accumulated from the states of the mesos agent.
+// It is only accessed from the goroutine which watches the agent.
+type watchState struct {
+	initialized bool
+	tasks       map[string]taskInfo
+}
+
+// watch requests the state of the local mesos agent every watch_interval and
+// records the state transitions of its tasks until ctx is cancelled.
+// Transitions of tasks which are launched and removed from the agent's state
+// between two requests are not seen.
+func (te *DCOSTaskEvents) watch(ctx context.Context) {
+	client, err := te.getClient()
+	if err != nil {
+		log.Printf("E! %s", err)
+		return
+	}
+
+	ws := &watchState{tasks: map[string]taskInfo{}}
+	dcosutil.WatchAgentState(ctx, client, te.Timeout.Duration, te.WatchInterval.Duration,
+		func(gs *agent.Response_GetState, err error) {
+			if err != nil {
+				log.Printf("E! Could not retrieve state from mesos agent %s: %s", te.MesosAgentUrl, err)
+				return
+			}
+			te.handleState(ws, gs)
+		})
+}
+
+// handleState records the transitions of the tasks on the agent since the
+// previous state. The states of the tasks in the first state are not counted
+// as transitions.
+func (te *DCOSTaskEvents) handleState(ws *watchState, gs *agent.Response_GetState) {
+	frameworkNames := map[string]string{}
+	gf := gs.GetGetFrameworks()
+	for _, frameworks := range [][]agent.Response_GetFrameworks_Framework{
+		gf.GetFrameworks(), gf.GetCompletedFrameworks(),
+	} {
+		for _, f := range frameworks {
+			fi := f.GetFrameworkInfo()
+			frameworkNames[fi.GetID().GetValue()] = fi.GetName()
+		}
+	}
+
+	gt := gs.GetGetTasks()
+	tasks := map[string]taskInfo{}
+	for _, list := range [][]mesos.Task{
+		gt.GetLaunchedTasks(), gt.GetTerminatedTasks(), gt.GetCompletedTasks(),
+	} {
+		for _, t := range list {
+			tid := t.GetTaskID().Value
+			ti, ok := ws.tasks[tid]
+			if !ok {
+				// tasks are launched in the staging state
+				ti = taskInfo{state: mesos.TASK_STAGING}
+			}
+			for _, status := range sortStatuses(t.GetStatuses()) {
+				if ok && status.GetTimestamp() <= ti.updated {
+					continue
+				}
+				ti.updated = status.GetTimestamp()
+				if cid := status.GetContainerStatus().GetContainerID().GetValue(); cid != "" {
+					ti.containerID = cid
+				}
+
+				state := status.GetState()
+				if state == ti.state {
+					continue
+				}
+				ti.state = state
+				if !ws.initialized {
+					continue
+				}
+
+				tr := transition{
+					containerID:   ti.containerID,
+					frameworkName: frameworkNames[t.GetFrameworkID().Value],
+					taskName:      t.GetName(),
+					state:         enumName(state.String(), "TASK_"),
+				}
+				// the reason is optional, and its zero value is a valid reason
+				if status.Reason != nil {
+					tr.reason = enumName(status.GetReason().String(), "REASON_")
+				}
+				te.record(tid, tr, status)
+			}
+			tasks[tid] = ti
+		}
+	}
+
+	ws.tasks = tasks
+	ws.initialized = true
+	te.setPresent(tasks)
+}
+
+// sortStatuses returns a copy of the status updates of a task, oldest first
+func sortStatuses(statuses []mesos.TaskStatus) []mesos.TaskStatus {
+	sorted := make([]mesos.TaskStatus, len(statuses))
+	copy(sorted, statuses)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].GetTimestamp() < sorted[j].GetTimestamp()
+	})
+	return sorted
+}
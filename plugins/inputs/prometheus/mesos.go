@@ -0,0 +1,69 @@
+package prometheus
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/influxdata/telegraf/selfstat"
+	"github.com/mesos/mesos-go/api/v1/lib/httpcli/httpagent"
+)
+
+// startMesos registers the discovery metrics and refreshes the targets on
+// the mesos agent in the background until ctx is cancelled
+func (p *Prometheus) startMesos(ctx context.Context) {
+	tags := map[string]string{"mesos_agent_url": p.MesosAgentUrl}
+	p.mesosDiscoveredTargets = selfstat.Register("prometheus", "mesos_discovered_targets", tags)
+	p.mesosDiscoveryErrors = selfstat.Register("prometheus", "mesos_discovery_errors", tags)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(p.MesosRefreshInterval.Duration)
+		defer ticker.Stop()
+		for {
+			if err := p.refreshMesosTargets(ctx); err != nil {
+				log.Printf("E! [inputs.prometheus] unable to discover targets on mesos agent %s: %s", p.MesosAgentUrl, err)
+				p.mesosDiscoveryErrors.Incr(1)
+			}
+			p.lock.Lock()
+			p.mesosDiscoveredTargets.Set(int64(len(p.mesosTargets)))
+			p.lock.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// refreshMesosTargets replaces the targets on the mesos agent with those
+// discovered from its tasks. The previous targets are kept on failure, so
+// that they are still scraped while the agent is unavailable.
+func (p *Prometheus) refreshMesosTargets(ctx context.Context) error {
+	client, err := p.getMesosClient()
+	if err != nil {
+		return err
+	}
+
+	cli := httpagent.NewSender(client.Send)
+	ctx, cancel := context.WithTimeout(ctx, p.MesosTimeout.Duration)
+	defer cancel()
+
+	tasks, err := p.getTasks(ctx, cli)
+	if err != nil {
+		return err
+	}
+
+	targets := map[string]URLAndAddress{}
+	for _, url := range getMesosTaskPrometheusURLs(tasks, p.mesosHostname) {
+		targets[url.URL.String()] = url
+	}
+
+	p.lock.Lock()
+	p.mesosTargets = targets
+	p.lock.Unlock()
+	return nil
+}
@@ -20,16 +20,20 @@ import (
 	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/internal/tls"
 	"github.com/influxdata/telegraf/plugins/inputs"
+	"github.com/influxdata/telegraf/selfstat"
 
 	"github.com/mesos/mesos-go/api/v1/lib"
 	"github.com/mesos/mesos-go/api/v1/lib/agent"
 	"github.com/mesos/mesos-go/api/v1/lib/agent/calls"
 	"github.com/mesos/mesos-go/api/v1/lib/httpcli"
-	"github.com/mesos/mesos-go/api/v1/lib/httpcli/httpagent"
 )
 
 const acceptHeader = `application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7,text/plain;version=0.0.4;q=0.3`
 
+// intervalTolerance is how early a target with its own scrape interval may be
+// scraped
+const intervalTolerance = time.Second
+
 type Prometheus struct {
 	// An array of urls to scrape metrics from.
 	URLs []string `toml:"urls"`
@@ -43,6 +47,8 @@ type Prometheus struct {
 	// The URL of the local mesos agent
 	MesosAgentUrl string
 	MesosTimeout  internal.Duration
+	// The period between requests for the mesos agent's tasks
+	MesosRefreshInterval internal.Duration
 	dcosutil.DCOSConfig
 
 	// Bearer Token authorization file path
@@ -52,7 +58,11 @@ type Prometheus struct {
 
 	tls.ClientConfig
 
-	client *http.Client
+	client         *http.Client
+	insecureClient *http.Client
+
+	// Add a prometheus_scrape metric reporting the health of each scrape
+	EmitScrapeMetrics bool `toml:"emit_scrape_metrics"`
 
 	// Should we scrape Kubernetes services for prometheus annotations
 	MonitorPods    bool `toml:"monitor_kubernetes_pods"`
@@ -63,6 +73,15 @@ type Prometheus struct {
 
 	mesosClient   *httpcli.Client
 	mesosHostname string
+	// mesosTargets holds the URLs discovered by the last successful request
+	// for the mesos agent's tasks
+	mesosTargets           map[string]URLAndAddress
+	mesosDiscoveredTargets selfstat.Stat
+	mesosDiscoveryErrors   selfstat.Stat
+
+	// lastScrape holds the time each target with its own interval was last
+	// scraped
+	lastScrape map[string]time.Time
 }
 
 var sampleConfig = `
@@ -87,6 +106,9 @@ var sampleConfig = `
   mesos_agent_url = "http://$NODE_PRIVATE_IP:5051"
   ## The period after which requests to mesos agent should time out
   mesos_timeout = "10s"
+  ## The period between requests for the tasks on the mesos agent. Targets
+  ## from the last successful request are scraped if a request fails.
+  mesos_refresh_interval = "10s"
 
   ## The user agent to send with requests
   user_agent = "Telegraf-prometheus"
@@ -100,6 +122,11 @@ var sampleConfig = `
   ## Specify timeout duration for slower prometheus clients (default is 3s)
   # response_timeout = "3s"
 
+  ## Add a prometheus_scrape metric for each target, with whether it was
+  ## scraped successfully, the scrape duration, the number of samples and the
+  ## size of the response
+  # emit_scrape_metrics = false
+
   ## Optional TLS Config
   # tls_ca = /path/to/cafile
   # tls_cert = /path/to/certfile
@@ -142,6 +169,12 @@ type URLAndAddress struct {
 	URL         *url.URL
 	Address     string
 	Tags        map[string]string
+
+	// Interval is the minimum period between scrapes of the URL, if it
+	// should be scraped less often than on every gather
+	Interval time.Duration
+	// InsecureSkipVerify skips TLS chain and host verification for the URL
+	InsecureSkipVerify bool
 }
 
 func (p *Prometheus) GetAllURLs() (map[string]URLAndAddress, error) {
@@ -161,6 +194,10 @@ func (p *Prometheus) GetAllURLs() (map[string]URLAndAddress, error) {
 	for k, v := range p.kubernetesPods {
 		allURLs[k] = v
 	}
+	// loop through all tasks discovered on the mesos agent
+	for k, v := range p.mesosTargets {
+		allURLs[k] = v
+	}
 
 	// Kubernetes service discovery
 	for _, service := range p.KubernetesServices {
@@ -184,29 +221,6 @@ func (p *Prometheus) GetAllURLs() (map[string]URLAndAddress, error) {
 		}
 	}
 
-	// Mesos service discovery
-	if p.MesosAgentUrl != "" {
-		client, err := p.getMesosClient()
-		if err != nil {
-			log.Printf("E! %s", err)
-			return allURLs, err
-		}
-
-		cli := httpagent.NewSender(client.Send)
-		ctx, cancel := context.WithTimeout(context.Background(), p.MesosTimeout.Duration)
-		defer cancel()
-
-		tasks, err := p.getTasks(ctx, cli)
-		if err != nil {
-			log.Printf("E! %s", err)
-			return allURLs, err
-		}
-
-		for _, url := range getMesosTaskPrometheusURLs(tasks, p.mesosHostname) {
-			allURLs[url.URL.String()] = url
-		}
-	}
-
 	return allURLs, nil
 }
 
@@ -214,7 +228,7 @@ func (p *Prometheus) GetAllURLs() (map[string]URLAndAddress, error) {
 // Returns one of the errors encountered while gather stats (if any).
 func (p *Prometheus) Gather(acc telegraf.Accumulator) error {
 	if p.client == nil {
-		client, err := p.createHTTPClient()
+		client, err := p.createHTTPClient(false)
 		if err != nil {
 			return err
 		}
@@ -227,11 +241,24 @@ func (p *Prometheus) Gather(acc telegraf.Accumulator) error {
 	if err != nil {
 		return err
 	}
-	for _, URL := range allURLs {
+	for key, URL := range p.dueURLs(allURLs, time.Now()) {
+		if URL.InsecureSkipVerify && p.insecureClient == nil {
+			client, err := p.createHTTPClient(true)
+			if err != nil {
+				acc.AddError(fmt.Errorf("could not create HTTP client for %s: %s", key, err))
+				continue
+			}
+			p.insecureClient = client
+		}
 		wg.Add(1)
 		go func(serviceURL URLAndAddress) {
 			defer wg.Done()
-			acc.AddError(p.gatherURL(serviceURL, acc))
+			start := time.Now()
+			result, err := p.gatherURL(serviceURL, acc)
+			acc.AddError(err)
+			if p.EmitScrapeMetrics {
+				addScrapeMetric(acc, serviceURL, result, err, start)
+			}
 		}(URL)
 	}
 
@@ -240,8 +267,39 @@ func (p *Prometheus) Gather(acc telegraf.Accumulator) error {
 	return nil
 }
 
-func (p *Prometheus) createHTTPClient() (*http.Client, error) {
-	tlsCfg, err := p.ClientConfig.TLSConfig()
+// dueURLs returns the URLs which should be scraped at the given time. URLs
+// with their own interval are only returned once it has elapsed since they
+// were last scraped; URLs which are no longer discovered are forgotten.
+func (p *Prometheus) dueURLs(allURLs map[string]URLAndAddress, now time.Time) map[string]URLAndAddress {
+	due := make(map[string]URLAndAddress, len(allURLs))
+	lastScrape := make(map[string]time.Time)
+	for key, u := range allURLs {
+		if u.Interval <= 0 {
+			due[key] = u
+			continue
+		}
+		last, ok := p.lastScrape[key]
+		// gather times jitter, so a target is due if its interval has all but
+		// elapsed; otherwise it would be scraped an interval late
+		if ok && now.Sub(last) < u.Interval-intervalTolerance {
+			lastScrape[key] = last
+			continue
+		}
+		lastScrape[key] = now
+		due[key] = u
+	}
+	p.lastScrape = lastScrape
+	return due
+}
+
+// createHTTPClient returns a client for scraping targets, which skips TLS
+// verification if insecureSkipVerify is set, whatever the configuration
+func (p *Prometheus) createHTTPClient(insecureSkipVerify bool) (*http.Client, error) {
+	clientConfig := p.ClientConfig
+	if insecureSkipVerify {
+		clientConfig.InsecureSkipVerify = true
+	}
+	tlsCfg, err := clientConfig.TLSConfig()
 	if err != nil {
 		return nil, err
 	}
@@ -257,7 +315,46 @@ func (p *Prometheus) createHTTPClient() (*http.Client, error) {
 	return client, nil
 }
 
-func (p *Prometheus) gatherURL(u URLAndAddress, acc telegraf.Accumulator) error {
+// scrapeResult is the size of a target's response, and the number of samples
+// it held
+type scrapeResult struct {
+	samples int
+	bytes   int
+}
+
+// addScrapeMetric adds a prometheus_scrape metric reporting the health of a
+// scrape of the target which started at the given time
+func addScrapeMetric(acc telegraf.Accumulator, u URLAndAddress, result scrapeResult, err error, start time.Time) {
+	up := 1
+	if err != nil {
+		up = 0
+	}
+	fields := map[string]interface{}{
+		"up":                      up,
+		"scrape_duration_seconds": time.Since(start).Seconds(),
+		"scrape_samples_scraped":  result.samples,
+		"response_size_bytes":     result.bytes,
+	}
+	acc.AddGauge("prometheus_scrape", fields, targetTags(u), start)
+}
+
+// targetTags returns the tags which identify a target on its metrics
+func targetTags(u URLAndAddress) map[string]string {
+	// strip user and password from URL
+	originalURL := *u.OriginalURL
+	originalURL.User = nil
+	tags := map[string]string{"url": originalURL.String()}
+	if u.Address != "" {
+		tags["address"] = u.Address
+	}
+	for k, v := range u.Tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+func (p *Prometheus) gatherURL(u URLAndAddress, acc telegraf.Accumulator) (scrapeResult, error) {
+	var result scrapeResult
 	var req *http.Request
 	var err error
 	var uClient *http.Client
@@ -294,48 +391,48 @@ func (p *Prometheus) gatherURL(u URLAndAddress, acc telegraf.Accumulator) error
 	if p.BearerToken != "" {
 		token, err = ioutil.ReadFile(p.BearerToken)
 		if err != nil {
-			return err
+			return result, err
 		}
 		req.Header.Set("Authorization", "Bearer "+string(token))
 	}
 
 	var resp *http.Response
 	if u.URL.Scheme != "unix" {
-		resp, err = p.client.Do(req)
+		if u.InsecureSkipVerify {
+			resp, err = p.insecureClient.Do(req)
+		} else {
+			resp, err = p.client.Do(req)
+		}
 	} else {
 		resp, err = uClient.Do(req)
 	}
 	if err != nil {
-		return fmt.Errorf("error making HTTP request to %s: %s", u.URL, err)
+		return result, fmt.Errorf("error making HTTP request to %s: %s", u.URL, err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("%s returned HTTP status %s", u.URL, resp.Status)
+		return result, fmt.Errorf("%s returned HTTP status %s", u.URL, resp.Status)
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("error reading body: %s", err)
+		return result, fmt.Errorf("error reading body: %s", err)
 	}
+	result.bytes = len(body)
 
 	metrics, err := Parse(body, resp.Header)
 	if err != nil {
-		return fmt.Errorf("error reading metrics for %s: %s",
+		return result, fmt.Errorf("error reading metrics for %s: %s",
 			u.URL, err)
 	}
 
 	for _, metric := range metrics {
 		tags := metric.Tags()
-		// strip user and password from URL
-		u.OriginalURL.User = nil
-		tags["url"] = u.OriginalURL.String()
-		if u.Address != "" {
-			tags["address"] = u.Address
-		}
-		for k, v := range u.Tags {
+		for k, v := range targetTags(u) {
 			tags[k] = v
 		}
+		result.samples += len(metric.Fields())
 
 		switch metric.Type() {
 		case telegraf.Counter:
@@ -351,10 +448,11 @@ func (p *Prometheus) gatherURL(u URLAndAddress, acc telegraf.Accumulator) error
 		}
 	}
 
-	return nil
+	return result, nil
 }
 
-// Start will start the Kubernetes scraping if enabled in the configuration
+// Start will start the Kubernetes scraping and Mesos discovery if enabled in
+// the configuration
 func (p *Prometheus) Start(a telegraf.Accumulator) error {
 	// Check that the mesos agent url is well-formed
 	if p.MesosAgentUrl != "" {
@@ -364,17 +462,27 @@ func (p *Prometheus) Start(a telegraf.Accumulator) error {
 		if err != nil {
 			return fmt.Errorf("the mesos agent URL was malformed: %s", err)
 		}
+		if p.MesosRefreshInterval.Duration <= 0 {
+			return fmt.Errorf("mesos_refresh_interval must be positive, got %s",
+				p.MesosRefreshInterval.Duration)
+		}
 	}
+
+	var ctx context.Context
+	ctx, p.cancel = context.WithCancel(context.Background())
 	if p.MonitorPods {
-		var ctx context.Context
-		ctx, p.cancel = context.WithCancel(context.Background())
-		return p.start(ctx)
+		if err := p.start(ctx); err != nil {
+			return err
+		}
+	}
+	if p.MesosAgentUrl != "" {
+		p.startMesos(ctx)
 	}
 	return nil
 }
 
 func (p *Prometheus) Stop() {
-	if p.MonitorPods {
+	if p.cancel != nil {
 		p.cancel()
 	}
 	p.wg.Wait()
@@ -496,23 +604,34 @@ func getMesosTaskPrometheusURLs(tasks *agent.Response_GetTasks, hostname string)
 	return results
 }
 
-func makeURLAndAddress(task mesos.Task, endpoint string) (URLAndAddress, error) {
-	URL, err := url.Parse(endpoint)
+func makeURLAndAddress(task mesos.Task, endpoint mesosEndpoint) (URLAndAddress, error) {
+	URL, err := url.Parse(endpoint.url)
 	cid, _ := getContainerIDs(task.GetStatuses())
 	return URLAndAddress{
-		URL:         URL,
-		OriginalURL: URL,
-		Tags:        map[string]string{"container_id": cid},
+		URL:                URL,
+		OriginalURL:        URL,
+		Tags:               map[string]string{"container_id": cid},
+		Interval:           endpoint.interval,
+		InsecureSkipVerify: endpoint.insecureSkipVerify,
 	}, err
 }
 
+// mesosEndpoint is a metrics endpoint of a mesos task, along with the scrape
+// settings requested by the task's labels
+type mesosEndpoint struct {
+	url                string
+	interval           time.Duration
+	insecureSkipVerify bool
+}
+
 // getEndpointsFromTaskPorts retrieves a map of ports end endpoints from which
 // Prometheus metrics can be retrieved from a given task.
-func getEndpointsFromTaskPorts(t *mesos.Task, nodeHostname string) []string {
-	endpoints := []string{}
+func getEndpointsFromTaskPorts(t *mesos.Task, nodeHostname string) []mesosEndpoint {
+	endpoints := []mesosEndpoint{}
 
 	// loop over the task's ports, adding them if they are appropriately labelled
 	taskPorts := getPortsFromTask(t)
+	taskLabels := simplifyLabels(t.GetLabels())
 
 	for _, p := range taskPorts {
 		portLabels := simplifyLabels(p.GetLabels())
@@ -522,11 +641,8 @@ func getEndpointsFromTaskPorts(t *mesos.Task, nodeHostname string) []string {
 				log.Printf("E! %s", err)
 				continue
 			}
-			route := "/metrics"
-			if ep := portLabels["DCOS_METRICS_ENDPOINT"]; ep != "" {
-				route = ep
-			}
-			endpoints = append(endpoints, fmt.Sprintf("http://%s:%d%s", hostname, p.Number, route))
+			endpoint := makeMesosEndpoint(t, hostname, p.Number, portLabels["DCOS_METRICS_ENDPOINT"], portLabels, taskLabels)
+			endpoints = append(endpoints, endpoint)
 		}
 	}
 	return endpoints
@@ -534,12 +650,12 @@ func getEndpointsFromTaskPorts(t *mesos.Task, nodeHostname string) []string {
 
 // getEndpointFromTaskLabels cross-references the task's DCOS_METRICS_PORT_INDEX
 // label, if present, with its ports to yield an endpoint.
-func getEndpointFromTaskLabels(t *mesos.Task, nodeHostname string) (string, bool) {
+func getEndpointFromTaskLabels(t *mesos.Task, nodeHostname string) (mesosEndpoint, bool) {
 	taskPorts := getPortsFromTask(t)
 	taskLabels := simplifyLabels(t.GetLabels())
 
 	if taskLabels["DCOS_METRICS_FORMAT"] != "prometheus" {
-		return "", false
+		return mesosEndpoint{}, false
 	}
 
 	portIndex := taskLabels["DCOS_METRICS_PORT_INDEX"]
@@ -550,7 +666,7 @@ func getEndpointFromTaskLabels(t *mesos.Task, nodeHostname string) (string, bool
 
 	if len(portIndex) == 0 && len(portName) == 0 {
 		// no usable metrics endpoint
-		return "", false
+		return mesosEndpoint{}, false
 	}
 
 	// specifying port via port index has priority of specifying via port name,
@@ -561,12 +677,12 @@ func getEndpointFromTaskLabels(t *mesos.Task, nodeHostname string) (string, bool
 			// non-empty non-int port index is treated as an error, there is no
 			// fallback into name-based association
 			log.Printf("E! Could not retrieve port index for %s: %s", t.GetTaskID(), err)
-			return "", false
+			return mesosEndpoint{}, false
 		}
 		if index < 0 || index >= len(taskPorts) {
 			// same here - no fallback to name-based association
 			log.Printf("E! Could not retrieve port index %d for task %s", index, t.GetTaskID())
-			return "", false
+			return mesosEndpoint{}, false
 		}
 		port = taskPorts[index]
 	} else {
@@ -577,21 +693,75 @@ func getEndpointFromTaskLabels(t *mesos.Task, nodeHostname string) (string, bool
 		}
 		if port.Number == 0 {
 			log.Printf("E! Could not match port name %s for task %s", portName, t.GetTaskID())
-			return "", false
+			return mesosEndpoint{}, false
 		}
 	}
 
 	hostname, err := getHostnameForPort(&port, t, nodeHostname)
 	if err != nil {
 		log.Printf("E! %s", err)
-		return "", false
+		return mesosEndpoint{}, false
 	}
 
+	portLabels := simplifyLabels(port.GetLabels())
+	return makeMesosEndpoint(t, hostname, port.Number, taskLabels["DCOS_METRICS_ENDPOINT"], portLabels, taskLabels), true
+}
+
+// makeMesosEndpoint applies the scrape settings in a task's labels to its
+// endpoint at the given hostname and port. Port labels take priority over
+// task labels, and DCOS_METRICS_PATH takes priority over the legacy
+// DCOS_METRICS_ENDPOINT path. Malformed settings are logged and ignored.
+func makeMesosEndpoint(t *mesos.Task, hostname string, port uint32, legacyPath string, portLabels, taskLabels map[string]string) mesosEndpoint {
+	label := func(key string) string {
+		if v := portLabels[key]; v != "" {
+			return v
+		}
+		return taskLabels[key]
+	}
+
+	// Only http and https are accepted from labels, so that a task cannot
+	// make telegraf connect to a unix socket on the agent.
+	scheme := "http"
+	switch s := label("DCOS_METRICS_SCHEME"); s {
+	case "", "http":
+	case "https":
+		scheme = s
+	default:
+		log.Printf("W! Ignoring DCOS_METRICS_SCHEME %q for task %s, only http and https are supported",
+			s, t.GetTaskID())
+	}
 	route := "/metrics"
-	if ep := taskLabels["DCOS_METRICS_ENDPOINT"]; ep != "" {
-		route = ep
+	if r := label("DCOS_METRICS_PATH"); r != "" {
+		route = r
+	} else if legacyPath != "" {
+		route = legacyPath
+	}
+	// A path which does not start with a slash could change the host
+	if !strings.HasPrefix(route, "/") {
+		log.Printf("W! Ignoring metrics path %q for task %s, it must start with /", route, t.GetTaskID())
+		route = "/metrics"
+	}
+	endpoint := mesosEndpoint{
+		url: fmt.Sprintf("%s://%s:%d%s", scheme, hostname, port, route),
+	}
+
+	if i := label("DCOS_METRICS_INTERVAL"); i != "" {
+		interval, err := time.ParseDuration(i)
+		if err != nil {
+			log.Printf("E! Could not parse DCOS_METRICS_INTERVAL for task %s: %s", t.GetTaskID(), err)
+		} else {
+			endpoint.interval = interval
+		}
+	}
+	if i := label("DCOS_METRICS_TLS_INSECURE"); i != "" {
+		insecure, err := strconv.ParseBool(i)
+		if err != nil {
+			log.Printf("E! Could not parse DCOS_METRICS_TLS_INSECURE for task %s: %s", t.GetTaskID(), err)
+		} else {
+			endpoint.insecureSkipVerify = insecure
+		}
 	}
-	return fmt.Sprintf("http://%s:%d%s", hostname, port.Number, route), true
+	return endpoint
 }
 
 // getPortsFromTask is a convenience method to retrieve a task's ports
@@ -677,9 +847,10 @@ func simplifyLabels(ll *mesos.Labels) map[string]string {
 func init() {
 	inputs.Add("prometheus", func() telegraf.Input {
 		return &Prometheus{
-			ResponseTimeout: internal.Duration{Duration: time.Second * 3},
-			MesosTimeout:    internal.Duration{Duration: time.Second * 10},
-			kubernetesPods:  map[string]URLAndAddress{},
+			ResponseTimeout:      internal.Duration{Duration: time.Second * 3},
+			MesosTimeout:         internal.Duration{Duration: time.Second * 10},
+			MesosRefreshInterval: internal.Duration{Duration: time.Second * 10},
+			kubernetesPods:       map[string]URLAndAddress{},
 		}
 	})
 }
@@ -1,6 +1,7 @@
 package prometheus
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -10,6 +11,7 @@ import (
 
 	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/testutil"
+	"github.com/mesos/mesos-go/api/v1/lib"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -105,6 +107,46 @@ func TestPrometheusGeneratesMetricsAlthoughFirstDNSFails(t *testing.T) {
 	assert.True(t, acc.HasTimestamp("test_metric", time.Unix(1490802350, 0)))
 }
 
+func TestPrometheusEmitsScrapeMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, sampleTextFormat)
+	}))
+	defer ts.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	p := &Prometheus{
+		URLs:              []string{ts.URL, failing.URL},
+		EmitScrapeMetrics: true,
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, p.Gather(&acc))
+	assert.Len(t, acc.Errors, 1)
+
+	scrapes := map[string]map[string]interface{}{}
+	for _, m := range acc.Metrics {
+		if m.Measurement == "prometheus_scrape" {
+			scrapes[m.Tags["url"]] = m.Fields
+		}
+	}
+	require.Len(t, scrapes, 2)
+
+	up := scrapes[ts.URL+"/metrics"]
+	assert.Equal(t, 1, up["up"])
+	// the summary has a sample for its count, its sum and each quantile
+	assert.Equal(t, 9, up["scrape_samples_scraped"])
+	assert.Equal(t, len(sampleTextFormat)+1, up["response_size_bytes"])
+	assert.IsType(t, float64(0), up["scrape_duration_seconds"])
+
+	down := scrapes[failing.URL+"/metrics"]
+	assert.Equal(t, 0, down["up"])
+	assert.Equal(t, 0, down["scrape_samples_scraped"])
+	assert.Equal(t, 0, down["response_size_bytes"])
+}
+
 func TestPrometheusGathersMesosMetrics(t *testing.T) {
 	testCases := map[string]map[string]URLAndAddress{
 		"empty":                    {},
@@ -184,6 +226,7 @@ func TestPrometheusGathersMesosMetrics(t *testing.T) {
 				mesosHostname: "127.0.0.1",
 			}
 
+			assert.Nil(t, p.refreshMesosTargets(context.Background()))
 			urls, err := p.GetAllURLs()
 			assert.Nil(t, err)
 			assert.Equal(t, expected, urls)
@@ -192,6 +235,151 @@ func TestPrometheusGathersMesosMetrics(t *testing.T) {
 	}
 }
 
+func TestPrometheusMesosDiscoveryKeepsTargets(t *testing.T) {
+	server := startTestServer(t, "portlabel")
+
+	p := &Prometheus{
+		MesosTimeout:         internal.Duration{Duration: 500 * time.Millisecond},
+		MesosRefreshInterval: internal.Duration{Duration: 50 * time.Millisecond},
+		MesosAgentUrl:        server.URL,
+	}
+	var acc testutil.Accumulator
+	require.NoError(t, p.Start(&acc))
+	defer p.Stop()
+
+	waitFor(t, func() bool { return p.mesosDiscoveredTargets.Get() == 2 })
+	assert.Equal(t, int64(0), p.mesosDiscoveryErrors.Get())
+
+	// The targets are still scraped while the mesos agent is unavailable
+	server.Close()
+	waitFor(t, func() bool { return p.mesosDiscoveryErrors.Get() > 0 })
+	urls, err := p.GetAllURLs()
+	assert.Nil(t, err)
+	assert.Len(t, urls, 2)
+	assert.Equal(t, int64(2), p.mesosDiscoveredTargets.Get())
+}
+
+func TestPrometheusMesosRefreshIntervalMustBePositive(t *testing.T) {
+	p := &Prometheus{
+		MesosAgentUrl:        "http://127.0.0.1:5051",
+		MesosRefreshInterval: internal.Duration{Duration: 0},
+	}
+	var acc testutil.Accumulator
+	assert.Error(t, p.Start(&acc))
+}
+
+func TestGetMesosEndpointScrapeConfig(t *testing.T) {
+	portName, otherPortName := "metrics", "other"
+	task := mesos.Task{
+		TaskID: mesos.TaskID{Value: "task"},
+		Labels: newLabels(map[string]string{
+			"DCOS_METRICS_FORMAT":       "prometheus",
+			"DCOS_METRICS_PORT_NAME":    "metrics",
+			"DCOS_METRICS_SCHEME":       "https",
+			"DCOS_METRICS_INTERVAL":     "1m",
+			"DCOS_METRICS_TLS_INSECURE": "true",
+		}),
+		Discovery: &mesos.DiscoveryInfo{
+			Ports: &mesos.Ports{
+				Ports: []mesos.Port{
+					{
+						Number: 12345,
+						Name:   &portName,
+						Labels: newLabels(map[string]string{
+							"DCOS_METRICS_PATH": "/federate",
+						}),
+					},
+					{
+						Number: 23456,
+						Name:   &otherPortName,
+						Labels: newLabels(map[string]string{
+							"DCOS_METRICS_FORMAT":   "prometheus",
+							"DCOS_METRICS_ENDPOINT": "/legacy",
+							"DCOS_METRICS_SCHEME":   "http",
+							"DCOS_METRICS_INTERVAL": "not-a-duration",
+						}),
+					},
+				},
+			},
+		},
+	}
+
+	// Task labels apply to the port selected by the task labels, and port
+	// labels take priority over them
+	endpoint, ok := getEndpointFromTaskLabels(&task, "127.0.0.1")
+	assert.True(t, ok)
+	assert.Equal(t, mesosEndpoint{
+		url:                "https://127.0.0.1:12345/federate",
+		interval:           time.Minute,
+		insecureSkipVerify: true,
+	}, endpoint)
+
+	// Task labels also apply to labelled ports; malformed settings are ignored
+	endpoints := getEndpointsFromTaskPorts(&task, "127.0.0.1")
+	assert.Equal(t, []mesosEndpoint{
+		{
+			url:                "http://127.0.0.1:23456/legacy",
+			insecureSkipVerify: true,
+		},
+	}, endpoints)
+}
+
+func TestGetMesosEndpointRejectsUnsafeLabels(t *testing.T) {
+	portName := "metrics"
+	task := mesos.Task{
+		TaskID: mesos.TaskID{Value: "task"},
+		Labels: newLabels(map[string]string{
+			"DCOS_METRICS_FORMAT":    "prometheus",
+			"DCOS_METRICS_PORT_NAME": "metrics",
+			"DCOS_METRICS_SCHEME":    "unix",
+			"DCOS_METRICS_PATH":      "/var/run/docker.sock",
+		}),
+		Discovery: &mesos.DiscoveryInfo{
+			Ports: &mesos.Ports{
+				Ports: []mesos.Port{{Number: 12345, Name: &portName}},
+			},
+		},
+	}
+
+	// Schemes other than http and https are ignored
+	endpoint, ok := getEndpointFromTaskLabels(&task, "127.0.0.1")
+	assert.True(t, ok)
+	assert.Equal(t, "http://127.0.0.1:12345/var/run/docker.sock", endpoint.url)
+
+	// Paths which do not start with a slash are ignored
+	task.Labels = newLabels(map[string]string{
+		"DCOS_METRICS_FORMAT":    "prometheus",
+		"DCOS_METRICS_PORT_NAME": "metrics",
+		"DCOS_METRICS_PATH":      "@example.com/metrics",
+	})
+	endpoint, ok = getEndpointFromTaskLabels(&task, "127.0.0.1")
+	assert.True(t, ok)
+	assert.Equal(t, "http://127.0.0.1:12345/metrics", endpoint.url)
+}
+
+func TestPrometheusScrapeInterval(t *testing.T) {
+	every := URLAndAddress{URL: unsafelyParse("http://127.0.0.1:12345/metrics")}
+	slow := URLAndAddress{URL: unsafelyParse("http://127.0.0.1:23456/metrics"), Interval: 30 * time.Second}
+	allURLs := map[string]URLAndAddress{
+		every.URL.String(): every,
+		slow.URL.String():  slow,
+	}
+
+	p := &Prometheus{}
+	start := time.Unix(1500000000, 0)
+	assert.Len(t, p.dueURLs(allURLs, start), 2)
+	assert.Equal(t, map[string]URLAndAddress{every.URL.String(): every},
+		p.dueURLs(allURLs, start.Add(10*time.Second)))
+	// a slightly early gather does not delay the scrape by a whole interval
+	assert.Len(t, p.dueURLs(allURLs, start.Add(30*time.Second-time.Millisecond)), 2)
+	assert.Len(t, p.dueURLs(allURLs, start.Add(40*time.Second)), 1)
+
+	// targets which are no longer discovered are forgotten
+	p.dueURLs(map[string]URLAndAddress{}, start.Add(50*time.Second))
+	assert.Empty(t, p.lastScrape)
+	assert.Len(t, p.dueURLs(allURLs, start.Add(60*time.Second)), 2)
+}
+
 func TestGetMesosHostname(t *testing.T) {
 	goodUrls := map[string]string{
 		"http://localhost":                       "localhost",
@@ -221,3 +409,24 @@ func unsafelyParse(u string) *url.URL {
 	result, _ := url.Parse(u)
 	return result
 }
+
+// newLabels is a utility method that converts a map to mesos labels
+func newLabels(labels map[string]string) *mesos.Labels {
+	ll := &mesos.Labels{}
+	for k, v := range labels {
+		value := v
+		ll.Labels = append(ll.Labels, mesos.Label{Key: k, Value: &value})
+	}
+	return ll
+}
+
+// waitFor waits for the condition to be true, or times out
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -39,6 +39,14 @@ var dropwarn = "E! Error: statsd message queue full. " +
 	"We have dropped %d messages so far. " +
 	"You may want to increase allowed_pending_messages in the config\n"
 
+var serieswarn = "E! Error: statsd series limit reached. " +
+	"We have dropped %d metrics so far. " +
+	"You may want to increase max_series in the config\n"
+
+var ratewarn = "E! Error: statsd packet rate limit reached. " +
+	"We have dropped %d packets so far. " +
+	"You may want to increase max_packets_per_second in the config\n"
+
 var malformedwarn = "E! Statsd over TCP has received %d malformed packets" +
 	" thus far."
 
@@ -93,6 +101,19 @@ type Statsd struct {
 	drops int
 	// malformed tracks the number of malformed packets
 	malformed int
+	// seriesDrops and rateDrops track the number of metrics and packets
+	// dropped due to MaxSeries and MaxPacketsPerSecond respectively
+	seriesDrops int
+	rateDrops   int
+
+	// Lock for the packet rate, which is updated by every listener
+	rateLock sync.Mutex
+	// rateSecond is the unix time of the second in which ratePackets packets
+	// have been received so far. lastRate is the number of packets received
+	// in the previous second.
+	rateSecond  int64
+	ratePackets int
+	lastRate    int
 
 	// Channel for all incoming statsd packets
 	in   chan *bytes.Buffer
@@ -105,6 +126,9 @@ type Statsd struct {
 	counters map[string]cachedcounter
 	sets     map[string]cachedset
 	timings  map[string]cachedtimings
+	// active holds the series which were updated since the last Gather, keyed
+	// by seriesKey. It is only maintained if MaxSeries is set.
+	active map[string]bool
 
 	// bucket -> influx templates
 	Templates []string
@@ -118,6 +142,19 @@ type Statsd struct {
 
 	MaxTCPConnections int `toml:"max_tcp_connections"`
 
+	// MaxSeries limits the number of distinct series cached between calls to
+	// Gather. Metrics which would add a series beyond the limit are dropped.
+	// Series which were not updated between calls to Gather are removed from
+	// the cache, so that the limit applies to the series of each interval
+	// whether or not the Delete* options are set. A value of 0 disables the
+	// limit.
+	MaxSeries int `toml:"max_series"`
+
+	// MaxPacketsPerSecond limits the rate at which packets are accepted.
+	// Packets received beyond the limit within any one second are dropped. A
+	// value of 0 disables the limit.
+	MaxPacketsPerSecond int `toml:"max_packets_per_second"`
+
 	TCPKeepAlive       bool               `toml:"tcp_keep_alive"`
 	TCPKeepAlivePeriod *internal.Duration `toml:"tcp_keep_alive_period"`
 
@@ -131,6 +168,10 @@ type Statsd struct {
 	PacketsRecv        selfstat.Stat
 	BytesRecv          selfstat.Stat
 	DroppedMessages    selfstat.Stat
+	// DroppedSeries and RateLimitedPackets are registered by Start unless they
+	// were set beforehand, which allows them to be reported with other tags
+	DroppedSeries      selfstat.Stat
+	RateLimitedPackets selfstat.Stat
 
 	// A pool of byte slices to handle parsing
 	bufPool sync.Pool
@@ -233,6 +274,16 @@ const sampleConfig = `
   ## calculation of percentiles. Raising this limit increases the accuracy
   ## of percentiles but also increases the memory usage and cpu time.
   percentile_limit = 1000
+
+  ## Maximum number of distinct series to cache between intervals. Metrics
+  ## which would add a new series beyond the limit are dropped, and series
+  ## which are not updated within an interval are removed from the cache
+  ## (default=0, unlimited)
+  # max_series = 0
+
+  ## Maximum number of packets to accept per second. Packets received beyond
+  ## the limit are dropped (default=0, unlimited)
+  # max_packets_per_second = 0
 `
 
 func (_ *Statsd) SampleConfig() string {
@@ -297,6 +348,10 @@ func (s *Statsd) Gather(acc telegraf.Accumulator) error {
 		s.sets = make(map[string]cachedset)
 	}
 
+	if s.MaxSeries > 0 {
+		s.removeIdleSeries()
+	}
+
 	return nil
 }
 
@@ -321,6 +376,12 @@ func (s *Statsd) Start(_ telegraf.Accumulator) error {
 	s.PacketsRecv = selfstat.Register("statsd", "tcp_packets_received", tags)
 	s.BytesRecv = selfstat.Register("statsd", "tcp_bytes_received", tags)
 	s.DroppedMessages = selfstat.Register("statsd", "dropped_messages", tags)
+	if s.DroppedSeries == nil {
+		s.DroppedSeries = selfstat.Register("statsd", "dropped_series", tags)
+	}
+	if s.RateLimitedPackets == nil {
+		s.RateLimitedPackets = selfstat.Register("statsd", "rate_limited_packets", tags)
+	}
 
 	s.in = make(chan *bytes.Buffer, s.AllowedPendingMessages)
 	s.done = make(chan struct{})
@@ -441,6 +502,9 @@ func (s *Statsd) udpListen() error {
 				log.Printf("E! Error READ: %s\n", err.Error())
 				continue
 			}
+			if !s.allowPacket() {
+				continue
+			}
 			b := s.bufPool.Get().(*bytes.Buffer)
 			b.Reset()
 			b.Write(buf[:n])
@@ -704,6 +768,17 @@ func parseKeyValue(keyvalue string) (string, string) {
 // aggregates and caches the current value(s). It does not deal with the
 // Delete* options, because those are dealt with in the Gather function.
 func (s *Statsd) aggregate(m metric) {
+	if s.MaxSeries > 0 {
+		if !s.isCached(m) && s.series() >= s.MaxSeries {
+			s.reportDroppedSeries()
+			return
+		}
+		if s.active == nil {
+			s.active = make(map[string]bool)
+		}
+		s.active[seriesKey(m.mtype, m.hash)] = true
+	}
+
 	switch m.mtype {
 	case "ms", "h":
 		// Check if the measurement exists
@@ -789,6 +864,124 @@ func (s *Statsd) aggregate(m metric) {
 	}
 }
 
+// isCached returns true if the series of a metric is already cached
+func (s *Statsd) isCached(m metric) bool {
+	var ok bool
+	switch m.mtype {
+	case "ms", "h":
+		_, ok = s.timings[m.hash]
+	case "c":
+		_, ok = s.counters[m.hash]
+	case "g":
+		_, ok = s.gauges[m.hash]
+	case "s":
+		_, ok = s.sets[m.hash]
+	}
+	return ok
+}
+
+// seriesKey returns the key in s.active of the series with the given hash in
+// the cache of metrics of type mtype
+func seriesKey(mtype, hash string) string {
+	if mtype == "h" {
+		mtype = "ms"
+	}
+	return mtype + ":" + hash
+}
+
+// removeIdleSeries removes the series which were not updated since the last
+// Gather from the caches. It must be called while s is locked.
+func (s *Statsd) removeIdleSeries() {
+	for hash := range s.timings {
+		if !s.active[seriesKey("ms", hash)] {
+			delete(s.timings, hash)
+		}
+	}
+	for hash := range s.counters {
+		if !s.active[seriesKey("c", hash)] {
+			delete(s.counters, hash)
+		}
+	}
+	for hash := range s.gauges {
+		if !s.active[seriesKey("g", hash)] {
+			delete(s.gauges, hash)
+		}
+	}
+	for hash := range s.sets {
+		if !s.active[seriesKey("s", hash)] {
+			delete(s.sets, hash)
+		}
+	}
+	s.active = make(map[string]bool)
+}
+
+// series returns the number of distinct series which are cached. It must be
+// called while s is locked.
+func (s *Statsd) series() int {
+	return len(s.timings) + len(s.counters) + len(s.gauges) + len(s.sets)
+}
+
+// Series returns the number of distinct series which are currently cached
+func (s *Statsd) Series() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.series()
+}
+
+// allowPacket counts a received packet against MaxPacketsPerSecond, and
+// returns false if the packet should be dropped
+func (s *Statsd) allowPacket() bool {
+	s.rateLock.Lock()
+	defer s.rateLock.Unlock()
+
+	now := time.Now().Unix()
+	if now != s.rateSecond {
+		if now == s.rateSecond+1 {
+			s.lastRate = s.ratePackets
+		} else {
+			s.lastRate = 0
+		}
+		s.rateSecond = now
+		s.ratePackets = 0
+	}
+	s.ratePackets++
+
+	if s.MaxPacketsPerSecond > 0 && s.ratePackets > s.MaxPacketsPerSecond {
+		s.RateLimitedPackets.Incr(1)
+		s.rateDrops++
+		if s.rateDrops == 1 || s.rateDrops%s.MaxPacketsPerSecond == 0 {
+			log.Printf(ratewarn, s.rateDrops)
+		}
+		return false
+	}
+	return true
+}
+
+// PacketRate returns the number of packets received in the last complete
+// second, including any which were dropped due to MaxPacketsPerSecond
+func (s *Statsd) PacketRate() int {
+	s.rateLock.Lock()
+	defer s.rateLock.Unlock()
+	switch time.Now().Unix() {
+	case s.rateSecond:
+		return s.lastRate
+	case s.rateSecond + 1:
+		return s.ratePackets
+	}
+	return 0
+}
+
+// reportDroppedSeries updates counters and logs when a metric is dropped
+// because it would exceed MaxSeries. It must be called while s is locked.
+func (s *Statsd) reportDroppedSeries() {
+	s.DroppedSeries.Incr(1)
+
+	s.seriesDrops++
+	if s.seriesDrops == 1 || s.seriesDrops%s.MaxSeries == 0 {
+		log.Printf(serieswarn, s.seriesDrops)
+	}
+}
+
 // handler handles a single TCP Connection
 func (s *Statsd) handler(conn *net.TCPConn, id string) {
 	s.CurrentConnections.Incr(1)
@@ -819,6 +1012,9 @@ func (s *Statsd) handler(conn *net.TCPConn, id string) {
 			}
 			s.BytesRecv.Incr(int64(n))
 			s.PacketsRecv.Incr(1)
+			if !s.allowPacket() {
+				continue
+			}
 
 			b := s.bufPool.Get().(*bytes.Buffer)
 			b.Reset()
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/influxdata/telegraf/selfstat"
 	"github.com/influxdata/telegraf/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -1485,6 +1486,78 @@ func TestParse_Counters_Delete(t *testing.T) {
 	}
 }
 
+// Tests the max_series option
+func TestParse_MaxSeries(t *testing.T) {
+	s := NewTestStatsd()
+	s.MaxSeries = 2
+	s.DroppedSeries = selfstat.Register("statsd_test", "dropped_series", map[string]string{})
+
+	lines := []string{
+		"current.users:100|g",
+		"current.users:200|g",
+		"requests:1|c",
+		"users:alice|s",
+		"errors:1|c",
+		"requests:2|c",
+	}
+	for _, line := range lines {
+		require.NoError(t, s.parseStatsdLine(line))
+	}
+
+	// Existing series are still updated once the limit is reached
+	require.NoError(t, test_validate_gauge("current_users", 200, s.gauges))
+	require.NoError(t, test_validate_counter("requests", 3, s.counters))
+	require.Error(t, test_validate_counter("errors", 1, s.counters))
+	assert.Equal(t, 2, s.Series())
+	assert.Equal(t, int64(2), s.DroppedSeries.Get())
+}
+
+// Tests that max_series limits the series of each interval, even if cached
+// series are not deleted
+func TestParse_MaxSeriesPerInterval(t *testing.T) {
+	s := NewTestStatsd()
+	s.MaxSeries = 2
+	s.DroppedSeries = selfstat.Register("statsd_test", "dropped_series_interval", map[string]string{})
+	acc := &testutil.Accumulator{}
+
+	require.NoError(t, s.parseStatsdLine("requests:1|c"))
+	require.NoError(t, s.parseStatsdLine("current.users:100|g"))
+	require.NoError(t, s.Gather(acc))
+
+	// Only requests is updated, so current.users is removed and a new series
+	// fits within the limit
+	require.NoError(t, s.parseStatsdLine("requests:2|c"))
+	require.NoError(t, s.Gather(acc))
+	assert.Equal(t, 1, s.Series())
+	require.NoError(t, s.parseStatsdLine("errors:1|c"))
+
+	require.NoError(t, test_validate_counter("requests", 3, s.counters))
+	require.NoError(t, test_validate_counter("errors", 1, s.counters))
+	require.Error(t, test_validate_gauge("current_users", 100, s.gauges))
+	assert.Equal(t, int64(0), s.DroppedSeries.Get())
+}
+
+// Tests the max_packets_per_second option
+func TestAllowPacket(t *testing.T) {
+	s := NewTestStatsd()
+	s.MaxPacketsPerSecond = 3
+	s.RateLimitedPackets = selfstat.Register("statsd_test", "rate_limited_packets", map[string]string{})
+
+	// The limit applies within a second
+	s.rateSecond = time.Now().Unix()
+	for i := 0; i < 3; i++ {
+		assert.True(t, s.allowPacket())
+	}
+	assert.False(t, s.allowPacket())
+	assert.Equal(t, int64(1), s.RateLimitedPackets.Get())
+
+	// The limit is reset in the next second, and the rate of the previous
+	// second includes dropped packets
+	s.rateSecond--
+	assert.True(t, s.allowPacket())
+	assert.Equal(t, 4, s.PacketRate())
+}
+
 func TestParseKeyValue(t *testing.T) {
 	k, v := parseKeyValue("foo=bar")
 	if k != "foo" {
@@ -0,0 +1,150 @@
+// +build !windows
+
+package dcos_metrics
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dcos/dcos-metrics/producers"
+)
+
+// cacheEntry is the latest message translated from a series
+type cacheEntry struct {
+	message producers.MetricsMessage
+	// seq orders entries by the time their series was first seen, so that
+	// responses are stable
+	seq   uint64
+	added time.Time
+}
+
+// messageCache holds the latest message of each series, keyed by the HashID of
+// the metric from which it was translated, until it expires
+type messageCache struct {
+	expiry time.Duration
+
+	mu      sync.RWMutex
+	entries map[uint64]cacheEntry
+	seq     uint64
+}
+
+func newMessageCache(expiry time.Duration) *messageCache {
+	return &messageCache{
+		expiry:  expiry,
+		entries: map[uint64]cacheEntry{},
+	}
+}
+
+// add caches a message for a series. Datapoints of the previous message of the
+// series are kept unless the new message replaces them, as a plugin may report
+// the fields of a series in several metrics.
+func (c *messageCache) add(id uint64, message producers.MetricsMessage, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[id]
+	if !ok {
+		c.seq++
+		entry.seq = c.seq
+	} else if !entry.added.Add(c.expiry).Before(now) {
+		message.Datapoints = mergeDatapoints(entry.message.Datapoints, message.Datapoints)
+	}
+	entry.message = message
+	entry.added = now
+	c.entries[id] = entry
+}
+
+// expire removes messages which were added longer ago than the expiry
+func (c *messageCache) expire(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, entry := range c.entries {
+		if entry.added.Add(c.expiry).Before(now) {
+			delete(c.entries, id)
+		}
+	}
+}
+
+// messages returns the unexpired messages for which match returns true, in
+// the order in which their series were first seen
+func (c *messageCache) messages(now time.Time, match func(producers.MetricsMessage) bool) []producers.MetricsMessage {
+	c.mu.RLock()
+	entries := []cacheEntry{}
+	for _, entry := range c.entries {
+		if !entry.added.Add(c.expiry).Before(now) && match(entry.message) {
+			entries = append(entries, entry)
+		}
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+	messages := make([]producers.MetricsMessage, len(entries))
+	for i, entry := range entries {
+		messages[i] = entry.message
+	}
+	return messages
+}
+
+// containerIDs returns the sorted IDs of containers with unexpired container
+// or app messages
+func (c *messageCache) containerIDs(now time.Time) []string {
+	ids := map[string]bool{}
+	c.messages(now, func(m producers.MetricsMessage) bool {
+		if m.Dimensions.ContainerID != "" {
+			ids[m.Dimensions.ContainerID] = true
+		}
+		return false
+	})
+
+	result := make([]string, 0, len(ids))
+	for id := range ids {
+		result = append(result, id)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// combineMessages returns a single message holding the datapoints of all
+// messages. Its dimensions are those of the latest message, with the labels of
+// all messages.
+func combineMessages(name string, messages []producers.MetricsMessage) producers.MetricsMessage {
+	combined := producers.MetricsMessage{
+		Name:       name,
+		Datapoints: []producers.Datapoint{},
+	}
+
+	labels := map[string]string{}
+	for _, m := range messages {
+		combined.Datapoints = append(combined.Datapoints, m.Datapoints...)
+		if m.Timestamp >= combined.Timestamp {
+			combined.Dimensions = m.Dimensions
+			combined.Timestamp = m.Timestamp
+		}
+		for k, v := range m.Dimensions.Labels {
+			labels[k] = v
+		}
+	}
+	if len(labels) > 0 {
+		combined.Dimensions.Labels = labels
+	}
+	return combined
+}
+
+// mergeDatapoints returns the datapoints of prev which are not replaced by a
+// datapoint of the same name in next, followed by those of next
+func mergeDatapoints(prev, next []producers.Datapoint) []producers.Datapoint {
+	names := make(map[string]bool, len(next))
+	for _, dp := range next {
+		names[dp.Name] = true
+	}
+
+	merged := make([]producers.Datapoint, 0, len(prev)+len(next))
+	for _, dp := range prev {
+		if !names[dp.Name] {
+			merged = append(merged, dp)
+		}
+	}
+	return append(merged, next...)
+}
@@ -3,20 +3,49 @@
 package dcos_metrics
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"log"
 	"net"
+	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/dcos/dcos-metrics/producers"
-	httpProducer "github.com/dcos/dcos-metrics/producers/http"
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/dcosutil"
 	"github.com/influxdata/telegraf/internal"
+	tlsint "github.com/influxdata/telegraf/internal/tls"
 	"github.com/influxdata/telegraf/plugins/outputs"
+	"github.com/influxdata/telegraf/selfstat"
 )
 
+// Overflow behaviours for a full queue
+const (
+	dropOldest = "drop_oldest"
+	dropNewest = "drop_newest"
+	errorFull  = "error"
+)
+
+// defaultQueueSize is the number of messages which can be queued for the
+// cache if queue_size is not set
+const defaultQueueSize = 10000
+
+// defaultCacheExpiry is the duration for which metrics are cached if
+// cache_expiry is not set
+const defaultCacheExpiry = 2 * time.Minute
+
+// queuedMessage is a message which is yet to be cached, with the HashID of the
+// metric from which it was translated
+type queuedMessage struct {
+	id      uint64
+	message producers.MetricsMessage
+}
+
 type DCOSMetrics struct {
 	Listen            string
 	SystemdSocketName string            `toml:"systemd_socket_name"`
@@ -25,9 +54,26 @@ type DCOSMetrics struct {
 	DCOSNodeRole      string            `toml:"dcos_node_role"`
 	DCOSClusterID     string            `toml:"dcos_cluster_id"`
 	DCOSNodePrivateIP string            `toml:"dcos_node_private_ip"`
+	LabelTags         []string          `toml:"label_tags"`
+	UnitSuffixes      map[string]string `toml:"unit_suffixes"`
+	QueueSize         int               `toml:"queue_size"`
+	QueueOverflow     string            `toml:"queue_overflow"`
+	ServePrometheus   bool              `toml:"serve_prometheus"`
+	tlsint.ServerConfig
+	// TLSAllowedClientCNs restricts the client certificates accepted by the
+	// API server to those with one of these common names
+	TLSAllowedClientCNs []string `toml:"tls_allowed_client_cns"`
 
 	translator producerTranslator
-	metricChan chan producers.MetricsMessage
+	// queue holds messages which are yet to be cached
+	queue  chan queuedMessage
+	cache  *messageCache
+	server *http.Server
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	queueDepth selfstat.Stat
+	dropped    selfstat.Stat
 }
 
 func (d *DCOSMetrics) Description() string {
@@ -56,6 +102,36 @@ func (d *DCOSMetrics) SampleConfig() string {
 
   # Global DC/OS Cluster ID.
   dcos_cluster_id = "4321FEDCBA"
+
+  # Tags which become labels of container, app and node metrics. Leave unset to
+  # use all remaining tags as labels of container and app metrics.
+  #label_tags = ["DCOS_SERVICE_NAME"]
+
+  # The units of container and app metrics without a unit tag, by the suffix of
+  # their field or metric name.
+  #[outputs.dcos_metrics.unit_suffixes]
+  #  _bytes = "bytes"
+  #  _seconds = "seconds"
+
+  # The number of messages which can be queued for the API server.
+  #queue_size = 10000
+
+  # What to do when the queue is full: "drop_oldest" or "drop_newest" drop a
+  # message, and "error" fails the write so that the batch is retried.
+  #queue_overflow = "drop_oldest"
+
+  # Also serve the cached metrics at /metrics in the Prometheus text format.
+  #serve_prometheus = false
+
+  # Optional TLS configuration. Set tls_allowed_cacerts to require clients to
+  # present a certificate signed by one of the given CAs.
+  #tls_cert = "/run/dcos/pki/tls/certs/dcos-metrics.crt"
+  #tls_key = "/run/dcos/pki/tls/private/dcos-metrics.key"
+  #tls_allowed_cacerts = ["/run/dcos/pki/CA/ca-bundle.crt"]
+
+  # Common names which are allowed in client certificates. Leave unset to allow
+  # any certificate signed by tls_allowed_cacerts.
+  #tls_allowed_client_cns = ["dcos-adminrouter"]
 `
 }
 
@@ -65,78 +141,219 @@ func (d *DCOSMetrics) Connect() error {
 		DCOSNodeRole:      d.DCOSNodeRole,
 		DCOSClusterID:     d.DCOSClusterID,
 		DCOSNodePrivateIP: d.DCOSNodePrivateIP,
+		LabelTags:         d.LabelTags,
+		UnitSuffixes:      d.UnitSuffixes,
+	}
+
+	switch d.DCOSNodeRole {
+	case "master", "agent":
+	default:
+		return errors.New("error reading dcos_node_role: must be one of master or agent")
 	}
 
-	config, err := d.producerConfig()
+	if err := d.initQueue(); err != nil {
+		return err
+	}
+
+	tlsConfig, err := d.ServerConfig.TLSConfig()
 	if err != nil {
 		return err
 	}
+	// client certificates are only verified if allowed CAs are configured
+	if len(d.TLSAllowedClientCNs) > 0 && len(d.TLSAllowedCACerts) == 0 {
+		return errors.New("tls_allowed_client_cns requires tls_allowed_cacerts to be set")
+	}
+
+	ln, err := d.listen()
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
 
-	producer, producerChan := httpProducer.New(config)
-	d.metricChan = producerChan
-	go producer.Run()
+	if d.CacheExpiry.Duration == 0 {
+		d.CacheExpiry.Duration = defaultCacheExpiry
+	}
+	d.cache = newMessageCache(d.CacheExpiry.Duration)
+	d.server = &http.Server{Handler: d.authorize(d.newRouter())}
+
+	d.wg.Add(2)
+	go d.forward()
+	go d.janitor()
+	go func() {
+		if err := d.server.Serve(ln); err != http.ErrServerClosed {
+			log.Printf("E! dcos_metrics API server closed: %s", err)
+		}
+	}()
 
 	return nil
 }
 
-// dcos-metrics producers don't offer a mechanism to stop them, and there's nothing to clean up.
-func (d *DCOSMetrics) Close() error { return nil }
+// Close stops caching messages and gracefully shuts down the API server
+func (d *DCOSMetrics) Close() error {
+	if d.done != nil {
+		close(d.done)
+		d.wg.Wait()
+		d.done = nil
+	}
+	if d.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := d.server.Shutdown(ctx)
+		d.server = nil
+		return err
+	}
+	return nil
+}
 
+// Write translates metrics and queues them to be cached. It does not wait for the queue to drain; if the queue is
+// full, messages are dropped, or an error is returned, according to queue_overflow. The exception is a batch larger
+// than the whole queue with queue_overflow = "error", which is queued in chunks as the queue drains.
 func (d *DCOSMetrics) Write(metrics []telegraf.Metric) error {
+	messages := make([]queuedMessage, 0, len(metrics))
 	for _, metric := range metrics {
 		message, ok, err := d.translator.Translate(metric)
 		if err != nil {
 			return errors.New(fmt.Sprintf("error translating metric %s: %s", metric.Name(), err))
 		}
 		if ok {
-			d.metricChan <- message
+			messages = append(messages, queuedMessage{id: metric.HashID(), message: message})
+		}
+	}
+
+	if d.QueueOverflow == errorFull {
+		// A batch larger than the queue could never be accepted as a whole, and would be retried forever
+		if len(messages) > cap(d.queue) {
+			return d.enqueueChunks(messages)
+		}
+		// The batch is rejected as a whole, so that it is not partially queued when it is retried
+		if cap(d.queue)-len(d.queue) < len(messages) {
+			return fmt.Errorf("queue is full: %d of %d messages are pending", len(d.queue), cap(d.queue))
 		}
 	}
+
+	for _, message := range messages {
+		d.enqueue(message)
+	}
+	d.queueDepth.Set(int64(len(d.queue)))
 	return nil
 }
 
-// producerConfig returns a httpProducer.Config configured from d.
-func (d *DCOSMetrics) producerConfig() (httpProducer.Config, error) {
-	var (
-		err        error
-		listenHost string
-		listenPort int
-		listener   net.Listener
-	)
-
-	if d.Listen != "" {
-		listenHost, listenPort, err = splitHostPort(d.Listen)
-		if err != nil {
-			return httpProducer.Config{}, errors.New(fmt.Sprintf("error reading listen: %s", err))
+// initQueue validates the queue configuration and creates the queue
+func (d *DCOSMetrics) initQueue() error {
+	if d.QueueSize == 0 {
+		d.QueueSize = defaultQueueSize
+	}
+	if d.QueueSize < 0 {
+		return errors.New("error reading queue_size: must be positive")
+	}
+	switch d.QueueOverflow {
+	case "":
+		d.QueueOverflow = dropOldest
+	case dropOldest, dropNewest, errorFull:
+	default:
+		return errors.New("error reading queue_overflow: must be one of drop_oldest, drop_newest or error")
+	}
+
+	d.queue = make(chan queuedMessage, d.QueueSize)
+	d.done = make(chan struct{})
+	tags := map[string]string{"listen": d.Listen}
+	d.queueDepth = selfstat.Register("dcos_metrics", "queue_depth", tags)
+	d.dropped = selfstat.Register("dcos_metrics", "dropped_messages", tags)
+	return nil
+}
+
+// enqueue adds a message to the queue without blocking. If the queue is full, either the oldest queued message or
+// the message itself is dropped.
+func (d *DCOSMetrics) enqueue(message queuedMessage) {
+	for {
+		select {
+		case d.queue <- message:
+			return
+		default:
+		}
+
+		if d.QueueOverflow == dropNewest {
+			d.dropped.Incr(1)
+			return
+		}
+		// The forwarder may empty the queue concurrently, in which case there is nothing to drop
+		select {
+		case <-d.queue:
+			d.dropped.Incr(1)
+		default:
 		}
 	}
+}
 
+// enqueueChunks queues messages, waiting for the forwarder to make room whenever the queue is full. It fails if the
+// output is closed before every message is queued.
+func (d *DCOSMetrics) enqueueChunks(messages []queuedMessage) error {
+	defer func() { d.queueDepth.Set(int64(len(d.queue))) }()
+	for i, message := range messages {
+		select {
+		case d.queue <- message:
+		case <-d.done:
+			return fmt.Errorf("output closed with %d of %d messages queued", i, len(messages))
+		}
+	}
+	return nil
+}
+
+// forward caches queued messages until Close is called
+func (d *DCOSMetrics) forward() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.done:
+			return
+		case q := <-d.queue:
+			d.cache.add(q.id, q.message, time.Now())
+			d.queueDepth.Set(int64(len(d.queue)))
+		}
+	}
+}
+
+// janitor removes expired messages from the cache until Close is called
+func (d *DCOSMetrics) janitor() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(d.CacheExpiry.Duration)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case now := <-ticker.C:
+			d.cache.expire(now)
+		}
+	}
+}
+
+// listen returns the listener on which the API server is served: either the
+// systemd socket named systemd_socket_name, or a TCP listener on listen.
+func (d *DCOSMetrics) listen() (net.Listener, error) {
 	if d.SystemdSocketName != "" {
 		listeners, err := dcosutil.ListenersWithNames()
 		if err != nil {
-			return httpProducer.Config{}, fmt.Errorf("error finding systemd socket: %s", err)
+			return nil, fmt.Errorf("error finding systemd socket: %s", err)
 		}
 
 		l, ok := listeners[d.SystemdSocketName]
 		if !ok || len(l) < 1 {
-			return httpProducer.Config{}, fmt.Errorf("systemd socket not found: %s", d.SystemdSocketName)
+			return nil, fmt.Errorf("systemd socket not found: %s", d.SystemdSocketName)
 		}
-		listener = l[0]
+		return l[0], nil
 	}
 
-	switch d.DCOSNodeRole {
-	case "master", "agent":
-	default:
-		return httpProducer.Config{}, errors.New("error reading dcos_node_role: must be one of master or agent")
+	if _, _, err := splitHostPort(d.Listen); err != nil {
+		return nil, errors.New(fmt.Sprintf("error reading listen: %s", err))
 	}
-
-	return httpProducer.Config{
-		IP:          listenHost,
-		Port:        listenPort,
-		CacheExpiry: d.CacheExpiry.Duration,
-		DCOSRole:    d.DCOSNodeRole,
-		Listener:    listener,
-	}, nil
+	ln, err := net.Listen("tcp", d.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on %s: %s", d.Listen, err)
+	}
+	return ln, nil
 }
 
 // splitHostPort splits a string of the format "host:port" and returns the host and port.
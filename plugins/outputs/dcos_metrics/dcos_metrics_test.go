@@ -118,36 +118,27 @@ func TestDCOSMetricsNaNValue(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	resp, err := http.Get(url + "/v0/containers/" + containerID + "/app")
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	if resp.StatusCode != 200 {
-		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	var metrics producers.MetricsMessage
-	json.Unmarshal(body, &metrics)
-
-	found := false
-	for _, dp := range metrics.Datapoints {
-		if dp.Name == "prefix.foo.metric1" {
-			if dp.Value != "" {
-				t.Fatalf("expected datapoint value to be empty string, got %v", dp.Value)
+	// Write only enqueues the metrics, so the datapoint is served once the queue has been drained
+	var value interface{}
+	err = waitFor(func() bool {
+		metrics, ok := getMetricsMessage(url + "/v0/containers/" + containerID + "/app")
+		if !ok {
+			return false
+		}
+		for _, dp := range metrics.Datapoints {
+			if dp.Name == "prefix.foo.metric1" {
+				value = dp.Value
+				return true
 			}
-			found = true
-			break
 		}
-	}
-	if !found {
+		return false
+	})
+	if err != nil {
 		t.Fatal("datapoint missing in response")
 	}
+	if value != "" {
+		t.Fatalf("expected datapoint value to be empty string, got %v", value)
+	}
 }
 
 func TestDCOSMetricsNilValue(t *testing.T) {
@@ -193,37 +184,144 @@ func TestDCOSMetricsNilValue(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	resp, err := http.Get(url + "/v0/node")
+	err = waitFor(func() bool {
+		metrics, ok := getMetricsMessage(url + "/v0/node")
+		if !ok {
+			return false
+		}
+		results := map[string]interface{}{}
+		for _, dp := range metrics.Datapoints {
+			results[dp.Name] = dp.Value
+		}
+		return len(results) == 4
+	})
+	if err != nil {
+		t.Fatal("datapoint missing in response")
+	}
+}
+
+func TestDCOSMetricsQueueOverflow(t *testing.T) {
+	m, err := metric.New(
+		"prefix.foo",
+		map[string]string{"container_id": "cid", "metric_type": "gauge"},
+		map[string]interface{}{"value": 1.0},
+		time.Now(),
+	)
 	if err != nil {
 		t.Fatal(err)
 	}
+	batch := []telegraf.Metric{m, m, m}
+
+	// Each output is given a distinct listen address, as its stats are tagged with it
+	for i, overflow := range []string{"drop_oldest", "drop_newest"} {
+		t.Run(overflow, func(t *testing.T) {
+			dm := &DCOSMetrics{Listen: fmt.Sprintf(":%d", i), QueueSize: 2, QueueOverflow: overflow}
+			if err := dm.initQueue(); err != nil {
+				t.Fatal(err)
+			}
 
-	if resp.StatusCode != 200 {
-		t.Fatalf("expected status code 200, got %d", resp.StatusCode)
+			// The queue is not consumed, so one message of the batch must be dropped
+			if err := dm.Write(batch); err != nil {
+				t.Fatal(err)
+			}
+			if len(dm.queue) != 2 {
+				t.Fatalf("expected 2 queued messages, got %d", len(dm.queue))
+			}
+			if dm.dropped.Get() != 1 {
+				t.Fatalf("expected 1 dropped message, got %d", dm.dropped.Get())
+			}
+			if dm.queueDepth.Get() != 2 {
+				t.Fatalf("expected queue depth of 2, got %d", dm.queueDepth.Get())
+			}
+		})
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
+	t.Run("error", func(t *testing.T) {
+		dm := &DCOSMetrics{Listen: ":2", QueueSize: 4, QueueOverflow: "error"}
+		if err := dm.initQueue(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := dm.Write(batch); err != nil {
+			t.Fatal(err)
+		}
+		// The second batch does not fit, and is rejected without being queued
+		if err := dm.Write(batch); err == nil {
+			t.Fatal("expected an error writing to a full queue")
+		}
+		if len(dm.queue) != 3 {
+			t.Fatalf("expected 3 queued messages, got %d", len(dm.queue))
+		}
+		if dm.dropped.Get() != 0 {
+			t.Fatalf("expected no dropped messages, got %d", dm.dropped.Get())
+		}
+	})
+
+	t.Run("error with a batch larger than the queue", func(t *testing.T) {
+		dm := &DCOSMetrics{Listen: ":3", QueueSize: 2, QueueOverflow: "error"}
+		if err := dm.initQueue(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The batch is queued in chunks as the queue is consumed
+		consumed := make(chan int)
+		go func() {
+			n := 0
+			for n < len(batch) {
+				<-dm.queue
+				n++
+			}
+			consumed <- n
+		}()
+		if err := dm.Write(batch); err != nil {
+			t.Fatal(err)
+		}
+		if n := <-consumed; n != len(batch) {
+			t.Fatalf("expected %d consumed messages, got %d", len(batch), n)
+		}
+		if dm.dropped.Get() != 0 {
+			t.Fatalf("expected no dropped messages, got %d", dm.dropped.Get())
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		dm := &DCOSMetrics{QueueOverflow: "block"}
+		if err := dm.initQueue(); err == nil {
+			t.Fatal("expected an error for an invalid queue_overflow")
+		}
+	})
+}
+
+func TestDCOSMetricsClose(t *testing.T) {
+	dcosMetrics, url, err := setupDCOSMetrics()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	var metrics producers.MetricsMessage
-	json.Unmarshal(body, &metrics)
+	err = waitFor(func() bool {
+		_, err := http.Get(url + "/health")
+		return err == nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	results := map[string]interface{}{}
-	for _, dp := range metrics.Datapoints {
-		results[dp.Name] = dp.Value
+	if err := dcosMetrics.Close(); err != nil {
+		t.Fatal(err)
 	}
-	if len(results) != 4 {
-		t.Fatal("datapoint missing in response")
+
+	// Keep-alive connections made before Close are not reused
+	http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+	if _, err := http.Get(url + "/health"); err == nil {
+		t.Fatal("expected the server to be stopped")
 	}
 }
 
-func setupDCOSMetrics() (DCOSMetrics, string, error) {
+func setupDCOSMetrics() (*DCOSMetrics, string, error) {
 	serverHostPort := fmt.Sprintf("localhost:%d", findFreePort())
 	serverURL := fmt.Sprintf("http://%s", serverHostPort)
 
-	dm := DCOSMetrics{
+	dm := &DCOSMetrics{
 		Listen:            serverHostPort,
 		CacheExpiry:       internal.Duration{Duration: time.Second},
 		MesosID:           "fake-mesos-id",
@@ -245,6 +343,25 @@ func findFreePort() int {
 	return addr.Port
 }
 
+// getMetricsMessage requests url and decodes the MetricsMessage it responds with.
+// It returns false if the request did not succeed.
+func getMetricsMessage(url string) (producers.MetricsMessage, bool) {
+	var metrics producers.MetricsMessage
+	resp, err := http.Get(url)
+	if err != nil {
+		return metrics, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return metrics, false
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return metrics, false
+	}
+	return metrics, json.Unmarshal(body, &metrics) == nil
+}
+
 // waitFor waits five seconds for a condition to be true
 func waitFor(cond func() bool) error {
 	done := make(chan bool)
@@ -0,0 +1,210 @@
+// +build !windows
+
+package dcos_metrics
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dcos/dcos-metrics/producers"
+	"github.com/golang/protobuf/proto"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+var invalidNameCharRE = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// prometheusHandler responds with all cached metrics in the Prometheus text
+// exposition format
+func (d *DCOSMetrics) prometheusHandler(w http.ResponseWriter, r *http.Request) {
+	messages := d.cache.messages(time.Now(), func(producers.MetricsMessage) bool { return true })
+
+	var buf bytes.Buffer
+	for _, mf := range metricFamilies(messages) {
+		if _, err := expfmt.MetricFamilyToText(&buf, mf); err != nil {
+			log.Printf("E! Could not encode metric family %s: %s", mf.GetName(), err)
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("Could not encode metrics"))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", string(expfmt.FmtText))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// sample is a sample of a metric family, with the timestamp of the message
+// from which it was taken
+type sample struct {
+	metric    *dto.Metric
+	timestamp int64
+}
+
+// metricFamilies returns a metric family, sorted by name, for each datapoint
+// name in messages. The DC/OS dimensions of each message become labels of its
+// samples, as do the tags and labels of its datapoints. Datapoints without a
+// numeric value are skipped. Datapoints whose names and labels are the same
+// once sanitized would be duplicate samples, which Prometheus rejects, so only
+// the datapoint of the latest message is kept.
+func metricFamilies(messages []producers.MetricsMessage) []*dto.MetricFamily {
+	families := map[string]*dto.MetricFamily{}
+	samples := map[string]map[string]sample{}
+	for _, m := range messages {
+		for _, dp := range m.Datapoints {
+			value, ok := toFloat(dp.Value)
+			if !ok || dp.Name == "" {
+				continue
+			}
+
+			name := sanitize(dp.Name)
+			if _, ok := families[name]; !ok {
+				families[name] = &dto.MetricFamily{
+					Name: proto.String(name),
+					Type: dto.MetricType_UNTYPED.Enum(),
+				}
+				samples[name] = map[string]sample{}
+			}
+			labels := labelPairs(m.Dimensions, dp.Tags)
+			key := labelsKey(labels)
+			if prev, ok := samples[name][key]; ok && prev.timestamp > m.Timestamp {
+				continue
+			}
+			samples[name][key] = sample{
+				metric: &dto.Metric{
+					Label:   labels,
+					Untyped: &dto.Untyped{Value: proto.Float64(value)},
+				},
+				timestamp: m.Timestamp,
+			}
+		}
+	}
+
+	// samples are sorted by their labels, so that responses are stable
+	for name, mf := range families {
+		keys := make([]string, 0, len(samples[name]))
+		for key := range samples[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			mf.Metric = append(mf.Metric, samples[name][key].metric)
+		}
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := make([]*dto.MetricFamily, len(names))
+	for i, name := range names {
+		result[i] = families[name]
+	}
+	return result
+}
+
+// labelPairs returns the sorted labels of a sample. Dimensions take precedence
+// over datapoint tags, which take precedence over task labels.
+func labelPairs(dims producers.Dimensions, tags map[string]string) []*dto.LabelPair {
+	labels := map[string]string{}
+	for _, m := range []map[string]string{dims.Labels, tags} {
+		for k, v := range m {
+			if k != "" {
+				labels[sanitizeLabel(k)] = v
+			}
+		}
+	}
+	for k, v := range map[string]string{
+		"mesos_id":     dims.MesosID,
+		"cluster_id":   dims.ClusterID,
+		"container_id": dims.ContainerID,
+		"task_name":    dims.TaskName,
+		"service_name": dims.FrameworkName,
+	} {
+		if v != "" {
+			labels[k] = v
+		}
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]*dto.LabelPair, len(keys))
+	for i, k := range keys {
+		pairs[i] = &dto.LabelPair{Name: proto.String(k), Value: proto.String(labels[k])}
+	}
+	return pairs
+}
+
+// sanitize returns value with characters which are not valid in Prometheus
+// names replaced by underscores
+func sanitize(value string) string {
+	// numeric chars are legal, except at the start of a metric name
+	// if the metric name starts with a number, prefix with underscore
+	if c := value[0]; c >= '0' && c <= '9' {
+		value = "_" + value
+	}
+	return invalidNameCharRE.ReplaceAllString(value, "_")
+}
+
+// sanitizeLabel returns a valid Prometheus label name for key. Label names
+// starting with two underscores are reserved by Prometheus, so their leading
+// underscores are collapsed into one.
+func sanitizeLabel(key string) string {
+	name := sanitize(key)
+	if strings.HasPrefix(name, "__") {
+		name = "_" + strings.TrimLeft(name, "_")
+	}
+	return name
+}
+
+// labelsKey returns a key which is the same for equal sorted label pairs
+func labelsKey(pairs []*dto.LabelPair) string {
+	var key bytes.Buffer
+	for _, p := range pairs {
+		key.WriteString(p.GetName())
+		key.WriteByte(0xff)
+		key.WriteString(p.GetValue())
+		key.WriteByte(0xff)
+	}
+	return key.String()
+}
+
+// toFloat returns a datapoint value as a float64, and whether it is numeric
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,128 @@
+// +build !windows
+
+package dcos_metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dcos/dcos-metrics/producers"
+	"github.com/gorilla/mux"
+)
+
+// newRouter returns a handler which serves the DC/OS Metrics API v0 and,
+// optionally, the Prometheus exposition format from the cache
+func (d *DCOSMetrics) newRouter() http.Handler {
+	router := mux.NewRouter().StrictSlash(true)
+	router.HandleFunc("/v0/node", d.nodeHandler).Methods("GET")
+	router.HandleFunc("/v0/containers", d.containersHandler).Methods("GET")
+	router.HandleFunc("/v0/containers/{id}", d.containerHandler).Methods("GET")
+	router.HandleFunc("/v0/containers/{id}/app", d.appHandler).Methods("GET")
+	router.HandleFunc("/v0/ping", pingHandler).Methods("GET")
+	if d.ServePrometheus {
+		router.HandleFunc("/metrics", d.prometheusHandler).Methods("GET")
+	}
+	return router
+}
+
+// nodeHandler responds with the node's metrics
+func (d *DCOSMetrics) nodeHandler(w http.ResponseWriter, r *http.Request) {
+	messages := d.cache.messages(time.Now(), func(m producers.MetricsMessage) bool {
+		return m.Name == producers.NodeMetricPrefix
+	})
+	message := combineMessages(producers.NodeMetricPrefix, messages)
+	if len(messages) == 0 {
+		message.Dimensions = producers.Dimensions{
+			MesosID:   d.MesosID,
+			ClusterID: d.DCOSClusterID,
+			Hostname:  d.DCOSNodePrivateIP,
+		}
+	}
+	writeJSON(w, message)
+}
+
+// containersHandler responds with the IDs of containers for which metrics are cached
+func (d *DCOSMetrics) containersHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, d.cache.containerIDs(time.Now()))
+}
+
+// containerHandler responds with the resource metrics of a container
+func (d *DCOSMetrics) containerHandler(w http.ResponseWriter, r *http.Request) {
+	d.writeContainerMessage(w, producers.ContainerMetricPrefix, mux.Vars(r)["id"])
+}
+
+// appHandler responds with the metrics reported by the app in a container
+func (d *DCOSMetrics) appHandler(w http.ResponseWriter, r *http.Request) {
+	d.writeContainerMessage(w, producers.AppMetricPrefix, mux.Vars(r)["id"])
+}
+
+// writeContainerMessage responds with the combined messages of the given name
+// for a container, or 404 Not Found if there are none
+func (d *DCOSMetrics) writeContainerMessage(w http.ResponseWriter, name string, cid string) {
+	messages := d.cache.messages(time.Now(), func(m producers.MetricsMessage) bool {
+		return m.Name == name && m.Dimensions.ContainerID == cid
+	})
+	if len(messages) == 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "No metrics found for container %q", cid)
+		return
+	}
+	writeJSON(w, combineMessages(name, messages))
+}
+
+// pingHandler responds to health checks
+func pingHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"ok":        true,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// authorize wraps a handler, such that requests over TLS whose client
+// certificate does not have an allowed common name are rejected as 403
+// Forbidden
+func (d *DCOSMetrics) authorize(inner http.Handler) http.Handler {
+	if len(d.TLSAllowedClientCNs) == 0 {
+		return inner
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 ||
+			!contains(d.TLSAllowedClientCNs, r.TLS.PeerCertificates[0].Subject.CommonName) {
+			log.Printf("I! Rejected request to %s: client certificate is not allowed", r.RequestURI)
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "Forbidden")
+			return
+		}
+		inner.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON responds with v encoded as JSON
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("E! Could not encode json: %s", err)
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, "Could not encode response")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// contains returns true if s is in list
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
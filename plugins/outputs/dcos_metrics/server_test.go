@@ -0,0 +1,448 @@
+// +build !windows
+
+package dcos_metrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dcos/dcos-metrics/producers"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+var pki = testutil.NewPKI("../../../testutil/pki")
+
+func TestServerResponses(t *testing.T) {
+	appTags := map[string]string{
+		"container_id": "cid",
+		"service_name": "sname",
+		"task_name":    "tname",
+		"metric_type":  "mtype",
+		"label_name":   "label_value",
+	}
+	dimensions := producers.Dimensions{
+		MesosID:       translator.MesosID,
+		ClusterID:     translator.DCOSClusterID,
+		Hostname:      translator.DCOSNodePrivateIP,
+		ContainerID:   "cid",
+		FrameworkName: "sname",
+		TaskName:      "tname",
+		Labels:        map[string]string{"label_name": "label_value"},
+	}
+
+	testCases := []struct {
+		name   string
+		input  metricParams
+		output producers.MetricsMessage
+	}{
+		{
+			name: "node metric",
+			input: metricParams{
+				name:   "prefix.system",
+				fields: map[string]interface{}{"uptime": uint64(10)},
+				tm:     tm,
+				tp:     telegraf.Gauge,
+			},
+			output: producers.MetricsMessage{
+				Name: "dcos.metrics.node",
+				Dimensions: producers.Dimensions{
+					MesosID:   translator.MesosID,
+					ClusterID: translator.DCOSClusterID,
+					Hostname:  translator.DCOSNodePrivateIP,
+				},
+				Datapoints: []producers.Datapoint{
+					{Name: "system.uptime", Unit: "count", Value: uint64(10), Timestamp: timestamp},
+				},
+			},
+		},
+		{
+			name: "container metric",
+			input: metricParams{
+				name: "prefix.foo",
+				tags: map[string]string{
+					"container_id":  "cid",
+					"service_name":  "sname",
+					"task_name":     "tname",
+					"executor_name": "ename",
+					"label_name":    "label_value",
+				},
+				fields: map[string]interface{}{"metric1": uint64(1)},
+				tm:     tm,
+				tp:     telegraf.Untyped,
+			},
+			output: producers.MetricsMessage{
+				Name:       "dcos.metrics.container",
+				Dimensions: dimensions,
+				Datapoints: []producers.Datapoint{
+					{
+						Name:      "prefix.foo.metric1",
+						Value:     uint64(1),
+						Timestamp: timestamp,
+						Tags:      map[string]string{"container_id": "cid", "executor_name": "ename"},
+					},
+				},
+			},
+		},
+		{
+			name: "app metric with NaN value",
+			input: metricParams{
+				name:   "prefix.foo",
+				tags:   appTags,
+				fields: map[string]interface{}{"metric1": math.NaN()},
+				tm:     tm,
+				tp:     telegraf.Untyped,
+			},
+			output: producers.MetricsMessage{
+				Name:       "dcos.metrics.app",
+				Dimensions: dimensions,
+				Datapoints: []producers.Datapoint{
+					{
+						Name:      "prefix.foo.metric1",
+						Value:     "",
+						Timestamp: timestamp,
+						Tags:      map[string]string{"label_name": "label_value"},
+					},
+				},
+			},
+		},
+	}
+
+	// Each message must be served as JSON, as it was by the dcos-metrics HTTP producer
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dm := &DCOSMetrics{
+				Listen:            fmt.Sprintf("localhost:%d", findFreePort()),
+				MesosID:           translator.MesosID,
+				DCOSNodeRole:      translator.DCOSNodeRole,
+				DCOSClusterID:     translator.DCOSClusterID,
+				DCOSNodePrivateIP: translator.DCOSNodePrivateIP,
+			}
+			if err := dm.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			defer dm.Close()
+
+			if err := dm.Write([]telegraf.Metric{tc.input.NewMetric(t)}); err != nil {
+				t.Fatal(err)
+			}
+
+			path := "/v0/node"
+			switch tc.output.Name {
+			case producers.ContainerMetricPrefix:
+				path = "/v0/containers/" + tc.output.Dimensions.ContainerID
+			case producers.AppMetricPrefix:
+				path = "/v0/containers/" + tc.output.Dimensions.ContainerID + "/app"
+			}
+
+			var actual producers.MetricsMessage
+			err := waitFor(func() bool {
+				body, status := get(http.DefaultClient, "http://"+dm.Listen+path)
+				if status != http.StatusOK {
+					return false
+				}
+				actual = producers.MetricsMessage{}
+				return json.Unmarshal(body, &actual) == nil && len(actual.Datapoints) > 0
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The expected message is encoded and decoded, so that its values have the types of decoded JSON
+			var expected producers.MetricsMessage
+			data, err := json.Marshal(tc.output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(data, &expected); err != nil {
+				t.Fatal(err)
+			}
+
+			actual.Timestamp = 0
+			if !reflect.DeepEqual(actual, expected) {
+				t.Log("expected:", expected)
+				t.Log("actually:", actual)
+				t.Fatal("server returned an unexpected MetricsMessage")
+			}
+
+			if id := tc.output.Dimensions.ContainerID; id != "" {
+				body, _ := get(http.DefaultClient, "http://"+dm.Listen+"/v0/containers")
+				if string(body) != fmt.Sprintf(`["%s"]`, id) {
+					t.Fatalf("expected containers [%q], got %s", id, body)
+				}
+			}
+		})
+	}
+}
+
+func TestServerNotFound(t *testing.T) {
+	dm, url, err := setupDCOSMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	err = waitFor(func() bool {
+		_, status := get(http.DefaultClient, url+"/v0/ping")
+		return status == http.StatusOK
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, status := get(http.DefaultClient, url+"/v0/containers")
+	if status != http.StatusOK || string(body) != "[]" {
+		t.Fatalf("expected no containers, got %d %s", status, body)
+	}
+
+	for _, path := range []string{"/v0/containers/cid", "/v0/containers/cid/app", "/metrics"} {
+		if _, status := get(http.DefaultClient, url+path); status != http.StatusNotFound {
+			t.Fatalf("expected status code 404 for %s, got %d", path, status)
+		}
+	}
+}
+
+func TestServerPrometheus(t *testing.T) {
+	dm := &DCOSMetrics{
+		Listen:            fmt.Sprintf("localhost:%d", findFreePort()),
+		MesosID:           "mesos_id",
+		DCOSNodeRole:      "agent",
+		DCOSClusterID:     "cluster_id",
+		DCOSNodePrivateIP: "10.0.0.1",
+		ServePrometheus:   true,
+	}
+	if err := dm.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	appTags := map[string]string{
+		"container_id": "cid",
+		"service_name": "sname",
+		"task_name":    "tname",
+		"metric_type":  "mtype",
+		"label_name":   "label_value",
+	}
+	inputs := []metricParams{
+		{
+			name:   "prefix.cpu",
+			tags:   map[string]string{"cpu": "cpu-total"},
+			fields: map[string]interface{}{"usage_idle": 70.0, "usage_user": 20.0, "usage_system": 6.0, "usage_iowait": 4.0},
+			tm:     tm,
+			tp:     telegraf.Gauge,
+		},
+		{
+			name:   "prefix.foo",
+			tags:   appTags,
+			fields: map[string]interface{}{"metric1": uint64(0), "metric2": uint64(1)},
+			tm:     tm,
+			tp:     telegraf.Untyped,
+		},
+		{
+			name:   "prefix.foo",
+			tags:   appTags,
+			fields: map[string]interface{}{"metric1": math.NaN()},
+			tm:     tm,
+			tp:     telegraf.Untyped,
+		},
+	}
+	metrics := []telegraf.Metric{}
+	for _, input := range inputs {
+		metrics = append(metrics, input.NewMetric(t))
+	}
+	if err := dm.Write(metrics); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"# TYPE cpu_total untyped",
+		`cpu_total{cluster_id="cluster_id",mesos_id="mesos_id"} 30`,
+		`cpu_idle{cluster_id="cluster_id",mesos_id="mesos_id"} 70`,
+		"# TYPE prefix_foo_metric2 untyped",
+		`prefix_foo_metric2{cluster_id="cluster_id",container_id="cid",label_name="label_value",mesos_id="mesos_id",service_name="sname",task_name="tname"} 1`,
+	}
+	var body []byte
+	err := waitFor(func() bool {
+		var status int
+		body, status = get(http.DefaultClient, "http://"+dm.Listen+"/metrics")
+		return status == http.StatusOK && bytes.Contains(body, []byte("prefix_foo_metric2"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range expected {
+		if !bytes.Contains(body, []byte(line+"\n")) {
+			t.Fatalf("expected line %q in response:\n%s", line, body)
+		}
+	}
+
+	// The NaN value of metric1 in the second app metric replaced its value in the first, and is not a sample
+	if bytes.Contains(body, []byte("prefix_foo_metric1{")) {
+		t.Fatalf("expected no samples of prefix_foo_metric1 in response:\n%s", body)
+	}
+}
+
+func TestMetricFamilies(t *testing.T) {
+	message := func(timestamp int64, tag string, value float64) producers.MetricsMessage {
+		return producers.MetricsMessage{
+			Name:       producers.AppMetricPrefix,
+			Dimensions: producers.Dimensions{ContainerID: "cid"},
+			Datapoints: []producers.Datapoint{
+				{Name: "foo.bar", Value: value, Tags: map[string]string{tag: "v"}},
+			},
+			Timestamp: timestamp,
+		}
+	}
+
+	// The tags of both datapoints are sanitized to the same label, so only the
+	// datapoint of the latest message is a sample, whichever comes first
+	families := metricFamilies([]producers.MetricsMessage{
+		message(2, "a.b", 2),
+		message(1, "a_b", 1),
+		message(1, "__name__", 3),
+	})
+	if len(families) != 1 || families[0].GetName() != "foo_bar" {
+		t.Fatalf("expected the metric family foo_bar, got %v", families)
+	}
+	samples := families[0].GetMetric()
+	if len(samples) != 2 {
+		t.Fatalf("expected 2 samples, got %d", len(samples))
+	}
+
+	values := map[string]float64{}
+	for _, s := range samples {
+		for _, l := range s.GetLabel() {
+			if l.GetName() != "container_id" {
+				values[l.GetName()] = s.GetUntyped().GetValue()
+			}
+		}
+	}
+	// Labels starting with two underscores are reserved by Prometheus
+	expected := map[string]float64{"a_b": 2, "_name__": 3}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("expected samples %v, got %v", expected, values)
+	}
+}
+
+func TestServerTLS(t *testing.T) {
+	clientConfig, err := pki.TLSClientConfig().TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+
+	for _, tc := range []struct {
+		name    string
+		allowed []string
+		status  int
+	}{
+		{"any client certificate", nil, http.StatusOK},
+		{"an allowed client certificate", []string{"client.localdomain"}, http.StatusOK},
+		{"a disallowed client certificate", []string{"agent.localdomain"}, http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dm := &DCOSMetrics{
+				Listen:              fmt.Sprintf("localhost:%d", findFreePort()),
+				DCOSNodeRole:        "agent",
+				ServerConfig:        *pki.TLSServerConfig(),
+				TLSAllowedClientCNs: tc.allowed,
+			}
+			if err := dm.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			defer dm.Close()
+
+			url := "https://" + dm.Listen + "/v0/ping"
+			err := waitFor(func() bool {
+				_, err := client.Get(url)
+				return err == nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, status := get(client, url); status != tc.status {
+				t.Fatalf("expected status code %d, got %d", tc.status, status)
+			}
+		})
+	}
+
+	t.Run("no client certificate", func(t *testing.T) {
+		dm := &DCOSMetrics{
+			Listen:       fmt.Sprintf("localhost:%d", findFreePort()),
+			DCOSNodeRole: "agent",
+			ServerConfig: *pki.TLSServerConfig(),
+		}
+		if err := dm.Connect(); err != nil {
+			t.Fatal(err)
+		}
+		defer dm.Close()
+
+		noCert := clientConfig.Clone()
+		noCert.Certificates = nil
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: noCert}}
+		if _, err := client.Get("https://" + dm.Listen + "/v0/ping"); err == nil {
+			t.Fatal("expected the TLS handshake to fail")
+		}
+	})
+}
+
+func TestMessageCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newMessageCache(time.Minute)
+
+	node := func(names ...string) producers.MetricsMessage {
+		m := producers.MetricsMessage{Name: producers.NodeMetricPrefix}
+		for _, n := range names {
+			m.Datapoints = append(m.Datapoints, producers.Datapoint{Name: n})
+		}
+		return m
+	}
+	isNode := func(m producers.MetricsMessage) bool { return m.Name == producers.NodeMetricPrefix }
+
+	// Messages of the same series are merged; messages of other series are kept apart
+	c.add(1, node("load.1min", "load.5min"), now)
+	c.add(2, node("process.count"), now)
+	c.add(1, node("load.5min", "system.uptime"), now.Add(time.Second))
+
+	combined := combineMessages(producers.NodeMetricPrefix, c.messages(now.Add(time.Second), isNode))
+	names := []string{}
+	for _, dp := range combined.Datapoints {
+		names = append(names, dp.Name)
+	}
+	expected := []string{"load.1min", "load.5min", "system.uptime", "process.count"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected datapoints %v, got %v", expected, names)
+	}
+
+	// Series which were not updated within the expiry are removed
+	c.expire(now.Add(time.Minute + time.Millisecond))
+	if messages := c.messages(now.Add(time.Minute+time.Millisecond), isNode); len(messages) != 1 {
+		t.Fatalf("expected 1 unexpired message, got %d", len(messages))
+	}
+	if len(c.entries) != 1 {
+		t.Fatalf("expected 1 cached series, got %d", len(c.entries))
+	}
+}
+
+// get requests url with client and returns the response body and status code.
+// The status code is 0 if the request failed.
+func get(client *http.Client, url string) ([]byte, int) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, 0
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0
+	}
+	return body, resp.StatusCode
+}
@@ -21,6 +21,12 @@ type producerTranslator struct {
 	DCOSNodeRole      string
 	DCOSClusterID     string
 	DCOSNodePrivateIP string
+	// LabelTags is an allowlist of tags which become labels. If empty, all tags of container and app metrics which
+	// are not otherwise used become labels, and node metrics have no labels.
+	LabelTags []string
+	// UnitSuffixes maps suffixes of field or metric names to the unit of their datapoints. It is consulted for
+	// container and app metrics without a unit tag.
+	UnitSuffixes map[string]string
 }
 
 // metricMapping describes the relationship between a telegraf metric name and
@@ -98,6 +104,7 @@ func (t *producerTranslator) containerMetricsMessage(m telegraf.Metric) producer
 	frameworkName := getAndDelete(tags, "service_name") // DC/OS services are Mesos frameworks.
 	taskName := getAndDelete(tags, "task_name")
 	executorName := getAndDelete(tags, "executor_name")
+	unit := getAndDelete(tags, "unit")
 
 	dpTags := map[string]string{"container_id": containerID}
 	if executorName != "" {
@@ -106,7 +113,7 @@ func (t *producerTranslator) containerMetricsMessage(m telegraf.Metric) producer
 
 	return producers.MetricsMessage{
 		Name:       producers.ContainerMetricPrefix,
-		Datapoints: datapointsFromMetric(m, dpTags),
+		Datapoints: datapointsFromMetric(m, dpTags, t.unitFunc(m, unit)),
 		Dimensions: producers.Dimensions{
 			MesosID:       t.MesosID,
 			ClusterID:     t.DCOSClusterID,
@@ -114,7 +121,7 @@ func (t *producerTranslator) containerMetricsMessage(m telegraf.Metric) producer
 			ContainerID:   containerID,
 			FrameworkName: frameworkName,
 			TaskName:      taskName,
-			Labels:        tags,
+			Labels:        t.labels(tags, true),
 		},
 	}
 }
@@ -126,12 +133,21 @@ func (t *producerTranslator) appMetricsMessage(m telegraf.Metric) producers.Metr
 	containerID := getAndDelete(tags, "container_id")
 	frameworkName := getAndDelete(tags, "service_name") // DC/OS services are Mesos frameworks.
 	taskName := getAndDelete(tags, "task_name")
+	unit := getAndDelete(tags, "unit")
 	// We don't use metric_type.
 	delete(tags, "metric_type")
 
+	// The url tag identifies the endpoint scraped by the prometheus input, rather than the task.
+	labelTags := make(map[string]string, len(tags))
+	for k, v := range tags {
+		if k != "url" {
+			labelTags[k] = v
+		}
+	}
+
 	return producers.MetricsMessage{
 		Name:       producers.AppMetricPrefix,
-		Datapoints: datapointsFromMetric(m, tags),
+		Datapoints: datapointsFromMetric(m, tags, t.unitFunc(m, unit)),
 		Dimensions: producers.Dimensions{
 			MesosID:       t.MesosID,
 			ClusterID:     t.DCOSClusterID,
@@ -139,7 +155,7 @@ func (t *producerTranslator) appMetricsMessage(m telegraf.Metric) producers.Metr
 			ContainerID:   containerID,
 			FrameworkName: frameworkName,
 			TaskName:      taskName,
-			Labels:        nil,
+			Labels:        t.labels(labelTags, true),
 		},
 	}
 }
@@ -195,6 +211,7 @@ func (t *producerTranslator) cpuMetricsMessage(m telegraf.Metric) (producers.Met
 			MesosID:   t.MesosID,
 			ClusterID: t.DCOSClusterID,
 			Hostname:  t.DCOSNodePrivateIP,
+			Labels:    t.labels(m.Tags(), false),
 		},
 	}, nil
 }
@@ -254,6 +271,7 @@ func (t *producerTranslator) diskMetricsMessage(m telegraf.Metric) producers.Met
 			MesosID:   t.MesosID,
 			ClusterID: t.DCOSClusterID,
 			Hostname:  t.DCOSNodePrivateIP,
+			Labels:    t.labels(m.Tags(), false),
 		},
 	}
 }
@@ -294,6 +312,7 @@ func (t *producerTranslator) memMetricsMessage(m telegraf.Metric) producers.Metr
 			MesosID:   t.MesosID,
 			ClusterID: t.DCOSClusterID,
 			Hostname:  t.DCOSNodePrivateIP,
+			Labels:    t.labels(m.Tags(), false),
 		},
 	}
 }
@@ -328,6 +347,7 @@ func (t *producerTranslator) swapMetricsMessage(m telegraf.Metric) producers.Met
 			MesosID:   t.MesosID,
 			ClusterID: t.DCOSClusterID,
 			Hostname:  t.DCOSNodePrivateIP,
+			Labels:    t.labels(m.Tags(), false),
 		},
 	}
 }
@@ -369,6 +389,7 @@ func (t *producerTranslator) netMetricsMessage(m telegraf.Metric) producers.Metr
 			MesosID:   t.MesosID,
 			ClusterID: t.DCOSClusterID,
 			Hostname:  t.DCOSNodePrivateIP,
+			Labels:    t.labels(m.Tags(), false),
 		},
 	}
 }
@@ -389,6 +410,7 @@ func (t *producerTranslator) processesMetricsMessage(m telegraf.Metric) producer
 			MesosID:   t.MesosID,
 			ClusterID: t.DCOSClusterID,
 			Hostname:  t.DCOSNodePrivateIP,
+			Labels:    t.labels(m.Tags(), false),
 		},
 	}
 }
@@ -424,13 +446,78 @@ func (t *producerTranslator) systemMetricsMessage(m telegraf.Metric) producers.M
 			MesosID:   t.MesosID,
 			ClusterID: t.DCOSClusterID,
 			Hostname:  t.DCOSNodePrivateIP,
+			Labels:    t.labels(m.Tags(), false),
 		},
 	}
 }
 
-// datapointsFromMetric returns a []producers.Datapoint for the fields in m, with tags set on all Datapoints.
-// Datapoints are sorted by name for stability.
-func datapointsFromMetric(m telegraf.Metric, tags map[string]string) []producers.Datapoint {
+// labels returns the labels of a message from the tags of its metric. Only tags in LabelTags become labels, unless
+// LabelTags is empty and all is true, in which case all tags become labels.
+func (t *producerTranslator) labels(tags map[string]string, all bool) map[string]string {
+	labels := map[string]string{}
+	if len(t.LabelTags) == 0 {
+		if !all {
+			return nil
+		}
+		for k, v := range tags {
+			labels[k] = v
+		}
+	}
+	for _, k := range t.LabelTags {
+		if v, ok := tags[k]; ok {
+			labels[k] = v
+		}
+	}
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
+
+// unitFunc returns a function which returns the unit of the datapoint for each field of m. If unit, from the unit
+// tag of m, is set, it is the unit of every field. Otherwise, the unit is found from the longest suffix in
+// UnitSuffixes which ends the field name or, failing that, the metric name. Fields which count observations have
+// no unit derived from the metric name.
+func (t *producerTranslator) unitFunc(m telegraf.Metric, unit string) func(field string) string {
+	return func(field string) string {
+		if unit != "" {
+			return unit
+		}
+		if u := longestSuffixMatch(t.UnitSuffixes, field); u != "" {
+			return u
+		}
+		if isCountField(m, field) {
+			return ""
+		}
+		return longestSuffixMatch(t.UnitSuffixes, m.Name())
+	}
+}
+
+// isCountField returns whether a field of m counts observations, that is the count of a summary or histogram, or
+// the cumulative count of a histogram bucket. The sum of a summary or histogram, and its quantiles, are in the unit
+// of the observations.
+func isCountField(m telegraf.Metric, field string) bool {
+	if field == "count" {
+		return true
+	}
+	return m.Type() == telegraf.Histogram && field != "sum"
+}
+
+// longestSuffixMatch returns the value in suffixes of the longest key which is a suffix of name, or an empty string
+// if there is none.
+func longestSuffixMatch(suffixes map[string]string, name string) string {
+	var match, value string
+	for suffix, v := range suffixes {
+		if strings.HasSuffix(name, suffix) && len(suffix) > len(match) {
+			match, value = suffix, v
+		}
+	}
+	return value
+}
+
+// datapointsFromMetric returns a []producers.Datapoint for the fields in m, with tags set on all Datapoints and the
+// unit of each Datapoint returned by unit. Datapoints are sorted by name for stability.
+func datapointsFromMetric(m telegraf.Metric, tags map[string]string, unit func(field string) string) []producers.Datapoint {
 	fields := m.Fields()
 	timestamp := timestampFromMetric(m)
 
@@ -457,6 +544,7 @@ func datapointsFromMetric(m telegraf.Metric, tags map[string]string) []producers
 
 		datapoints[i] = producers.Datapoint{
 			Name:      name,
+			Unit:      unit(fn),
 			Value:     datapointValueFromFieldValue(fields[fn]),
 			Timestamp: timestamp,
 			Tags:      tags,
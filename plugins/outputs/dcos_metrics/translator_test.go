@@ -564,6 +564,7 @@ func TestTranslate(t *testing.T) {
 					ContainerID:   "cid",
 					FrameworkName: "sname",
 					TaskName:      "tname",
+					Labels:        map[string]string{"label_name": "label_value"},
 				},
 				Datapoints: []producers.Datapoint{
 					{
@@ -610,6 +611,7 @@ func TestTranslate(t *testing.T) {
 					ContainerID:   "cid",
 					FrameworkName: "sname",
 					TaskName:      "tname",
+					Labels:        map[string]string{"label_name": "label_value"},
 				},
 				Datapoints: []producers.Datapoint{
 					{
@@ -737,6 +739,7 @@ func TestTranslate(t *testing.T) {
 					ContainerID:   "cid",
 					FrameworkName: "sname",
 					TaskName:      "tname",
+					Labels:        map[string]string{"label_name": "label_value"},
 				},
 				Datapoints: []producers.Datapoint{
 					{
@@ -780,6 +783,244 @@ func TestTranslate(t *testing.T) {
 	}
 }
 
+func TestTranslateLabelsAndUnits(t *testing.T) {
+	type testCase struct {
+		name   string
+		input  metricParams
+		output producers.MetricsMessage
+	}
+
+	lt := producerTranslator{
+		MesosID:           "mesos_id",
+		DCOSNodeRole:      "agent",
+		DCOSClusterID:     "cluster_id",
+		DCOSNodePrivateIP: "10.0.0.1",
+		LabelTags:         []string{"label_name", "region"},
+		UnitSuffixes:      map[string]string{"_bytes": "bytes", "_seconds": "seconds", "_milliseconds": "milliseconds"},
+	}
+
+	testCases := []testCase{
+		{
+			name: "app metric with unit tag",
+			input: metricParams{
+				name: "foo",
+				tags: map[string]string{
+					"container_id": "cid",
+					"task_name":    "tname",
+					"metric_type":  "timing",
+					"unit":         "milliseconds",
+					"label_name":   "label_value",
+					"other_tag":    "other_value",
+				},
+				fields: map[string]interface{}{
+					"mean": 1.5,
+				},
+				tm: tm,
+				tp: telegraf.Untyped,
+			},
+			output: producers.MetricsMessage{
+				Name: "dcos.metrics.app",
+				Dimensions: producers.Dimensions{
+					MesosID:     lt.MesosID,
+					ClusterID:   lt.DCOSClusterID,
+					Hostname:    lt.DCOSNodePrivateIP,
+					ContainerID: "cid",
+					TaskName:    "tname",
+					Labels:      map[string]string{"label_name": "label_value"},
+				},
+				Datapoints: []producers.Datapoint{
+					{
+						Name:      "foo.mean",
+						Unit:      "milliseconds",
+						Value:     1.5,
+						Timestamp: timestamp,
+						Tags:      map[string]string{"label_name": "label_value", "other_tag": "other_value"},
+					},
+				},
+			},
+		},
+
+		{
+			name: "app metric with unit suffixes",
+			input: metricParams{
+				name: "request_seconds",
+				tags: map[string]string{
+					"container_id": "cid",
+					"url":          "http://example.com",
+				},
+				fields: map[string]interface{}{
+					"count":          uint64(3),
+					"response_bytes": uint64(1024),
+				},
+				tm: tm,
+				tp: telegraf.Untyped,
+			},
+			output: producers.MetricsMessage{
+				Name: "dcos.metrics.app",
+				Dimensions: producers.Dimensions{
+					MesosID:     lt.MesosID,
+					ClusterID:   lt.DCOSClusterID,
+					Hostname:    lt.DCOSNodePrivateIP,
+					ContainerID: "cid",
+				},
+				Datapoints: []producers.Datapoint{
+					{
+						Name:      "request_seconds.count",
+						Unit:      "",
+						Value:     uint64(3),
+						Timestamp: timestamp,
+						Tags:      map[string]string{"url": "http://example.com"},
+					},
+					{
+						Name:      "request_seconds.response_bytes",
+						Unit:      "bytes",
+						Value:     uint64(1024),
+						Timestamp: timestamp,
+						Tags:      map[string]string{"url": "http://example.com"},
+					},
+				},
+			},
+		},
+
+		{
+			name: "app histogram with unit suffix",
+			input: metricParams{
+				name: "request_seconds",
+				tags: map[string]string{
+					"container_id": "cid",
+					"url":          "http://example.com",
+				},
+				fields: map[string]interface{}{
+					"0.5":   uint64(2),
+					"count": uint64(3),
+					"sum":   1.2,
+				},
+				tm: tm,
+				tp: telegraf.Histogram,
+			},
+			output: producers.MetricsMessage{
+				Name: "dcos.metrics.app",
+				Dimensions: producers.Dimensions{
+					MesosID:     lt.MesosID,
+					ClusterID:   lt.DCOSClusterID,
+					Hostname:    lt.DCOSNodePrivateIP,
+					ContainerID: "cid",
+				},
+				Datapoints: []producers.Datapoint{
+					{
+						Name:      "request_seconds.0.5",
+						Unit:      "",
+						Value:     uint64(2),
+						Timestamp: timestamp,
+						Tags:      map[string]string{"url": "http://example.com"},
+					},
+					{
+						Name:      "request_seconds.count",
+						Unit:      "",
+						Value:     uint64(3),
+						Timestamp: timestamp,
+						Tags:      map[string]string{"url": "http://example.com"},
+					},
+					{
+						Name:      "request_seconds.sum",
+						Unit:      "seconds",
+						Value:     1.2,
+						Timestamp: timestamp,
+						Tags:      map[string]string{"url": "http://example.com"},
+					},
+				},
+			},
+		},
+
+		{
+			name: "container metric with allowed labels",
+			input: metricParams{
+				name: "mem",
+				tags: map[string]string{
+					"container_id": "cid",
+					"label_name":   "label_value",
+					"other_tag":    "other_value",
+				},
+				fields: map[string]interface{}{
+					"total_bytes": uint64(100),
+				},
+				tm: tm,
+				tp: telegraf.Untyped,
+			},
+			output: producers.MetricsMessage{
+				Name: "dcos.metrics.container",
+				Dimensions: producers.Dimensions{
+					MesosID:     lt.MesosID,
+					ClusterID:   lt.DCOSClusterID,
+					Hostname:    lt.DCOSNodePrivateIP,
+					ContainerID: "cid",
+					Labels:      map[string]string{"label_name": "label_value"},
+				},
+				Datapoints: []producers.Datapoint{
+					{
+						Name:      "mem.total_bytes",
+						Unit:      "bytes",
+						Value:     uint64(100),
+						Timestamp: timestamp,
+						Tags:      map[string]string{"container_id": "cid"},
+					},
+				},
+			},
+		},
+
+		{
+			name: "node metric with allowed labels",
+			input: metricParams{
+				name: "processes",
+				tags: map[string]string{
+					"region": "us-east-1",
+					"host":   "node1",
+				},
+				fields: map[string]interface{}{
+					"total": uint64(42),
+				},
+				tm: tm,
+				tp: telegraf.Untyped,
+			},
+			output: producers.MetricsMessage{
+				Name: "dcos.metrics.node",
+				Dimensions: producers.Dimensions{
+					MesosID:   lt.MesosID,
+					ClusterID: lt.DCOSClusterID,
+					Hostname:  lt.DCOSNodePrivateIP,
+					Labels:    map[string]string{"region": "us-east-1"},
+				},
+				Datapoints: []producers.Datapoint{
+					{
+						Name:      "process.count",
+						Unit:      "count",
+						Value:     uint64(42),
+						Timestamp: timestamp,
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, ok, err := lt.Translate(tc.input.NewMetric(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Fatal("translation failed to produce a MetricsMessage")
+			}
+			msg.Timestamp = 0
+			if !reflect.DeepEqual(msg, tc.output) {
+				t.Log("expected:", tc.output)
+				t.Log("actually:", msg)
+				t.Fatal("translation returned an unexpected MetricsMessage")
+			}
+		})
+	}
+}
+
 func TestTranslateFail(t *testing.T) {
 	type testCase struct {
 		name  string
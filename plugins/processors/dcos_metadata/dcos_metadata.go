@@ -2,37 +2,70 @@ package dcos_metadata
 
 import (
 	"context"
-	"errors"
-	"fmt"
-	"io"
 	"log"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/dcosutil"
+	"github.com/influxdata/telegraf/filter"
 	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/plugins/processors"
+	"github.com/influxdata/telegraf/selfstat"
 
 	"github.com/mesos/mesos-go/api/v1/lib"
 	"github.com/mesos/mesos-go/api/v1/lib/agent"
-	"github.com/mesos/mesos-go/api/v1/lib/agent/calls"
 	"github.com/mesos/mesos-go/api/v1/lib/httpcli"
-	"github.com/mesos/mesos-go/api/v1/lib/httpcli/httpagent"
 )
 
 type DCOSMetadata struct {
 	MesosAgentUrl              string
+	WatchInterval              internal.Duration
+	EvictionGracePeriod        internal.Duration
 	Timeout                    internal.Duration
 	RateLimit                  internal.Duration
 	Whitelist, WhitelistPrefix []string
 	UserAgent                  string
+	HoldTimeout                internal.Duration
+	HoldLimit                  int
+	StateFile                  string
+	StateTTL                   internal.Duration `toml:"state_ttl"`
+	TaskResources              bool
+	ResourceMetrics            []string
+	MarathonTags               bool
+	MarathonFrameworks         []string
+	FaultDomainTags            bool
+	RoleTag                    bool
+	resourceFilter             filter.Filter
+	agent                      *agentInfo
+	initialized                bool
 	containers                 map[string]containerInfo
+	held                       []heldMetric
+	evictions                  map[string]time.Time
+	restored                   map[string]time.Time
+	stateMu                    sync.Mutex
+	savedAt                    time.Time
+	cancel                     context.CancelFunc
+	watching                   chan struct{}
+	stopped                    bool
 	mu                         sync.Mutex
 	once                       Once
+	clientMu                   sync.Mutex
 	client                     *httpcli.Client
 	dcosutil.DCOSConfig
+
+	HeldMetrics   selfstat.Stat
+	HoldTimeouts  selfstat.Stat
+	HoldOverflows selfstat.Stat
+}
+
+// heldMetric is a metric whose container was not found in the cache, which
+// is held back until metadata arrives or its deadline passes.
+type heldMetric struct {
+	metric   telegraf.Metric
+	deadline time.Time
 }
 
 // containerInfo is a tuple of metadata which we use to map a container ID to
@@ -43,11 +76,27 @@ type containerInfo struct {
 	executorName  string
 	frameworkName string
 	taskLabels    map[string]string
+	// The following are only set for the task's own container
+	appID     string
+	podName   string
+	role      string
+	resources taskResources
 }
 
+// missingMetadataTag is added to held metrics which were released untagged
+// because their metadata did not arrive before the hold timeout
+const missingMetadataTag = "dcos_metadata_missing"
+
 const sampleConfig = `
 	## The URL of the local mesos agent
 	mesos_agent_url = "http://$NODE_PRIVATE_IP:5051"
+	## The period between requests for the state of the mesos agent, which
+	## keep the container cache up to date. When unset, the state is only
+	## requested when metrics from unrecognised containers arrive.
+	# watch_interval = "10s"
+	## The period for which metadata of terminated containers is retained
+	## when watch_interval is set
+	eviction_grace_period = "5m"
 	## The period after which requests to mesos agent should time out
 	timeout = "10s"
 	## The minimum period between requests to the mesos agent
@@ -60,6 +109,31 @@ const sampleConfig = `
 	whitelist_prefix = []
   	## The user agent to send with requests
 	user_agent = "Telegraf-dcos-metadata"
+	## The maximum period for which metrics from unrecognised containers are
+	## held back while metadata is retrieved; metrics which time out are
+	## released with a dcos_metadata_missing tag. 0 disables holding.
+	hold_timeout = "0s"
+	## The maximum number of metrics to hold back; further metrics from
+	## unrecognised containers are passed through untagged
+	hold_limit = 10000
+	## The file in which container metadata is saved, so that it is available
+	## immediately after a restart. Leave unset to disable.
+	# state_file = "/run/dcos/telegraf/dcos_metadata/state.json"
+	## The period after which saved container metadata which could not be
+	## confirmed by mesos is discarded
+	state_ttl = "1h"
+	## Add the task's allocated cpus, memory and disk as fields
+	task_resources = false
+	## Names of metrics which receive task resource fields; globs are supported
+	resource_metrics = ["container"]
+	## Add marathon_app_id or pod_name tags to tasks launched by marathon
+	marathon_tags = false
+	## Names of the frameworks whose tasks are launched by marathon
+	marathon_frameworks = ["marathon"]
+	## Add region and zone tags from the agent's fault domain
+	fault_domain_tags = false
+	## Add a role tag with the role of the task's allocated resources
+	role_tag = false
 	## Optional IAM configuration
 	# ca_certificate_path = "/run/dcos/pki/CA/ca-bundle.crt"
 	# iam_config_path = "/run/dcos/etc/dcos-telegraf/service_account.json"
@@ -77,33 +151,66 @@ func (dm *DCOSMetadata) Description() string {
 
 // Apply the filter to the given metrics
 func (dm *DCOSMetadata) Apply(in ...telegraf.Metric) []telegraf.Metric {
-	// stale tracks whether our container cache is stale
-	stale := false
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	if !dm.initialized {
+		dm.initialize()
+	}
+
+	if dm.WatchInterval.Duration > 0 && dm.cancel == nil && !dm.stopped {
+		var ctx context.Context
+		ctx, dm.cancel = context.WithCancel(context.Background())
+		dm.watching = make(chan struct{})
+		go func() {
+			defer close(dm.watching)
+			dm.watch(ctx)
+		}()
+	}
+	dm.evict(time.Now())
+	dm.expireRestored(time.Now())
+
+	// release any held metrics whose metadata has arrived or whose deadline
+	// has passed before handling new metrics
+	out := dm.release(time.Now())
 
 	// track unrecognised container ids
 	nonCachedIDs := map[string]bool{}
 
 	for _, metric := range in {
 		// Ignore metrics without container_id tag
-		if cid, ok := metric.Tags()["container_id"]; ok {
-			if c, ok := dm.containers[cid]; ok {
-				// Data for this container was cached
-				for k, v := range c.taskLabels {
-					metric.AddTag(k, v)
-				}
-				metric.AddTag("service_name", c.frameworkName)
-				if c.executorName != "" {
-					metric.AddTag("executor_name", c.executorName)
-				}
-				metric.AddTag("task_name", c.taskName)
-			} else {
-				nonCachedIDs[cid] = true
-				stale = true
-			}
+		cid, ok := metric.Tags()["container_id"]
+		if !ok {
+			out = append(out, metric)
+			continue
 		}
+		if c, ok := dm.containers[cid]; ok {
+			// Data for this container was cached
+			dm.addTags(metric, c)
+			out = append(out, metric)
+			continue
+		}
+
+		nonCachedIDs[cid] = true
+		if dm.HoldTimeout.Duration <= 0 {
+			out = append(out, metric)
+			continue
+		}
+		if len(dm.held) >= dm.HoldLimit {
+			dm.HoldOverflows.Incr(1)
+			out = append(out, metric)
+			continue
+		}
+		dm.held = append(dm.held, heldMetric{
+			metric:   metric,
+			deadline: time.Now().Add(dm.HoldTimeout.Duration),
+		})
 	}
+	dm.HeldMetrics.Set(int64(len(dm.held)))
 
-	if stale {
+	// the container cache is stale if any container ids were unrecognised or
+	// it was restored from disk and not yet reconciled with mesos
+	if len(nonCachedIDs) > 0 || len(dm.restored) > 0 {
 		cids := []string{}
 		for cid := range nonCachedIDs {
 			cids = append(cids, cid)
@@ -111,17 +218,159 @@ func (dm *DCOSMetadata) Apply(in ...telegraf.Metric) []telegraf.Metric {
 		go dm.refresh(cids...)
 	}
 
-	return in
+	return out
+}
+
+// Release returns the held metrics whose metadata has arrived or whose
+// deadline has passed
+func (dm *DCOSMetadata) Release() []telegraf.Metric {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	// nothing is held before the first call to Apply
+	if !dm.initialized {
+		return nil
+	}
+
+	out := dm.release(time.Now())
+	dm.HeldMetrics.Set(int64(len(dm.held)))
+	return out
+}
+
+// Flush returns all held metrics, tagging those whose metadata has not
+// arrived as missing it
+func (dm *DCOSMetadata) Flush() []telegraf.Metric {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	// nothing is held before the first call to Apply
+	if !dm.initialized {
+		return nil
+	}
+
+	out := dm.release(time.Now())
+	for _, h := range dm.held {
+		dm.HoldTimeouts.Incr(1)
+		h.metric.AddTag(missingMetadataTag, "true")
+		out = append(out, h.metric)
+	}
+	dm.held = nil
+	dm.HeldMetrics.Set(0)
+	return out
+}
+
+// Stop ends the watch of the mesos agent's state, if any, and waits for it to
+// return. Held metrics are flushed by the agent before it stops the
+// processor.
+func (dm *DCOSMetadata) Stop() {
+	dm.mu.Lock()
+	dm.stopped = true
+	cancel, watching := dm.cancel, dm.watching
+	dm.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-watching
+	}
+}
+
+// release removes held metrics from the queue and returns them if their
+// container is now cached, or if their deadline has passed. It must be called
+// while dm.mu is held.
+func (dm *DCOSMetadata) release(now time.Time) []telegraf.Metric {
+	out := []telegraf.Metric{}
+	remaining := dm.held[:0]
+	for _, h := range dm.held {
+		cid := h.metric.Tags()["container_id"]
+		if c, ok := dm.containers[cid]; ok {
+			dm.addTags(h.metric, c)
+			out = append(out, h.metric)
+		} else if !now.Before(h.deadline) {
+			dm.HoldTimeouts.Incr(1)
+			h.metric.AddTag(missingMetadataTag, "true")
+			out = append(out, h.metric)
+		} else {
+			remaining = append(remaining, h)
+		}
+	}
+	// clear references to released metrics from the backing array
+	for i := len(remaining); i < len(dm.held); i++ {
+		dm.held[i] = heldMetric{}
+	}
+	dm.held = remaining
+	return out
+}
+
+// initialize registers internal statistics and restores the container cache
+// from disk. It must be called while dm.mu is held.
+func (dm *DCOSMetadata) initialize() {
+	dm.registerStats()
+	if dm.TaskResources {
+		f, err := filter.Compile(dm.ResourceMetrics)
+		if err != nil {
+			log.Printf("E! Could not compile resource_metrics: %s", err)
+		}
+		dm.resourceFilter = f
+	}
+	if dm.StateFile != "" {
+		if err := dm.loadState(time.Now()); err != nil {
+			log.Printf("E! Could not load container metadata from %s: %s", dm.StateFile, err)
+		}
+	}
+	dm.initialized = true
+}
+
+// registerStats registers the internal statistics reported by dcos_metadata
+func (dm *DCOSMetadata) registerStats() {
+	tags := map[string]string{
+		"mesos_agent_url": dm.MesosAgentUrl,
+	}
+	dm.HeldMetrics = selfstat.Register("dcos_metadata", "held_metrics", tags)
+	dm.HoldTimeouts = selfstat.Register("dcos_metadata", "hold_timeouts", tags)
+	dm.HoldOverflows = selfstat.Register("dcos_metadata", "hold_overflows", tags)
+}
+
+// addTags decorates a metric with the cached metadata of its container. It
+// must be called while dm.mu is held.
+func (dm *DCOSMetadata) addTags(metric telegraf.Metric, c containerInfo) {
+	for k, v := range c.taskLabels {
+		metric.AddTag(k, v)
+	}
+	metric.AddTag("service_name", c.frameworkName)
+	if c.executorName != "" {
+		metric.AddTag("executor_name", c.executorName)
+	}
+	metric.AddTag("task_name", c.taskName)
+
+	if dm.MarathonTags {
+		if c.appID != "" {
+			metric.AddTag("marathon_app_id", c.appID)
+		}
+		if c.podName != "" {
+			metric.AddTag("pod_name", c.podName)
+		}
+	}
+	if dm.RoleTag && c.role != "" {
+		metric.AddTag("role", c.role)
+	}
+	if dm.FaultDomainTags && dm.agent != nil {
+		if dm.agent.region != "" {
+			metric.AddTag("region", dm.agent.region)
+		}
+		if dm.agent.zone != "" {
+			metric.AddTag("zone", dm.agent.zone)
+		}
+	}
+	if dm.TaskResources && dm.resourceFilter != nil && dm.resourceFilter.Match(metric.Name()) {
+		c.resources.addFields(metric)
+	}
 }
 
 // refresh triggers a call to Mesos state. Calls to refresh are throttled by
 // the rate_limit option in configuration. Optionally, the container IDs which
 // caused the refresh may be passed in to be logged.
 func (dm *DCOSMetadata) refresh(cids ...string) {
-	whitelistMap := map[string]bool{}
-	for _, label := range dm.Whitelist {
-		whitelistMap[label] = true
-	}
+	whitelistMap := dm.whitelistMap()
 
 	dm.once.Do(func() {
 		// Subsequent calls to refresh() will be ignored until the RateLimit period
@@ -141,94 +390,134 @@ func (dm *DCOSMetadata) refresh(cids ...string) {
 			return
 		}
 
-		cli := httpagent.NewSender(client.Send)
 		ctx, cancel := context.WithTimeout(context.Background(), dm.Timeout.Duration)
 		defer cancel()
 
-		state, err := dm.getState(ctx, cli)
+		state, err := dcosutil.GetAgentState(ctx, client)
 		if err != nil {
 			log.Printf("E! %s", err)
 			return
 		}
-		err = dm.cache(state, whitelistMap)
-		if err != nil {
-			log.Printf("E! %s", err)
-		}
+		dm.update(ctx, state, whitelistMap)
 	})
 }
 
-// getState requests state from the operator API
-func (dm *DCOSMetadata) getState(ctx context.Context, cli calls.Sender) (*agent.Response_GetState,
-	error) {
-	resp, err := cli.Send(ctx, calls.NonStreaming(calls.GetState()))
-	if err != nil {
-		return nil, err
-	}
-	r, err := processResponse(resp, agent.Response_GET_STATE)
-	if err != nil {
-		return nil, err
+// update caches container info from a state of the mesos agent, and saves
+// the cache if it changed
+func (dm *DCOSMetadata) update(ctx context.Context, gs *agent.Response_GetState,
+	whitelist map[string]bool) {
+	// the fault domain is not part of the agent's state, so it is requested
+	// separately, once
+	if dm.FaultDomainTags && !dm.hasAgent() {
+		ai, err := dm.getAgent(ctx)
+		if err != nil {
+			log.Printf("E! %s", err)
+		} else {
+			dm.setAgent(ai)
+		}
 	}
 
-	gs := r.GetGetState()
-	if gs == nil {
-		return nil, errors.New("the getState response from the mesos agent was empty")
+	changed, err := dm.cache(gs, whitelist)
+	if err != nil {
+		log.Printf("E! %s", err)
+		return
 	}
-	return gs, nil
+	dm.persist(changed)
 }
 
-// cache caches container info from state
+// cache caches container info from state, and returns whether the cache
+// changed
 func (dm *DCOSMetadata) cache(gs *agent.Response_GetState,
-	whitelist map[string]bool) error {
+	whitelist map[string]bool) (bool, error) {
 	dm.mu.Lock()
 	defer dm.mu.Unlock()
 
-	containers := map[string]containerInfo{}
+	// the cache is now reconciled with mesos
+	changed := len(dm.restored) > 0
 
-	gt := gs.GetGetTasks()
-	if gt == nil { // no tasks are running on the cluster
-		dm.containers = containers
-		return nil
-	}
+	containers := map[string]containerInfo{}
 
-	// map frameworks and executors in advance to avoid iterating
-	// over both for each container
-	frameworkNames := mapFrameworkNames(gs.GetGetFrameworks())
-	executorNames := mapExecutorNames(gs.GetGetExecutors())
+	// gt is nil when no tasks are running on the agent
+	if gt := gs.GetGetTasks(); gt != nil {
+		// map frameworks and executors in advance to avoid iterating
+		// over both for each container
+		frameworkNames := mapFrameworkNames(gs.GetGetFrameworks())
+		executorNames := mapExecutorNames(gs.GetGetExecutors())
 
-	for _, t := range gt.GetLaunchedTasks() {
-		cid, pcid := getContainerIDs(t.GetStatuses())
-		eName := ""
-		// ExecutorID is _not_ guaranteed not to be nil (FrameworkID is)
-		if eid := t.GetExecutorID(); eid != nil {
-			eName = executorNames[eid.Value]
-		}
-
-		// If container ID could not be found, don't add a nil entry
-		if cid != "" {
-			containers[cid] = containerInfo{
-				containerID:   cid,
-				taskName:      t.GetName(),
-				executorName:  eName,
-				frameworkName: frameworkNames[t.GetFrameworkID().Value],
-				taskLabels:    mapTaskLabels(t.GetLabels(), whitelist, dm.WhitelistPrefix),
-			}
-		}
-		if pcid != "" {
-			containers[pcid] = containerInfo{
-				containerID:   pcid,
-				executorName:  eName,
-				frameworkName: frameworkNames[t.GetFrameworkID().Value],
+		for _, t := range gt.GetLaunchedTasks() {
+			for _, c := range dm.taskContainers(t, frameworkNames, executorNames, whitelist) {
+				containers[c.containerID] = c
 			}
 		}
 	}
 
+	// while watching the agent, containers which are no longer running are
+	// evicted once their grace period has passed
+	if dm.WatchInterval.Duration > 0 {
+		dm.retain(containers, time.Now())
+	}
+	dm.restored = nil
+
+	changed = changed || (len(dm.containers)+len(containers) > 0 && !reflect.DeepEqual(dm.containers, containers))
 	dm.containers = containers
-	return nil
+	return changed, nil
+}
+
+// taskContainers returns container info for the container of a task and, if
+// the task is nested, the container of its executor
+func (dm *DCOSMetadata) taskContainers(t mesos.Task, frameworkNames,
+	executorNames map[string]string, whitelist map[string]bool) []containerInfo {
+	results := []containerInfo{}
+
+	cid, pcid := getContainerIDs(t.GetStatuses())
+	eName := ""
+	// ExecutorID is _not_ guaranteed not to be nil (FrameworkID is)
+	if eid := t.GetExecutorID(); eid != nil {
+		eName = executorNames[eid.Value]
+	}
+
+	// If container ID could not be found, don't add a nil entry
+	if cid != "" {
+		appID, podName := dm.marathonIDs(t, pcid != "", frameworkNames)
+		results = append(results, containerInfo{
+			containerID:   cid,
+			taskName:      t.GetName(),
+			executorName:  eName,
+			frameworkName: frameworkNames[t.GetFrameworkID().Value],
+			taskLabels:    mapTaskLabels(t.GetLabels(), whitelist, dm.WhitelistPrefix),
+			appID:         appID,
+			podName:       podName,
+			role:          taskRole(t.GetResources()),
+			resources:     sumTaskResources(t.GetResources()),
+		})
+	}
+	if pcid != "" {
+		// the executor's container belongs to the same pod as the task
+		_, podName := dm.marathonIDs(t, true, frameworkNames)
+		results = append(results, containerInfo{
+			containerID:   pcid,
+			executorName:  eName,
+			frameworkName: frameworkNames[t.GetFrameworkID().Value],
+			podName:       podName,
+		})
+	}
+	return results
+}
+
+// whitelistMap returns the whitelisted labels as a set
+func (dm *DCOSMetadata) whitelistMap() map[string]bool {
+	whitelist := map[string]bool{}
+	for _, label := range dm.Whitelist {
+		whitelist[label] = true
+	}
+	return whitelist
 }
 
 // getClient returns the *httpcli.Client configured to make requests to Mesos that is a member of dm. If it hasn't been
 // created yet, it is created and then returned.
 func (dm *DCOSMetadata) getClient() (*httpcli.Client, error) {
+	dm.clientMu.Lock()
+	defer dm.clientMu.Unlock()
 	if dm.client == nil {
 		client, err := dcosutil.MesosClient(dm.MesosAgentUrl, dm.DCOSConfig)
 		if err != nil {
@@ -315,36 +604,17 @@ func mapTaskLabels(labels *mesos.Labels, whitelisted map[string]bool,
 	return results
 }
 
-// processResponse reads the response from a triggered request, verifies its
-// type, and returns an agent response
-func processResponse(resp mesos.Response, t agent.Response_Type) (agent.Response, error) {
-	var r agent.Response
-	defer func() {
-		if resp != nil {
-			resp.Close()
-		}
-	}()
-	for {
-		if err := resp.Decode(&r); err != nil {
-			if err == io.EOF {
-				break
-			}
-			return r, err
-		}
-	}
-	if r.GetType() == t {
-		return r, nil
-	} else {
-		return r, fmt.Errorf("processResponse expected type %q, got %q", t, r.GetType())
-	}
-}
-
 // init is called once when telegraf starts
 func init() {
 	processors.Add("dcos_metadata", func() telegraf.Processor {
 		return &DCOSMetadata{
-			Timeout:   internal.Duration{Duration: 10 * time.Second},
-			RateLimit: internal.Duration{Duration: 5 * time.Second},
+			Timeout:             internal.Duration{Duration: 10 * time.Second},
+			RateLimit:           internal.Duration{Duration: 5 * time.Second},
+			HoldLimit:           10000,
+			EvictionGracePeriod: internal.Duration{Duration: 5 * time.Minute},
+			ResourceMetrics:     []string{"container"},
+			MarathonFrameworks:  []string{"marathon"},
+			StateTTL:            internal.Duration{Duration: time.Hour},
 		}
 	})
 }
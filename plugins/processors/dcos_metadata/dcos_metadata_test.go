@@ -1,15 +1,25 @@
 package dcos_metadata
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/metric"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/mesos/mesos-go/api/v1/lib"
+	"github.com/mesos/mesos-go/api/v1/lib/agent"
 	"github.com/stretchr/testify/assert"
 )
 
+// fixtureResources are the resources allocated to tasks in the fixtures
+var fixtureResources = taskResources{cpus: 0.1, mem: 128}
+
 type testCase struct {
 	fixture                    string
 	whitelist, whitelistPrefix []string
@@ -57,12 +67,12 @@ var (
 				),
 			},
 			cachedContainers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
-					map[string]string{"FOO": "bar", "BAZ": "qux"}},
+				"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
+					taskLabels: map[string]string{"FOO": "bar", "BAZ": "qux"}},
 			},
 			containers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
-					map[string]string{"FOO": "bar", "BAZ": "qux"}},
+				"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
+					taskLabels: map[string]string{"FOO": "bar", "BAZ": "qux"}},
 			},
 		},
 		// One metric, no cached state; no tags are added but state is updated (no additional whitelisted tags)
@@ -86,9 +96,10 @@ var (
 			cachedContainers: map[string]containerInfo{},
 			// We do expect the cache to be updated when apply is done
 			containers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
+				"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
 					// No whitelist/whitelisted prefixes configured
-					map[string]string{}},
+					taskLabels: map[string]string{},
+					role:       "slave_public", resources: fixtureResources},
 			},
 		},
 		// One metric, no cached state; no tags are added but state is updated (with prefix-whitelisted tags)
@@ -113,9 +124,10 @@ var (
 			cachedContainers: map[string]containerInfo{},
 			// We do expect the cache to be updated when apply is done
 			containers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
+				"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
 					// Ensure that the tags are picked up from state, including whitelisted DCOS_METRICS_-prefixed ones
-					map[string]string{"FOO": "bar", "BAZ": "qux"}},
+					taskLabels: map[string]string{"FOO": "bar", "BAZ": "qux"},
+					role:       "slave_public", resources: fixtureResources},
 			},
 		},
 		// One metric, no cached state; no tags are added but state is updated (with a whitelisted tag,
@@ -141,9 +153,10 @@ var (
 			cachedContainers: map[string]containerInfo{},
 			// We do expect the cache to be updated when apply is done
 			containers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
+				"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
 					// Ensure that the tags are picked up from state, including whitelisted "WHITELISTED_METRIC" tag
-					map[string]string{"WHITELISTED_METRIC": "foobar"}},
+					taskLabels: map[string]string{"WHITELISTED_METRIC": "foobar"},
+					role:       "slave_public", resources: fixtureResources},
 			},
 		},
 		// One metric, no cached state; no tags are added but state is updated (
@@ -170,9 +183,10 @@ var (
 			cachedContainers: map[string]containerInfo{},
 			// We do expect the cache to be updated when apply is done
 			containers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
+				"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
 					// Ensure that the tags are picked up from state, including all whitelisted ones
-					map[string]string{"FOO": "bar", "BAZ": "qux", "WHITELISTED_METRIC": "foobar"}},
+					taskLabels: map[string]string{"FOO": "bar", "BAZ": "qux", "WHITELISTED_METRIC": "foobar"},
+					role:       "slave_public", resources: fixtureResources},
 			},
 		},
 		// One metric without a container ID; nothing to do
@@ -219,10 +233,10 @@ var (
 			// We do expect the cache to be updated when apply is done
 			// Parent container (executor) is fetched along with task
 			containers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
-					map[string]string{}},
-				"xyz123": {"xyz123", "", "executor", "framework",
-					nil},
+				"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
+					taskLabels: map[string]string{},
+					role:       "slave_public", resources: fixtureResources},
+				"xyz123": {containerID: "xyz123", executorName: "executor", frameworkName: "framework"},
 			},
 		},
 		// Fetching a nested container ID; cached
@@ -264,17 +278,15 @@ var (
 				),
 			},
 			cachedContainers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
-					map[string]string{}},
-				"xyz123": {"xyz123", "", "executor", "framework",
-					nil},
+				"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
+					taskLabels: map[string]string{}},
+				"xyz123": {containerID: "xyz123", executorName: "executor", frameworkName: "framework"},
 			},
 			// We do not expect the cache to be updated
 			containers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "executor", "framework",
-					map[string]string{}},
-				"xyz123": {"xyz123", "", "executor", "framework",
-					nil},
+				"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
+					taskLabels: map[string]string{}},
+				"xyz123": {containerID: "xyz123", executorName: "executor", frameworkName: "framework"},
 			},
 		},
 		// No executor;
@@ -301,12 +313,12 @@ var (
 				),
 			},
 			cachedContainers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "", "framework",
-					map[string]string{}},
+				"abc123": {containerID: "abc123", taskName: "task", frameworkName: "framework",
+					taskLabels: map[string]string{}},
 			},
 			containers: map[string]containerInfo{
-				"abc123": {"abc123", "task", "", "framework",
-					map[string]string{}},
+				"abc123": {containerID: "abc123", taskName: "task", frameworkName: "framework",
+					taskLabels: map[string]string{}},
 			},
 		},
 	}
@@ -343,6 +355,424 @@ func TestApply(t *testing.T) {
 	}
 }
 
+func TestApplyHold(t *testing.T) {
+	server := startTestServer(t, "fresh")
+	defer server.Close()
+
+	dm := DCOSMetadata{
+		MesosAgentUrl: server.URL,
+		Timeout:       internal.Duration{Duration: 500 * time.Millisecond},
+		RateLimit:     internal.Duration{Duration: 50 * time.Millisecond},
+		HoldTimeout:   internal.Duration{Duration: 10 * time.Second},
+		HoldLimit:     10,
+		containers:    map[string]containerInfo{},
+	}
+
+	// The metric is held back since its container is not cached
+	outputs := dm.Apply(newMetric("test",
+		map[string]string{"container_id": "abc123"},
+		map[string]interface{}{"value": int64(1)},
+		time.Now(),
+	))
+	assert.Empty(t, outputs)
+
+	waitForContainersToEqual(t, &dm, map[string]containerInfo{
+		"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
+			taskLabels: map[string]string{},
+			role:       "slave_public", resources: fixtureResources},
+	}, 2*time.Second)
+
+	// The held metric is released with tags on the next call to Apply
+	outputs = dm.Apply()
+	assert.Equal(t, 1, len(outputs))
+	assert.Equal(t, map[string]string{
+		"container_id":  "abc123",
+		"service_name":  "framework",
+		"executor_name": "executor",
+		"task_name":     "task",
+	}, outputs[0].Tags())
+	assert.Equal(t, int64(0), dm.HeldMetrics.Get())
+}
+
+func TestApplyHoldTimeout(t *testing.T) {
+	server := startTestServer(t, "empty")
+	defer server.Close()
+
+	dm := DCOSMetadata{
+		MesosAgentUrl: server.URL,
+		Timeout:       internal.Duration{Duration: 500 * time.Millisecond},
+		RateLimit:     internal.Duration{Duration: 50 * time.Millisecond},
+		HoldTimeout:   internal.Duration{Duration: 100 * time.Millisecond},
+		HoldLimit:     1,
+		containers:    map[string]containerInfo{},
+	}
+
+	// The first metric is held back, the second exceeds the hold limit and
+	// passes through untagged
+	outputs := dm.Apply(
+		newMetric("test",
+			map[string]string{"container_id": "abc123"},
+			map[string]interface{}{"value": int64(1)},
+			time.Now(),
+		),
+		newMetric("test",
+			map[string]string{"container_id": "xyz123"},
+			map[string]interface{}{"value": int64(1)},
+			time.Now(),
+		),
+	)
+	assert.Equal(t, 1, len(outputs))
+	assert.Equal(t, map[string]string{"container_id": "xyz123"}, outputs[0].Tags())
+	assert.Equal(t, int64(1), dm.HeldMetrics.Get())
+
+	// The held metric is not released before its deadline
+	assert.Empty(t, dm.Release())
+
+	time.Sleep(200 * time.Millisecond)
+
+	// The held metric is released with a marker tag once its deadline passes
+	outputs = dm.Release()
+	assert.Equal(t, 1, len(outputs))
+	assert.Equal(t, map[string]string{
+		"container_id":          "abc123",
+		"dcos_metadata_missing": "true",
+	}, outputs[0].Tags())
+	assert.Equal(t, int64(0), dm.HeldMetrics.Get())
+}
+
+func TestApplyHoldFlush(t *testing.T) {
+	server := startTestServer(t, "empty")
+	defer server.Close()
+
+	dm := DCOSMetadata{
+		MesosAgentUrl: server.URL,
+		Timeout:       internal.Duration{Duration: 500 * time.Millisecond},
+		RateLimit:     internal.Duration{Duration: 50 * time.Millisecond},
+		HoldTimeout:   internal.Duration{Duration: 10 * time.Second},
+		HoldLimit:     10,
+		containers:    map[string]containerInfo{},
+	}
+	assert.Empty(t, dm.Flush())
+
+	outputs := dm.Apply(newMetric("test",
+		map[string]string{"container_id": "abc123"},
+		map[string]interface{}{"value": int64(1)},
+		time.Now(),
+	))
+	assert.Empty(t, outputs)
+
+	// Flushing releases the held metric before its deadline, with a marker tag
+	outputs = dm.Flush()
+	assert.Equal(t, 1, len(outputs))
+	assert.Equal(t, map[string]string{
+		"container_id":          "abc123",
+		"dcos_metadata_missing": "true",
+	}, outputs[0].Tags())
+	assert.Equal(t, int64(0), dm.HeldMetrics.Get())
+	assert.Empty(t, dm.Flush())
+}
+
+func TestWatch(t *testing.T) {
+	server := testutil.NewMesosAgent(t)
+	defer server.Close()
+
+	setTasks := func(tasks ...mesos.Task) {
+		server.SetState(agent.Response_GetState{
+			GetTasks: &agent.Response_GetTasks{LaunchedTasks: tasks},
+			GetFrameworks: &agent.Response_GetFrameworks{
+				Frameworks: []agent.Response_GetFrameworks_Framework{{
+					FrameworkInfo: mesos.FrameworkInfo{
+						ID:   &mesos.FrameworkID{Value: "framework.id"},
+						Name: "framework",
+						User: "root",
+					},
+				}},
+			},
+			GetExecutors: &agent.Response_GetExecutors{
+				Executors: []agent.Response_GetExecutors_Executor{{
+					ExecutorInfo: mesos.ExecutorInfo{
+						ExecutorID: mesos.ExecutorID{Value: "executor.id"},
+						Name:       "executor",
+					},
+				}},
+			},
+		})
+	}
+	task1 := newTask("task1", "abc123", mesos.TASK_RUNNING)
+	task2 := newTask("task2", "def456", mesos.TASK_RUNNING)
+	setTasks(task1)
+
+	dm := DCOSMetadata{
+		MesosAgentUrl:       server.URL,
+		WatchInterval:       internal.Duration{Duration: 50 * time.Millisecond},
+		Timeout:             internal.Duration{Duration: 500 * time.Millisecond},
+		RateLimit:           internal.Duration{Duration: 50 * time.Millisecond},
+		EvictionGracePeriod: internal.Duration{Duration: 500 * time.Millisecond},
+		containers:          map[string]containerInfo{},
+	}
+	// The agent is watched from the first call to Apply
+	dm.Apply()
+	defer dm.Stop()
+
+	container1 := containerInfo{containerID: "abc123", taskName: "task1", executorName: "executor",
+		frameworkName: "framework", taskLabels: map[string]string{}}
+	container2 := containerInfo{containerID: "def456", taskName: "task2", executorName: "executor",
+		frameworkName: "framework", taskLabels: map[string]string{}}
+	waitForContainersToEqual(t, &dm, map[string]containerInfo{"abc123": container1}, 2*time.Second)
+
+	// The containers of new tasks are cached without further metrics
+	setTasks(task1, task2)
+	waitForContainersToEqual(t, &dm, map[string]containerInfo{
+		"abc123": container1,
+		"def456": container2,
+	}, 2*time.Second)
+
+	// Containers which are no longer running are evicted after the grace
+	// period
+	setTasks(task2)
+	time.Sleep(200 * time.Millisecond)
+	dm.Apply()
+	waitForContainersToEqual(t, &dm, map[string]containerInfo{
+		"abc123": container1,
+		"def456": container2,
+	}, 2*time.Second)
+	time.Sleep(500 * time.Millisecond)
+	dm.Apply()
+	waitForContainersToEqual(t, &dm, map[string]containerInfo{"def456": container2}, 2*time.Second)
+
+	// Stop waits for the watch to return, and it is not restarted
+	dm.Stop()
+	dm.Apply()
+	select {
+	case <-dm.watching:
+	default:
+		t.Fatal("the watch was restarted")
+	}
+}
+
+func TestState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcos_metadata")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	// Save the cache to disk
+	dm := DCOSMetadata{
+		StateFile: path,
+		StateTTL:  internal.Duration{Duration: time.Hour},
+		containers: map[string]containerInfo{
+			"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
+				taskLabels: map[string]string{"FOO": "bar"}},
+		},
+	}
+	assert.Nil(t, dm.saveState())
+
+	// The mesos agent is not available
+	server := startTestServer(t, "fresh")
+	server.Close()
+
+	// The cache is restored from disk on the first call to Apply
+	dm = DCOSMetadata{
+		MesosAgentUrl: server.URL,
+		Timeout:       internal.Duration{Duration: 500 * time.Millisecond},
+		RateLimit:     internal.Duration{Duration: 50 * time.Millisecond},
+		StateFile:     path,
+		StateTTL:      internal.Duration{Duration: time.Hour},
+	}
+	outputs := dm.Apply(newMetric("test",
+		map[string]string{"container_id": "abc123"},
+		map[string]interface{}{"value": int64(1)},
+		time.Now(),
+	))
+	assert.Equal(t, 1, len(outputs))
+	assert.Equal(t, map[string]string{
+		"container_id":  "abc123",
+		"service_name":  "framework",
+		"executor_name": "executor",
+		"task_name":     "task",
+		"FOO":           "bar",
+	}, outputs[0].Tags())
+}
+
+func TestStatePersistUnchanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcos_metadata")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	dm := DCOSMetadata{
+		StateFile:  path,
+		StateTTL:   internal.Duration{Duration: time.Hour},
+		containers: map[string]containerInfo{},
+	}
+	dm.persist(false)
+	_, err = os.Stat(path)
+	assert.Nil(t, err)
+
+	// An unchanged cache is not saved again until half of the TTL has passed
+	assert.Nil(t, os.Remove(path))
+	dm.persist(false)
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	dm.persist(true)
+	_, err = os.Stat(path)
+	assert.Nil(t, err)
+}
+
+func TestStateReconcile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dcos_metadata")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	data := []byte(`{"containers": [
+		{"container_id": "xyz123", "task_name": "old", "last_seen": "` +
+		time.Now().Add(-time.Minute).Format(time.RFC3339) + `"},
+		{"container_id": "def456", "task_name": "expired", "last_seen": "` +
+		time.Now().Add(-2*time.Hour).Format(time.RFC3339) + `"}
+	]}`)
+	assert.Nil(t, ioutil.WriteFile(path, data, 0644))
+
+	server := startTestServer(t, "fresh")
+	defer server.Close()
+
+	dm := DCOSMetadata{
+		MesosAgentUrl: server.URL,
+		Timeout:       internal.Duration{Duration: 500 * time.Millisecond},
+		RateLimit:     internal.Duration{Duration: 50 * time.Millisecond},
+		StateFile:     path,
+		StateTTL:      internal.Duration{Duration: time.Hour},
+	}
+
+	// Expired containers are discarded on load
+	dm.Apply()
+	dm.mu.Lock()
+	_, ok := dm.containers["def456"]
+	dm.mu.Unlock()
+	assert.False(t, ok)
+
+	// Restored containers are replaced once mesos is reachable
+	waitForContainersToEqual(t, &dm, map[string]containerInfo{
+		"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
+			taskLabels: map[string]string{},
+			role:       "slave_public", resources: fixtureResources},
+	}, 2*time.Second)
+
+	// The reconciled cache is written to disk
+	var sf stateFile
+	for i := 0; i < 100; i++ {
+		data, err = ioutil.ReadFile(path)
+		assert.Nil(t, err)
+		assert.Nil(t, json.Unmarshal(data, &sf))
+		if len(sf.Containers) == 1 && sf.Containers[0].ContainerID == "abc123" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 1, len(sf.Containers))
+	assert.Equal(t, "abc123", sf.Containers[0].ContainerID)
+}
+
+func TestEnrich(t *testing.T) {
+	server := startTestServer(t, "fresh")
+	defer server.Close()
+
+	dm := DCOSMetadata{
+		MesosAgentUrl:   server.URL,
+		Timeout:         internal.Duration{Duration: 500 * time.Millisecond},
+		RateLimit:       internal.Duration{Duration: 50 * time.Millisecond},
+		TaskResources:   true,
+		ResourceMetrics: []string{"container"},
+		MarathonTags:    true,
+		FaultDomainTags: true,
+		RoleTag:         true,
+		containers:      map[string]containerInfo{},
+	}
+
+	// The first metric triggers a refresh, which also retrieves the fault
+	// domain of the agent
+	dm.Apply(newMetric("container",
+		map[string]string{"container_id": "abc123"},
+		map[string]interface{}{"processes": uint64(1)},
+		time.Now(),
+	))
+	for i := 0; i < 100 && !dm.hasAgent(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	waitForContainersToEqual(t, &dm, map[string]containerInfo{
+		"abc123": {containerID: "abc123", taskName: "task", executorName: "executor", frameworkName: "framework",
+			taskLabels: map[string]string{},
+			role:       "slave_public", resources: fixtureResources},
+	}, 2*time.Second)
+
+	outputs := dm.Apply(
+		newMetric("container",
+			map[string]string{"container_id": "abc123"},
+			map[string]interface{}{"processes": uint64(1)},
+			time.Now(),
+		),
+		newMetric("cpus",
+			map[string]string{"container_id": "abc123"},
+			map[string]interface{}{"limit": float64(0.1)},
+			time.Now(),
+		),
+	)
+	assert.Equal(t, 2, len(outputs))
+
+	expectedTags := map[string]string{
+		"container_id":  "abc123",
+		"service_name":  "framework",
+		"executor_name": "executor",
+		"task_name":     "task",
+		"role":          "slave_public",
+		"region":        "region",
+		"zone":          "zone",
+	}
+	assert.Equal(t, expectedTags, outputs[0].Tags())
+	assert.Equal(t, map[string]interface{}{
+		"processes":           uint64(1),
+		"allocated_cpus":      float64(0.1),
+		"allocated_mem_bytes": uint64(128 * 1024 * 1024),
+	}, outputs[0].Fields())
+
+	// Resource fields are only added to metrics matching resource_metrics
+	assert.Equal(t, expectedTags, outputs[1].Tags())
+	assert.Equal(t, map[string]interface{}{"limit": float64(0.1)}, outputs[1].Fields())
+}
+
+func TestMarathonIDs(t *testing.T) {
+	dm := DCOSMetadata{MarathonFrameworks: []string{"marathon"}}
+	frameworkNames := map[string]string{
+		"marathon.id":  "marathon",
+		"framework.id": "framework",
+	}
+
+	testCases := []struct {
+		taskID      string
+		frameworkID string
+		nested      bool
+		appID       string
+		podName     string
+	}{
+		{"group_app.instance-8e3b8c4f-6f5c-11e8-9b91-6e1e2b7ee7d6._app.1", "marathon.id", false, "/group/app", ""},
+		{"app.8e3b8c4f-6f5c-11e8-9b91-6e1e2b7ee7d6", "marathon.id", false, "/app", ""},
+		{"group_pod.instance-8e3b8c4f-6f5c-11e8-9b91-6e1e2b7ee7d6.container", "marathon.id", true, "", "/group/pod"},
+		{"app.8e3b8c4f-6f5c-11e8-9b91-6e1e2b7ee7d6", "framework.id", false, "", ""},
+		{"noinstance", "marathon.id", false, "", ""},
+	}
+
+	for _, tc := range testCases {
+		task := mesos.Task{
+			TaskID:      mesos.TaskID{Value: tc.taskID},
+			FrameworkID: mesos.FrameworkID{Value: tc.frameworkID},
+		}
+		appID, podName := dm.marathonIDs(task, tc.nested, frameworkNames)
+		assert.Equal(t, tc.appID, appID, tc.taskID)
+		assert.Equal(t, tc.podName, podName, tc.taskID)
+	}
+}
+
 func TestGetClient(t *testing.T) {
 	dm := DCOSMetadata{}
 	client1, err1 := dm.getClient()
@@ -362,29 +792,52 @@ func newMetric(name string, tags map[string]string, fields map[string]interface{
 	return m
 }
 
-// waitForContainersToEqual waits for the length of the container cache to
-// change and asserts that they equal the expected, or times out
+// newTask returns a task with a status holding the given container ID
+func newTask(name, containerID string, state mesos.TaskState) mesos.Task {
+	return mesos.Task{
+		Name:        name,
+		TaskID:      mesos.TaskID{Value: name},
+		FrameworkID: mesos.FrameworkID{Value: "framework.id"},
+		ExecutorID:  &mesos.ExecutorID{Value: "executor.id"},
+		State:       state.Enum(),
+		Statuses: []mesos.TaskStatus{{
+			TaskID: mesos.TaskID{Value: name},
+			State:  state.Enum(),
+			ContainerStatus: &mesos.ContainerStatus{
+				ContainerID: &mesos.ContainerID{Value: containerID},
+			},
+		}},
+	}
+}
+
+// waitForContainersToEqual waits for the container cache to equal the
+// expected, or times out
 func waitForContainersToEqual(t *testing.T, dm *DCOSMetadata, expected map[string]containerInfo, timeout time.Duration) {
 	done := make(chan bool)
 	go func() {
 		for {
 			// acquiring the lock here avoids triggering the go race detector
 			dm.mu.Lock()
-			if len(dm.containers) == len(expected) {
+			equal := assert.ObjectsAreEqual(expected, dm.containers)
+			dm.mu.Unlock()
+			if equal {
 				done <- true
 				break
 			}
-			dm.mu.Unlock()
 			time.Sleep(10 * time.Millisecond)
 		}
 	}()
 
 	select {
 	case <-done:
+		dm.mu.Lock()
+		defer dm.mu.Unlock()
 		assert.Equal(t, expected, dm.containers)
 		return
 	case <-time.After(timeout):
-		assert.Fail(t, "Timed out waiting for a container update")
+		dm.mu.Lock()
+		defer dm.mu.Unlock()
+		assert.Equal(t, expected, dm.containers, "Timed out waiting for a container update")
 		return
 	}
 }
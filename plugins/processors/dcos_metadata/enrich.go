@@ -0,0 +1,154 @@
+package dcos_metadata
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/dcosutil"
+
+	"github.com/mesos/mesos-go/api/v1/lib"
+	"github.com/mesos/mesos-go/api/v1/lib/agent"
+	"github.com/mesos/mesos-go/api/v1/lib/agent/calls"
+	"github.com/mesos/mesos-go/api/v1/lib/httpcli/httpagent"
+)
+
+// agentInfo holds information about the local mesos agent
+type agentInfo struct {
+	id     string
+	region string
+	zone   string
+}
+
+// taskResources holds the scalar resources allocated to a task
+type taskResources struct {
+	cpus float64
+	// mem and disk are in megabytes, as reported by mesos
+	mem  float64
+	disk float64
+}
+
+// addFields adds the allocated resources to a metric as fields
+func (tr taskResources) addFields(metric telegraf.Metric) {
+	if tr.cpus > 0 {
+		metric.AddField("allocated_cpus", tr.cpus)
+	}
+	if tr.mem > 0 {
+		metric.AddField("allocated_mem_bytes", uint64(tr.mem*1024*1024))
+	}
+	if tr.disk > 0 {
+		metric.AddField("allocated_disk_bytes", uint64(tr.disk*1024*1024))
+	}
+}
+
+// getAgent requests information about the local agent from the operator API
+func (dm *DCOSMetadata) getAgent(ctx context.Context) (agentInfo, error) {
+	client, err := dm.getClient()
+	if err != nil {
+		return agentInfo{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, dm.Timeout.Duration)
+	defer cancel()
+
+	cli := httpagent.NewSender(client.Send)
+	resp, err := cli.Send(ctx, calls.NonStreaming(calls.GetAgent()))
+	if err != nil {
+		return agentInfo{}, err
+	}
+	r, err := dcosutil.ProcessResponse(resp, agent.Response_GET_AGENT)
+	if err != nil {
+		return agentInfo{}, err
+	}
+
+	ai := r.GetGetAgent().GetAgentInfo()
+	result := agentInfo{id: ai.GetID().GetValue()}
+	if result.id == "" {
+		return agentInfo{}, errors.New("the getAgent response from the mesos agent did not include an agent ID")
+	}
+	if d := ai.GetDomain(); d != nil {
+		if fd := d.GetFaultDomain(); fd != nil {
+			result.region = fd.Region.Name
+			result.zone = fd.Zone.Name
+		}
+	}
+	return result, nil
+}
+
+// setAgent records information about the local agent
+func (dm *DCOSMetadata) setAgent(ai agentInfo) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	dm.agent = &ai
+}
+
+// hasAgent returns true if information about the local agent is known
+func (dm *DCOSMetadata) hasAgent() bool {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return dm.agent != nil
+}
+
+// marathonIDs returns the app ID or pod name of a task launched by marathon.
+// Marathon derives task IDs from the app or pod ID, with slashes replaced by
+// underscores, followed by an instance-specific suffix; for example
+// "group_app.instance-<uuid>._app.1", "group_app.<uuid>" or, for a pod
+// container, "group_pod.instance-<uuid>.container".
+func (dm *DCOSMetadata) marathonIDs(t mesos.Task, nested bool,
+	frameworkNames map[string]string) (appID string, podName string) {
+	fName := frameworkNames[t.GetFrameworkID().Value]
+	isMarathon := false
+	for _, name := range dm.MarathonFrameworks {
+		if name == fName {
+			isMarathon = true
+			break
+		}
+	}
+	if !isMarathon {
+		return "", ""
+	}
+
+	tid := t.GetTaskID().Value
+	var prefix string
+	if i := strings.Index(tid, ".instance-"); i > 0 {
+		prefix = tid[:i]
+	} else if i := strings.LastIndex(tid, "."); i > 0 {
+		prefix = tid[:i]
+	} else {
+		return "", ""
+	}
+
+	id := "/" + strings.Replace(prefix, "_", "/", -1)
+	if nested {
+		return "", id
+	}
+	return id, ""
+}
+
+// taskRole returns the role to which a task's resources are allocated
+func taskRole(resources []mesos.Resource) string {
+	for _, r := range resources {
+		if role := r.GetAllocationInfo().GetRole(); role != "" {
+			return role
+		}
+	}
+	return ""
+}
+
+// sumTaskResources sums the cpus, mem and disk resources allocated to a task
+func sumTaskResources(resources []mesos.Resource) taskResources {
+	var tr taskResources
+	for _, r := range resources {
+		v := r.GetScalar().GetValue()
+		switch r.GetName() {
+		case "cpus":
+			tr.cpus += v
+		case "mem":
+			tr.mem += v
+		case "disk":
+			tr.disk += v
+		}
+	}
+	return tr
+}
@@ -13,6 +13,9 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/mesos/mesos-go/api/v1/lib"
+	"github.com/mesos/mesos-go/api/v1/lib/agent"
 )
 
 // raw protobuf request types:
@@ -21,8 +24,12 @@ var (
 	GET_CONTAINERS = []byte{8, 10}
 	GET_STATE      = []byte{8, 9}
 	GET_TASKS      = []byte{8, 13}
+	GET_AGENT      = []byte{8, 20}
 )
 
+// testAgentID is the agent ID returned by the mock mesos agent
+const testAgentID = "agent.id"
+
 // startTestServer starts a server and serves the specified fixture's content
 // at /api/v1
 func startTestServer(t *testing.T, fixture string) *httptest.Server {
@@ -48,6 +55,29 @@ func startTestServer(t *testing.T, fixture string) *httptest.Server {
 			w.Write(tasks)
 			return
 		}
+		if bytes.Equal(body, GET_AGENT) {
+			resp := agent.Response{
+				Type: agent.Response_GET_AGENT,
+				GetAgent: &agent.Response_GetAgent{
+					AgentInfo: &mesos.AgentInfo{
+						ID:       &mesos.AgentID{Value: testAgentID},
+						Hostname: "localhost",
+						Domain: &mesos.DomainInfo{
+							FaultDomain: &mesos.DomainInfo_FaultDomain{
+								Region: mesos.DomainInfo_FaultDomain_RegionInfo{Name: "region"},
+								Zone:   mesos.DomainInfo_FaultDomain_ZoneInfo{Name: "zone"},
+							},
+						},
+					},
+				},
+			}
+			data, err := resp.Marshal()
+			if err != nil {
+				t.Error(err)
+			}
+			w.Write(data)
+			return
+		}
 		t.Errorf("Unknown request to mock-mesos-server: %s", body)
 		return
 	})
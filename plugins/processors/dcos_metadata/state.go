@@ -0,0 +1,182 @@
+package dcos_metadata
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateFile is the on-disk representation of the container cache
+type stateFile struct {
+	Containers []stateFileEntry `json:"containers"`
+	AgentID    string           `json:"agent_id,omitempty"`
+	Region     string           `json:"region,omitempty"`
+	Zone       string           `json:"zone,omitempty"`
+}
+
+// stateFileEntry holds the metadata of a single container, and the time at
+// which it was last confirmed to exist by mesos
+type stateFileEntry struct {
+	ContainerID   string            `json:"container_id"`
+	TaskName      string            `json:"task_name,omitempty"`
+	ExecutorName  string            `json:"executor_name,omitempty"`
+	FrameworkName string            `json:"framework_name,omitempty"`
+	TaskLabels    map[string]string `json:"task_labels,omitempty"`
+	AppID         string            `json:"marathon_app_id,omitempty"`
+	PodName       string            `json:"pod_name,omitempty"`
+	Role          string            `json:"role,omitempty"`
+	Cpus          float64           `json:"cpus,omitempty"`
+	Mem           float64           `json:"mem,omitempty"`
+	Disk          float64           `json:"disk,omitempty"`
+	LastSeen      time.Time         `json:"last_seen"`
+}
+
+// loadState populates the container cache from the state file. Containers
+// which were last seen longer ago than state_ttl are discarded. Restored
+// containers are kept until the cache is reconciled with mesos, or until
+// their TTL expires. It must be called while dm.mu is held.
+func (dm *DCOSMetadata) loadState(now time.Time) error {
+	data, err := ioutil.ReadFile(dm.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var sf stateFile
+	if err := json.Unmarshal(data, &sf); err != nil {
+		return err
+	}
+
+	if dm.containers == nil {
+		dm.containers = map[string]containerInfo{}
+	}
+	dm.restored = map[string]time.Time{}
+	for _, e := range sf.Containers {
+		if now.Sub(e.LastSeen) > dm.StateTTL.Duration {
+			continue
+		}
+		dm.containers[e.ContainerID] = containerInfo{
+			containerID:   e.ContainerID,
+			taskName:      e.TaskName,
+			executorName:  e.ExecutorName,
+			frameworkName: e.FrameworkName,
+			taskLabels:    e.TaskLabels,
+			appID:         e.AppID,
+			podName:       e.PodName,
+			role:          e.Role,
+			resources:     taskResources{cpus: e.Cpus, mem: e.Mem, disk: e.Disk},
+		}
+		dm.restored[e.ContainerID] = e.LastSeen
+	}
+	if sf.AgentID != "" && dm.agent == nil {
+		dm.agent = &agentInfo{id: sf.AgentID, region: sf.Region, zone: sf.Zone}
+	}
+	log.Printf("I! Restored metadata for %d containers from %s", len(dm.restored), dm.StateFile)
+	return nil
+}
+
+// saveState atomically writes the container cache to the state file, by
+// writing a temporary file and renaming it over the state file. Saves are
+// serialized, so that an older snapshot of the cache never replaces a newer
+// one.
+func (dm *DCOSMetadata) saveState() error {
+	dm.stateMu.Lock()
+	defer dm.stateMu.Unlock()
+
+	now := time.Now()
+
+	dm.mu.Lock()
+	sf := stateFile{Containers: []stateFileEntry{}}
+	for cid, c := range dm.containers {
+		lastSeen, ok := dm.restored[cid]
+		if !ok {
+			lastSeen = now
+		}
+		sf.Containers = append(sf.Containers, stateFileEntry{
+			ContainerID:   c.containerID,
+			TaskName:      c.taskName,
+			ExecutorName:  c.executorName,
+			FrameworkName: c.frameworkName,
+			TaskLabels:    c.taskLabels,
+			AppID:         c.appID,
+			PodName:       c.podName,
+			Role:          c.role,
+			Cpus:          c.resources.cpus,
+			Mem:           c.resources.mem,
+			Disk:          c.resources.disk,
+			LastSeen:      lastSeen,
+		})
+	}
+	if dm.agent != nil {
+		sf.AgentID = dm.agent.id
+		sf.Region = dm.agent.region
+		sf.Zone = dm.agent.zone
+	}
+	dm.mu.Unlock()
+
+	data, err := json.Marshal(sf)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dm.StateFile), filepath.Base(dm.StateFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dm.StateFile); err != nil {
+		return err
+	}
+	dm.savedAt = now
+	return nil
+}
+
+// persist saves the container cache to the state file if one is configured,
+// logging any failure. An unchanged cache is only saved once half of
+// state_ttl has passed since the last save, so that the containers it holds
+// are not discarded as stale after a restart.
+func (dm *DCOSMetadata) persist(changed bool) {
+	if dm.StateFile == "" {
+		return
+	}
+	if !changed {
+		dm.stateMu.Lock()
+		fresh := time.Since(dm.savedAt) < dm.StateTTL.Duration/2
+		dm.stateMu.Unlock()
+		if fresh {
+			return
+		}
+	}
+	if err := dm.saveState(); err != nil {
+		log.Printf("E! Could not save container metadata to %s: %s", dm.StateFile, err)
+	}
+}
+
+// expireRestored removes restored containers from the cache once their TTL
+// has expired without mesos confirming that they still exist. It must be
+// called while dm.mu is held.
+func (dm *DCOSMetadata) expireRestored(now time.Time) {
+	for cid, lastSeen := range dm.restored {
+		if now.Sub(lastSeen) > dm.StateTTL.Duration {
+			delete(dm.containers, cid)
+			delete(dm.restored, cid)
+		}
+	}
+}
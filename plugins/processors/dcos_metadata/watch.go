@@ -0,0 +1,72 @@
+package dcos_metadata
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/influxdata/telegraf/dcosutil"
+
+	"github.com/mesos/mesos-go/api/v1/lib/agent"
+)
+
+// watch requests the state of the local mesos agent every watch_interval and
+// keeps the container cache up to date until ctx is cancelled. Containers
+// which are no longer running are evicted from the cache once their grace
+// period has passed.
+func (dm *DCOSMetadata) watch(ctx context.Context) {
+	client, err := dm.getClient()
+	if err != nil {
+		log.Printf("E! %s", err)
+		return
+	}
+
+	whitelist := dm.whitelistMap()
+	dcosutil.WatchAgentState(ctx, client, dm.Timeout.Duration, dm.WatchInterval.Duration,
+		func(gs *agent.Response_GetState, err error) {
+			if err != nil {
+				log.Printf("E! Could not retrieve state from mesos agent %s: %s", dm.MesosAgentUrl, err)
+				return
+			}
+			dm.update(ctx, gs, whitelist)
+		})
+}
+
+// retain keeps containers which are missing from a new state of the agent in
+// the cache until their grace period has passed, and cancels the eviction of
+// those which are running again. Restored containers are not retained. It
+// must be called while dm.mu is held.
+func (dm *DCOSMetadata) retain(containers map[string]containerInfo, now time.Time) {
+	if dm.evictions == nil {
+		dm.evictions = map[string]time.Time{}
+	}
+	for cid, c := range dm.containers {
+		if _, ok := containers[cid]; ok {
+			delete(dm.evictions, cid)
+			continue
+		}
+		if _, ok := dm.restored[cid]; ok {
+			continue
+		}
+		if _, ok := dm.evictions[cid]; !ok {
+			dm.evictions[cid] = now.Add(dm.EvictionGracePeriod.Duration)
+		}
+		containers[cid] = c
+	}
+	for cid := range dm.evictions {
+		if _, ok := containers[cid]; !ok {
+			delete(dm.evictions, cid)
+		}
+	}
+}
+
+// evict removes containers from the cache once their grace period after
+// termination has passed. It must be called while dm.mu is held.
+func (dm *DCOSMetadata) evict(now time.Time) {
+	for cid, deadline := range dm.evictions {
+		if !now.Before(deadline) {
+			delete(dm.containers, cid)
+			delete(dm.evictions, cid)
+		}
+	}
+}
@@ -10,3 +10,27 @@ type Processor interface {
 	// Apply the filter to the given metric.
 	Apply(in ...Metric) []Metric
 }
+
+// StoppingProcessor is a processor which holds resources, such as goroutines
+// or connections, which must be released once it is no longer used.
+type StoppingProcessor interface {
+	Processor
+
+	// Stop releases the resources of the processor. Metrics which were
+	// already being processed may still be applied after Stop, without the
+	// processor acquiring resources again.
+	Stop()
+}
+
+// HoldingProcessor is a processor which may hold metrics back from Apply and
+// emit them later. The agent calls Release periodically, and Flush once no
+// further metrics will be applied.
+type HoldingProcessor interface {
+	Processor
+
+	// Release returns the held metrics which are due to be emitted.
+	Release() []Metric
+
+	// Flush returns all held metrics, which the processor no longer holds.
+	Flush() []Metric
+}
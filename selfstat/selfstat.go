@@ -87,6 +87,16 @@ func RegisterTiming(measurement, field string, tags map[string]string) Stat {
 	})
 }
 
+// Unregister removes all stats registered with the given measurement and tags
+// from the selfstat registry, so that they are no longer returned by
+// Metrics(). It should be called once the subject of the stats, such as a
+// short-lived server, has gone away.
+func Unregister(measurement string, tags map[string]string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	delete(registry.stats, key("internal_"+measurement, tags))
+}
+
 // Metrics returns all registered stats as telegraf metrics.
 func Metrics() []telegraf.Metric {
 	registry.mu.Lock()
@@ -137,6 +137,23 @@ func TestStatKeyConsistency(t *testing.T) {
 	}
 }
 
+func TestUnregister(t *testing.T) {
+	testLock.Lock()
+	defer testCleanup()
+	s1 := Register("test", "test_field1", map[string]string{"test": "foo"})
+	Register("test", "test_field2", map[string]string{"test": "foo"})
+	Register("test", "test_field1", map[string]string{"test": "bar"})
+	s1.Incr(10)
+
+	Unregister("test", map[string]string{"test": "foo"})
+	assert.Len(t, Metrics(), 1)
+
+	// registering the stat again starts it afresh
+	s1 = Register("test", "test_field1", map[string]string{"test": "foo"})
+	assert.Equal(t, int64(0), s1.Get())
+	assert.Len(t, Metrics(), 2)
+}
+
 func TestRegisterMetricsAndVerify(t *testing.T) {
 	testLock.Lock()
 	defer testCleanup()
@@ -0,0 +1,73 @@
+package testutil
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/mesos/mesos-go/api/v1/lib/agent"
+)
+
+// MesosAgent is a mock of the operator API of a mesos agent. It responds to
+// calls at /api/v1 with the response set for the type of the call.
+type MesosAgent struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	responses map[agent.Call_Type]agent.Response
+}
+
+// NewMesosAgent starts a mock mesos agent, which must be closed once the test
+// is done with it. Calls without a response fail the test.
+func NewMesosAgent(t *testing.T) *MesosAgent {
+	m := &MesosAgent{responses: map[agent.Call_Type]agent.Response{}}
+	router := http.NewServeMux()
+	router.HandleFunc("/api/v1", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		var call agent.Call
+		if err := call.Unmarshal(body); err != nil {
+			t.Errorf("Unknown request to mock mesos agent: %s", body)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		m.mu.Lock()
+		resp, ok := m.responses[call.GetType()]
+		m.mu.Unlock()
+		if !ok {
+			t.Errorf("Unexpected %s call to mock mesos agent", call.GetType())
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		data, err := resp.Marshal()
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	})
+	m.Server = httptest.NewServer(router)
+	return m
+}
+
+// SetResponse sets the response to calls of the given type
+func (m *MesosAgent) SetResponse(t agent.Call_Type, resp agent.Response) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.responses[t] = resp
+}
+
+// SetState sets the response to GET_STATE calls
+func (m *MesosAgent) SetState(gs agent.Response_GetState) {
+	m.SetResponse(agent.Call_GET_STATE, agent.Response{
+		Type:     agent.Response_GET_STATE,
+		GetState: &gs,
+	})
+}